@@ -0,0 +1,68 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+)
+
+var (
+	ErrBootCommandName     = errors.New("boot command name must be that of a .service unit")
+	ErrBootCommandEmpty    = errors.New("boot command is empty")
+	ErrBootCommandRelative = errors.New("boot command executable path must be absolute")
+)
+
+// BootCommand is a command run once, on the first boot after provisioning,
+// by the oneshot service of the same name which Ignition writes for it.
+type BootCommand struct {
+	Name    SystemdUnitName `json:"name"    yaml:"name"`
+	Command []string        `json:"command" yaml:"command"`
+}
+
+func (c *BootCommand) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return c.unmarshal(unmarshal)
+}
+
+func (c *BootCommand) UnmarshalJSON(data []byte) error {
+	return c.unmarshal(func(tc interface{}) error {
+		return json.Unmarshal(data, tc)
+	})
+}
+
+type bootCommand BootCommand
+
+func (c *BootCommand) unmarshal(unmarshal func(interface{}) error) error {
+	tc := bootCommand(*c)
+	if err := unmarshal(&tc); err != nil {
+		return err
+	}
+	*c = BootCommand(tc)
+	return c.assertValid()
+}
+
+func (c BootCommand) assertValid() error {
+	if filepath.Ext(string(c.Name)) != ".service" {
+		return ErrBootCommandName
+	}
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return ErrBootCommandEmpty
+	}
+	if !filepath.IsAbs(c.Command[0]) {
+		return ErrBootCommandRelative
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBootCommandAssertValid(t *testing.T) {
+	type in struct {
+		command BootCommand
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{command: BootCommand{Name: "hello.service", Command: []string{"/bin/echo", "hello"}}},
+			out: out{},
+		},
+		{
+			in:  in{command: BootCommand{Name: "hello.timer", Command: []string{"/bin/echo", "hello"}}},
+			out: out{err: ErrBootCommandName},
+		},
+		{
+			in:  in{command: BootCommand{Name: "hello.service"}},
+			out: out{err: ErrBootCommandEmpty},
+		},
+		{
+			in:  in{command: BootCommand{Name: "hello.service", Command: []string{""}}},
+			out: out{err: ErrBootCommandEmpty},
+		},
+		{
+			in:  in{command: BootCommand{Name: "hello.service", Command: []string{"echo", "hello"}}},
+			out: out{err: ErrBootCommandRelative},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.command.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
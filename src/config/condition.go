@@ -0,0 +1,60 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var (
+	ErrConditionEmpty = errors.New("condition must test for a device or a filesystem label")
+)
+
+// Condition restricts the writing of a file or unit to hosts on which every
+// one of its predicates holds, so that one config can serve several hardware
+// variants.
+type Condition struct {
+	DeviceExists DevicePath `json:"deviceExists,omitempty" yaml:"device_exists"`
+	LabelExists  string     `json:"labelExists,omitempty"  yaml:"label_exists"`
+}
+
+func (c *Condition) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return c.unmarshal(unmarshal)
+}
+
+func (c *Condition) UnmarshalJSON(data []byte) error {
+	return c.unmarshal(func(tc interface{}) error {
+		return json.Unmarshal(data, tc)
+	})
+}
+
+type condition Condition
+
+func (c *Condition) unmarshal(unmarshal func(interface{}) error) error {
+	tc := condition(*c)
+	if err := unmarshal(&tc); err != nil {
+		return err
+	}
+	*c = Condition(tc)
+	return c.assertValid()
+}
+
+func (c Condition) assertValid() error {
+	if c.DeviceExists == "" && c.LabelExists == "" {
+		return ErrConditionEmpty
+	}
+	return nil
+}
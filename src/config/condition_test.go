@@ -0,0 +1,64 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestConditionUnmarshalJSON(t *testing.T) {
+	type in struct {
+		data string
+	}
+	type out struct {
+		condition Condition
+		err       error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{data: `{"deviceExists": "/dev/sdb"}`},
+			out: out{condition: Condition{DeviceExists: "/dev/sdb"}},
+		},
+		{
+			in:  in{data: `{"deviceExists": "LABEL=DATA", "labelExists": "SCRATCH"}`},
+			out: out{condition: Condition{DeviceExists: "LABEL=DATA", LabelExists: "SCRATCH"}},
+		},
+		{
+			in:  in{data: `{"deviceExists": "sdb"}`},
+			out: out{condition: Condition{}, err: ErrFilesystemRelativePath},
+		},
+		{
+			in:  in{data: `{}`},
+			out: out{condition: Condition{}, err: ErrConditionEmpty},
+		},
+	}
+
+	for i, test := range tests {
+		var condition Condition
+		err := json.Unmarshal([]byte(test.in.data), &condition)
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+		if err == nil && !reflect.DeepEqual(test.out.condition, condition) {
+			t.Errorf("#%d: bad condition: want %#v, got %#v", i, test.out.condition, condition)
+		}
+	}
+}
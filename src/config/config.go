@@ -15,15 +15,24 @@
 package config
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
 )
 
 type Config struct {
-	Version  int      `json:"ignitionVersion"    yaml:"ignition_version"`
-	Storage  Storage  `json:"storage,omitempty"  yaml:"storage"`
-	Systemd  Systemd  `json:"systemd,omitempty"  yaml:"systemd"`
-	Networkd Networkd `json:"networkd,omitempty" yaml:"networkd"`
+	Version  int            `json:"ignitionVersion"    yaml:"ignition_version"`
+	Ignition Ignition       `json:"ignition,omitempty" yaml:"ignition"`
+	Storage  Storage        `json:"storage,omitempty"  yaml:"storage"`
+	Systemd  Systemd        `json:"systemd,omitempty"  yaml:"systemd"`
+	Networkd Networkd       `json:"networkd,omitempty" yaml:"networkd"`
+	Passwd   Passwd         `json:"passwd,omitempty"   yaml:"passwd"`
+	Modules  []KernelModule `json:"modules,omitempty"  yaml:"modules"`
 }
 
 const (
@@ -31,16 +40,43 @@ const (
 )
 
 var (
-	ErrVersion     = errors.New("incorrect config version")
+	ErrVersion     = errors.New("missing config version")
 	ErrCloudConfig = errors.New("not a config (found coreos-cloudconfig)")
 	ErrScript      = errors.New("not a config (found coreos-cloudinit script)")
 )
 
-func Parse(config []byte) (cfg Config, err error) {
+// VersionError is returned when a config declares a version other than the
+// one this binary understands.
+type VersionError struct {
+	Version int
+}
+
+func (e VersionError) Error() string {
+	return fmt.Sprintf("unsupported config version %d (only version %d is supported)", e.Version, Version)
+}
+
+// UnknownKeyError is returned by ParseStrict when a config contains a
+// top-level key which isn't part of the schema.
+type UnknownKeyError struct {
+	Key string
+}
+
+func (e UnknownKeyError) Error() string {
+	return fmt.Sprintf("unknown config key %q", e.Key)
+}
+
+// Parse parses config, which may be gzip compressed.
+func Parse(config []byte) (Config, error) {
+	config, err := decompress(config)
+	if err != nil {
+		return Config{}, err
+	}
+	return parse(config)
+}
+
+func parse(config []byte) (cfg Config, err error) {
 	if err = json.Unmarshal(config, &cfg); err == nil {
-		if cfg.Version != Version {
-			err = ErrVersion
-		}
+		err = assertVersion(cfg.Version)
 	} else if isCloudConfig(config) {
 		err = ErrCloudConfig
 	} else if isScript(config) {
@@ -48,3 +84,74 @@ func Parse(config []byte) (cfg Config, err error) {
 	}
 	return
 }
+
+// ParseStrict behaves like Parse, but additionally rejects configs containing
+// unknown top-level keys, which are most likely typos or fields from a newer
+// schema.
+func ParseStrict(config []byte) (Config, error) {
+	config, err := decompress(config)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg, err := parse(config)
+	if err != nil {
+		return cfg, err
+	}
+
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(config, &keys); err != nil {
+		return Config{}, err
+	}
+	known := jsonKeys(reflect.TypeOf(cfg))
+	for key := range keys {
+		if !known[key] {
+			return Config{}, UnknownKeyError{Key: key}
+		}
+	}
+	return cfg, nil
+}
+
+// gzipMagic starts every gzip stream, and can't start a JSON document.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompress returns config decompressed if it's gzip compressed, or as is
+// otherwise.
+func decompress(config []byte) ([]byte, error) {
+	if !bytes.HasPrefix(config, gzipMagic) {
+		return config, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress config: %v", err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress config: %v", err)
+	}
+	return data, nil
+}
+
+func assertVersion(version int) error {
+	switch version {
+	case Version:
+		return nil
+	case 0:
+		return ErrVersion
+	default:
+		return VersionError{Version: version}
+	}
+}
+
+// jsonKeys returns the set of JSON object keys t is decoded from.
+func jsonKeys(t reflect.Type) map[string]bool {
+	keys := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		keys[name] = true
+	}
+	return keys
+}
@@ -15,6 +15,8 @@
 package config
 
 import (
+	"bytes"
+	"compress/gzip"
 	"reflect"
 	"testing"
 )
@@ -40,6 +42,14 @@ func TestParse(t *testing.T) {
 			in:  in{config: []byte(`{}`)},
 			out: out{err: ErrVersion},
 		},
+		{
+			in:  in{config: []byte(`{"ignitionVersion": 2}`)},
+			out: out{config: Config{Version: 2}, err: VersionError{Version: 2}},
+		},
+		{
+			in:  in{config: []byte(`{"ignitionVersion": 1, "unknown": true}`)},
+			out: out{config: Config{Version: 1}},
+		},
 		{
 			in:  in{config: []byte(`#cloud-config`)},
 			out: out{err: ErrCloudConfig},
@@ -48,6 +58,14 @@ func TestParse(t *testing.T) {
 			in:  in{config: []byte(`#!/bin/sh`)},
 			out: out{err: ErrScript},
 		},
+		{
+			in:  in{config: gzipped(`{"ignitionVersion": 1}`)},
+			out: out{config: Config{Version: 1}},
+		},
+		{
+			in:  in{config: gzipped(`#cloud-config`)},
+			out: out{err: ErrCloudConfig},
+		},
 	}
 
 	for i, test := range tests {
@@ -60,3 +78,61 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+func TestParseStrict(t *testing.T) {
+	type in struct {
+		config []byte
+	}
+	type out struct {
+		config Config
+		err    error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{config: []byte(`{"ignitionVersion": 1, "storage": {}, "systemd": {}, "networkd": {}, "passwd": {}}`)},
+			out: out{config: Config{Version: 1}},
+		},
+		{
+			in:  in{config: []byte(`{"ignitionVersion": 1, "sytemd": {}}`)},
+			out: out{err: UnknownKeyError{Key: "sytemd"}},
+		},
+		{
+			in:  in{config: []byte(`{"ignitionVersion": 2, "unknown": true}`)},
+			out: out{config: Config{Version: 2}, err: VersionError{Version: 2}},
+		},
+		{
+			in:  in{config: gzipped(`{"ignitionVersion": 1, "sytemd": {}}`)},
+			out: out{err: UnknownKeyError{Key: "sytemd"}},
+		},
+	}
+
+	for i, test := range tests {
+		config, err := ParseStrict(test.in.config)
+		if !reflect.DeepEqual(test.out.config, config) {
+			t.Errorf("#%d: bad config: want %+v, got %+v", i, test.out.config, config)
+		}
+		if test.out.err != err {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
+
+func TestParseCorruptGzip(t *testing.T) {
+	data := gzipped(`{"ignitionVersion": 1}`)
+	if _, err := Parse(data[:len(data)/2]); err == nil {
+		t.Errorf("truncated gzip config parsed without error")
+	}
+}
+
+// gzipped returns s gzip compressed.
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.Bytes()
+}
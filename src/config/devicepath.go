@@ -17,8 +17,12 @@ package config
 import (
 	"encoding/json"
 	"path/filepath"
+	"strings"
 )
 
+// DevicePath is the absolute path of a device node. Filesystems may instead
+// be referenced by the label or uuid of their existing filesystem, as in
+// LABEL=ROOT or UUID=<uuid>, to be resolved once the devices have appeared.
 type DevicePath string
 
 func (d *DevicePath) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -42,7 +46,24 @@ func (d *DevicePath) unmarshal(unmarshal func(interface{}) error) error {
 	return d.assertValid()
 }
 
+// Tag returns the tag name (LABEL or UUID) and value of a device referenced
+// by label or uuid. ok is false for devices referenced by path.
+func (d DevicePath) Tag() (name, value string, ok bool) {
+	for _, name := range []string{"LABEL", "UUID"} {
+		if strings.HasPrefix(string(d), name+"=") {
+			return name, strings.TrimPrefix(string(d), name+"="), true
+		}
+	}
+	return "", "", false
+}
+
 func (d DevicePath) assertValid() error {
+	if _, value, ok := d.Tag(); ok {
+		if value == "" {
+			return ErrFilesystemEmptyTag
+		}
+		return nil
+	}
 	if !filepath.IsAbs(string(d)) {
 		return ErrFilesystemRelativePath
 	}
@@ -20,9 +20,12 @@ import (
 )
 
 type Disk struct {
-	Device     DevicePath  `json:"device,omitempty"     yaml:"device"`
-	WipeTable  bool        `json:"wipeTable,omitempty"  yaml:"wipe_table"`
-	Partitions []Partition `json:"partitions,omitempty" yaml:"partitions"`
+	Device             DevicePath          `json:"device,omitempty"             yaml:"device"`
+	WipeTable          bool                `json:"wipeTable,omitempty"          yaml:"wipe_table"`
+	Partitions         []Partition         `json:"partitions,omitempty"         yaml:"partitions"`
+	DeletePartitions   []int               `json:"deletePartitions,omitempty"   yaml:"delete_partitions"`
+	PreservePartitions []PartitionSelector `json:"preservePartitions,omitempty" yaml:"preserve_partitions"`
+	TableType          PartitionTableType  `json:"tableType,omitempty"          yaml:"table_type"`
 }
 
 func (n *Disk) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -61,19 +64,78 @@ func (n Disk) assertValid() error {
 	if len(n.Device) == 0 {
 		return fmt.Errorf("disk device is required")
 	}
+	for _, num := range n.DeletePartitions {
+		if num < 1 {
+			return fmt.Errorf("disk %q: invalid partition number to delete: %d", n.Device, num)
+		}
+	}
+	for _, sel := range n.PreservePartitions {
+		if sel.Label == "" && sel.TypeGUID == "" {
+			return fmt.Errorf("disk %q: partitions to preserve must be selected by label or type guid", n.Device)
+		}
+	}
+	if err := n.assertPercentsValid(); err != nil {
+		return err
+	}
+	if err := n.assertExpectedValid(); err != nil {
+		return err
+	}
+	if err := n.assertMBRValid(); err != nil {
+		return err
+	}
 	if n.partitionNumbersCollide() {
 		return fmt.Errorf("disk %q: partition numbers collide", n.Device)
 	}
-	if n.partitionsOverlap() {
-		return fmt.Errorf("disk %q: partitions overlap", n.Device)
+	if p, o, ok := n.partitionsOverlap(); ok {
+		return fmt.Errorf("disk %q: partitions %d and %d overlap", n.Device, p, o)
 	}
 	if n.partitionsMisaligned() {
 		return fmt.Errorf("disk %q: partitions misaligned", n.Device)
 	}
+	if n.partitionsGrowNotLast() {
+		return fmt.Errorf("disk %q: only the last partition may grow to fill the disk", n.Device)
+	}
+	if err := n.assertMaxSizesValid(); err != nil {
+		return err
+	}
 	// Disks which get to this point will likely succeed in sgdisk
 	return nil
 }
 
+// PartitionTableType is the type of partition table on a disk: "gpt", the
+// default, or "mbr".
+type PartitionTableType string
+
+func (t *PartitionTableType) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return t.unmarshal(unmarshal)
+}
+
+func (t *PartitionTableType) UnmarshalJSON(data []byte) error {
+	return t.unmarshal(func(tt interface{}) error {
+		return json.Unmarshal(data, tt)
+	})
+}
+
+type partitionTableType PartitionTableType
+
+func (t *PartitionTableType) unmarshal(unmarshal func(interface{}) error) error {
+	tt := partitionTableType(*t)
+	if err := unmarshal(&tt); err != nil {
+		return err
+	}
+	*t = PartitionTableType(tt)
+	return t.assertValid()
+}
+
+func (t PartitionTableType) assertValid() error {
+	switch t {
+	case "", "gpt", "mbr":
+		return nil
+	default:
+		return fmt.Errorf(`partition table type must be "gpt" or "mbr", got: %q`, string(t))
+	}
+}
+
 // partitionNumbersCollide returns true if partition numbers in n.Partitions are not unique.
 func (n Disk) partitionNumbersCollide() bool {
 	m := map[int][]Partition{}
@@ -89,6 +151,94 @@ func (n Disk) partitionNumbersCollide() bool {
 	return false
 }
 
+// assertPercentsValid checks the percentage-based starts and sizes, which are
+// resolved against the disk size only once the device is known.
+func (n Disk) assertPercentsValid() error {
+	var total uint
+	for _, p := range n.Partitions {
+		if p.Start != 0 && p.StartPercent != 0 {
+			return fmt.Errorf("disk %q: partition %d: start and start percent are mutually exclusive", n.Device, p.Number)
+		}
+		if p.Size != 0 && p.SizePercent != 0 {
+			return fmt.Errorf("disk %q: partition %d: size and size percent are mutually exclusive", n.Device, p.Number)
+		}
+		if p.StartPercent+p.SizePercent > 100 {
+			return fmt.Errorf("disk %q: partition %d: extends past 100%% of the disk", n.Device, p.Number)
+		}
+		total += p.SizePercent
+	}
+	if total > 100 {
+		return fmt.Errorf("disk %q: partition sizes total %d%% of the disk", n.Device, total)
+	}
+	return nil
+}
+
+// assertExpectedValid checks the partitions which are verified to exist
+// rather than created: they must survive the rest of the disk's changes and
+// be given in sectors, to compare against the partitions found.
+func (n Disk) assertExpectedValid() error {
+	for _, p := range n.Partitions {
+		if !p.ShouldExist {
+			continue
+		}
+		if n.WipeTable {
+			return fmt.Errorf("disk %q: partition %d should exist but the table is wiped", n.Device, p.Number)
+		}
+		for _, num := range n.DeletePartitions {
+			if num == p.Number {
+				return fmt.Errorf("disk %q: partition %d should exist but is deleted", n.Device, p.Number)
+			}
+		}
+		if p.StartPercent != 0 || p.SizePercent != 0 {
+			return fmt.Errorf("disk %q: partition %d: partitions which should exist can't be sized by percent", n.Device, p.Number)
+		}
+	}
+	return nil
+}
+
+// assertMBRValid checks the disk fits in an MBR partition table when one is
+// asked for: at most four primary partitions within the first 2^32 sectors,
+// none of them with the GPT only label, GUID or attributes. Only the legacy
+// BIOS bootable attribute (2) carries over, as the MBR bootable flag.
+func (n Disk) assertMBRValid() error {
+	if n.TableType != "mbr" {
+		return nil
+	}
+	if len(n.PreservePartitions) != 0 {
+		return fmt.Errorf("disk %q: preserving partitions is unsupported on mbr", n.Device)
+	}
+	if len(n.Partitions) > 4 {
+		return fmt.Errorf("disk %q: mbr holds at most 4 primary partitions, got %d", n.Device, len(n.Partitions))
+	}
+	for _, num := range n.DeletePartitions {
+		if num > 4 {
+			return fmt.Errorf("disk %q: invalid mbr partition number to delete: %d", n.Device, num)
+		}
+	}
+	for _, p := range n.Partitions {
+		switch {
+		case p.Number < 1 || p.Number > 4:
+			return fmt.Errorf("disk %q: partition %d: mbr partitions must be numbered 1 to 4", n.Device, p.Number)
+		case p.ShouldExist:
+			return fmt.Errorf("disk %q: partition %d: partitions which should exist are unsupported on mbr", n.Device, p.Number)
+		case p.Label != "":
+			return fmt.Errorf("disk %q: partition %d: mbr partitions can't be labeled", n.Device, p.Number)
+		case p.GUID != "":
+			return fmt.Errorf("disk %q: partition %d: mbr partitions can't have a guid", n.Device, p.Number)
+		case uuidRegexp.MatchString(string(p.TypeGUID)):
+			return fmt.Errorf("disk %q: partition %d: mbr partitions take a type code, not a type guid", n.Device, p.Number)
+		case uint64(p.Start+p.Size) > 1<<32:
+			return fmt.Errorf("disk %q: partition %d: extends past the last sector addressable by mbr", n.Device, p.Number)
+		}
+		for _, bit := range p.Attributes {
+			if bit != 2 {
+				return fmt.Errorf("disk %q: partition %d: mbr partitions only support the bootable attribute (2)", n.Device, p.Number)
+			}
+		}
+	}
+	return nil
+}
+
 // end returns the last sector of a partition.
 func (p Partition) end() PartitionDimension {
 	if p.Size == 0 {
@@ -98,49 +248,74 @@ func (p Partition) end() PartitionDimension {
 	return p.Start + p.Size - 1
 }
 
-// partitionsOverlap returns true if any explicitly dimensioned partitions overlap
-func (n Disk) partitionsOverlap() bool {
-	for _, p := range n.Partitions {
+// partitionsOverlap returns the numbers of the first pair of explicitly
+// dimensioned partitions which overlap, and whether any do.
+func (n Disk) partitionsOverlap() (int, int, bool) {
+	for i, p := range n.Partitions {
 		// Starts of 0 are placed by sgdisk into the "largest available block" at that time.
 		// We aren't going to check those for overlap since we don't have the disk geometry.
 		if p.Start == 0 {
 			continue
 		}
 
-		for _, o := range n.Partitions {
-			if p == o || o.Start == 0 {
+		for j, o := range n.Partitions {
+			if i == j || o.Start == 0 {
 				continue
 			}
 
 			// is p.Start within o?
 			if p.Start >= o.Start && p.Start <= o.end() {
-				return true
+				return p.Number, o.Number, true
 			}
 
 			// is p.end() within o?
 			if p.end() >= o.Start && p.end() <= o.end() {
-				return true
+				return p.Number, o.Number, true
 			}
 
 			// do p.Start and p.end() straddle o?
 			if p.Start < o.Start && p.end() > o.end() {
-				return true
+				return p.Number, o.Number, true
 			}
 		}
 	}
-	return false
+	return 0, 0, false
 }
 
 // partitionsMisaligned returns true if any of the partitions don't start on a 2048-sector (1MiB) boundary.
+// Partitions which should already exist are laid out by whoever created them.
 func (n Disk) partitionsMisaligned() bool {
 	for _, p := range n.Partitions {
-		if (p.Start & (2048 - 1)) != 0 {
+		if !p.ShouldExist && (p.Start&(2048-1)) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// partitionsGrowNotLast returns true if any partition other than the last has a
+// size of 0, meaning it grows to fill the remaining space on the disk. Partitions
+// which should already exist don't grow, their size of 0 matches any size.
+func (n Disk) partitionsGrowNotLast() bool {
+	for i, p := range n.Partitions {
+		if p.Size == 0 && p.SizePercent == 0 && !p.ShouldExist && i != len(n.Partitions)-1 {
 			return true
 		}
 	}
 	return false
 }
 
+// assertMaxSizesValid checks that only partitions growing to fill the disk
+// are capped by a max size, since the size of any other is already known.
+func (n Disk) assertMaxSizesValid() error {
+	for _, p := range n.Partitions {
+		if p.MaxSize != 0 && (p.Size != 0 || p.SizePercent != 0 || p.ShouldExist) {
+			return fmt.Errorf("disk %q: partition %d: max size requires the partition to grow to fill the disk", n.Device, p.Number)
+		}
+	}
+	return nil
+}
+
 // preparePartitions performs some checks and potentially adjusts the partitions for alignment.
 // This is only invoked when unmarshalling YAML, since there we parse human-friendly units.
 func (n *Disk) preparePartitions() error {
@@ -148,8 +323,8 @@ func (n *Disk) preparePartitions() error {
 
 	// align partition starts
 	for i := range n.Partitions {
-		// skip automatically placed partitions
-		if n.Partitions[i].Start == 0 {
+		// skip automatically placed partitions and those already laid out
+		if n.Partitions[i].Start == 0 || n.Partitions[i].ShouldExist {
 			continue
 		}
 
@@ -0,0 +1,256 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDiskAssertValid(t *testing.T) {
+	type in struct {
+		disk Disk
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{disk: Disk{Device: "/dev/sda"}},
+			out: out{},
+		},
+		{
+			in:  in{disk: Disk{}},
+			out: out{err: errors.New("disk device is required")},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", Partitions: []Partition{
+				{Number: 1, Start: 2048, Size: 2048},
+				{Number: 2, Start: 4096},
+			}}},
+			out: out{},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", Partitions: []Partition{
+				{Number: 1, Start: 2048},
+				{Number: 2, Start: 8192, Size: 2048},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": only the last partition may grow to fill the disk`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", Partitions: []Partition{
+				{Number: 1, Start: 2048, Size: 4096},
+				{Number: 1, Start: 8192, Size: 2048},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partition numbers collide`)},
+		},
+		{
+			in:  in{disk: Disk{Device: "/dev/sda", DeletePartitions: []int{1, 3}}},
+			out: out{},
+		},
+		{
+			in:  in{disk: Disk{Device: "/dev/sda", DeletePartitions: []int{0}}},
+			out: out{err: errors.New(`disk "/dev/sda": invalid partition number to delete: 0`)},
+		},
+		{
+			in:  in{disk: Disk{Device: "/dev/sda", WipeTable: true, PreservePartitions: []PartitionSelector{{Label: "OEM"}}}},
+			out: out{},
+		},
+		{
+			in:  in{disk: Disk{Device: "/dev/sda", PreservePartitions: []PartitionSelector{{}}}},
+			out: out{err: errors.New(`disk "/dev/sda": partitions to preserve must be selected by label or type guid`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", Partitions: []Partition{
+				{Number: 1, Start: 2048, Size: 2048},
+				{Number: 2, SizePercent: 50},
+				{Number: 3, SizePercent: 40},
+			}}},
+			out: out{},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", Partitions: []Partition{
+				{Number: 1, SizePercent: 60},
+				{Number: 2, SizePercent: 50},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partition sizes total 110% of the disk`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", Partitions: []Partition{
+				{Number: 1, StartPercent: 60, SizePercent: 50},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partition 1: extends past 100% of the disk`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", Partitions: []Partition{
+				{Number: 1, Size: 2048, SizePercent: 50},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partition 1: size and size percent are mutually exclusive`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", Partitions: []Partition{
+				{Number: 1, Start: 34, Size: 2014, ShouldExist: true},
+				{Number: 9, Label: "ROOT", ShouldExist: true},
+				{Number: 10, Start: 4194304},
+			}}},
+			out: out{},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", WipeTable: true, Partitions: []Partition{
+				{Number: 1, ShouldExist: true},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partition 1 should exist but the table is wiped`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", DeletePartitions: []int{1}, Partitions: []Partition{
+				{Number: 1, ShouldExist: true},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partition 1 should exist but is deleted`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", Partitions: []Partition{
+				{Number: 1, SizePercent: 50, ShouldExist: true},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partition 1: partitions which should exist can't be sized by percent`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", Partitions: []Partition{
+				{Number: 1, Start: 2048, Size: 2048},
+				{Number: 2, Start: 4096, MaxSize: 20971520},
+			}}},
+			out: out{},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", Partitions: []Partition{
+				{Number: 1, Start: 2048, Size: 2048, MaxSize: 4096},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partition 1: max size requires the partition to grow to fill the disk`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", Partitions: []Partition{
+				{Number: 1, SizePercent: 50, MaxSize: 4096},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partition 1: max size requires the partition to grow to fill the disk`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", TableType: "mbr", Partitions: []Partition{
+				{Number: 1, Start: 2048, Size: 204800, TypeGUID: "ef", Attributes: PartitionAttributes{2}},
+				{Number: 2, Start: 206848, TypeGUID: "linux"},
+			}}},
+			out: out{},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", TableType: "mbr", Partitions: []Partition{
+				{Number: 1, Start: 2048, Size: 2048},
+				{Number: 2, Start: 4096, Size: 2048},
+				{Number: 3, Start: 6144, Size: 2048},
+				{Number: 4, Start: 8192, Size: 2048},
+				{Number: 5, Start: 10240, Size: 2048},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": mbr holds at most 4 primary partitions, got 5`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", TableType: "mbr", Partitions: []Partition{
+				{Number: 5, Start: 2048},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partition 5: mbr partitions must be numbered 1 to 4`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", TableType: "mbr", Partitions: []Partition{
+				{Number: 1, Start: 2048, Label: "ROOT"},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partition 1: mbr partitions can't be labeled`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", TableType: "mbr", Partitions: []Partition{
+				{Number: 1, Start: 2048, TypeGUID: "0FC63DAF-8483-4772-8E79-3D69D8477DE4"},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partition 1: mbr partitions take a type code, not a type guid`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", TableType: "mbr", Partitions: []Partition{
+				{Number: 1, Start: 2048, Attributes: PartitionAttributes{60}},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partition 1: mbr partitions only support the bootable attribute (2)`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", TableType: "mbr", Partitions: []Partition{
+				{Number: 1, Start: 2048, Size: 1 << 32},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partition 1: extends past the last sector addressable by mbr`)},
+		},
+		{
+			in: in{disk: Disk{Device: "/dev/sda", Partitions: []Partition{
+				{Number: 1, Start: 2048, Size: 4096},
+				{Number: 2, Start: 4096, Size: 2048},
+			}}},
+			out: out{err: errors.New(`disk "/dev/sda": partitions 1 and 2 overlap`)},
+		},
+		{
+			in:  in{disk: Disk{Device: "/dev/sda", TableType: "mbr", PreservePartitions: []PartitionSelector{{Label: "OEM"}}}},
+			out: out{err: errors.New(`disk "/dev/sda": preserving partitions is unsupported on mbr`)},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.disk.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
+
+func TestPartitionTableTypeAssertValid(t *testing.T) {
+	type in struct {
+		tableType PartitionTableType
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{tableType: ""},
+			out: out{},
+		},
+		{
+			in:  in{tableType: "gpt"},
+			out: out{},
+		},
+		{
+			in:  in{tableType: "mbr"},
+			out: out{},
+		},
+		{
+			in:  in{tableType: "dos"},
+			out: out{err: errors.New(`partition table type must be "gpt" or "mbr", got: "dos"`)},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.tableType.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
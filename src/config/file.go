@@ -15,24 +15,132 @@
 package config
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"net/url"
 	"os"
+	"text/template"
 )
 
 var (
-	ErrFileIllegalMode = errors.New("illegal file mode")
+	ErrFileIllegalMode     = errors.New("illegal file mode")
+	ErrFileSourceScheme    = errors.New("file source must be an http or https url")
+	ErrFileSourceContents  = errors.New("file source and contents are mutually exclusive")
+	ErrFileEncoding        = errors.New("unsupported file encoding")
+	ErrFileBase64Contents  = errors.New("file contents are not valid base64")
+	ErrFileNegativeSize    = errors.New("file size may not be negative")
+	ErrFileSizeContents    = errors.New("file size and contents are mutually exclusive")
+	ErrFileSizeAppend      = errors.New("file size may not be set on files which are appended to")
+	ErrFileOverwrite       = errors.New("invalid file overwrite policy")
+	ErrFilePermissionsOnly = errors.New("permissions only files may not have contents, a source, a size, be appended to or be templates")
+	ErrFileTemplate        = errors.New("file contents are not a valid template")
+	ErrFileNegativeMtime   = errors.New("file mtime may not be before the epoch")
+	ErrFileUidUser         = errors.New("file uid and user are mutually exclusive")
+	ErrFileGidGroup        = errors.New("file gid and group are mutually exclusive")
 )
 
 type FileMode os.FileMode
 
 type File struct {
-	Path     string   `json:"path,omitempty"     yaml:"path"`
-	Contents string   `json:"contents,omitempty" yaml:"contents"`
-	Mode     FileMode `json:"mode,omitempty"     yaml:"mode"`
-	// FIXME(vc) make these strings and add resolution to WriteFile
-	Uid int `json:"uid,omitempty"                yaml:"uid"`
-	Gid int `json:"gid,omitempty"                yaml:"gid"`
+	Path            string        `json:"path,omitempty"            yaml:"path"`
+	Contents        string        `json:"contents,omitempty"        yaml:"contents"`
+	Source          string        `json:"source,omitempty"          yaml:"source"`
+	Verification    Verification  `json:"verification,omitempty"    yaml:"verification"`
+	Mode            FileMode      `json:"mode,omitempty"            yaml:"mode"`
+	Uid             *int          `json:"uid,omitempty"             yaml:"uid"`
+	Gid             *int          `json:"gid,omitempty"             yaml:"gid"`
+	User            string        `json:"user,omitempty"            yaml:"user"`
+	Group           string        `json:"group,omitempty"           yaml:"group"`
+	Append          bool          `json:"append,omitempty"          yaml:"append"`
+	Encoding        string        `json:"encoding,omitempty"        yaml:"encoding"`
+	SELinuxContext  string        `json:"selinuxContext,omitempty"  yaml:"selinux_context"`
+	Size            int64         `json:"size,omitempty"            yaml:"size"`
+	Overwrite       FileOverwrite `json:"overwrite,omitempty"       yaml:"overwrite"`
+	PermissionsOnly bool          `json:"permissionsOnly,omitempty" yaml:"permissions_only"`
+	Template        bool          `json:"template,omitempty"        yaml:"template"`
+	Mtime           *int64        `json:"mtime,omitempty"           yaml:"mtime"`
+	Condition       *Condition    `json:"condition,omitempty"       yaml:"condition"`
+	Filesystem      string        `json:"filesystem,omitempty"      yaml:"filesystem"`
+}
+
+func (f *File) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return f.unmarshal(unmarshal)
+}
+
+func (f *File) UnmarshalJSON(data []byte) error {
+	return f.unmarshal(func(tf interface{}) error {
+		return json.Unmarshal(data, tf)
+	})
+}
+
+type file File
+
+func (f *File) unmarshal(unmarshal func(interface{}) error) error {
+	tf := file(*f)
+	if err := unmarshal(&tf); err != nil {
+		return err
+	}
+	*f = File(tf)
+	return f.assertValid()
+}
+
+func (f File) assertValid() error {
+	if f.PermissionsOnly && (f.Contents != "" || f.Source != "" || f.Size != 0 || f.Append || f.Encoding != "" || f.Template) {
+		return ErrFilePermissionsOnly
+	}
+	if f.Size < 0 {
+		return ErrFileNegativeSize
+	}
+	if f.Mtime != nil && *f.Mtime < 0 {
+		return ErrFileNegativeMtime
+	}
+	if f.Size != 0 && (f.Contents != "" || f.Source != "") {
+		return ErrFileSizeContents
+	}
+	if f.Size != 0 && f.Append {
+		return ErrFileSizeAppend
+	}
+	if f.Uid != nil && f.User != "" {
+		return ErrFileUidUser
+	}
+	if f.Gid != nil && f.Group != "" {
+		return ErrFileGidGroup
+	}
+
+	switch f.Encoding {
+	case "":
+	case "base64":
+		if _, err := base64.StdEncoding.DecodeString(f.Contents); err != nil {
+			return ErrFileBase64Contents
+		}
+	default:
+		return ErrFileEncoding
+	}
+
+	// encoded and fetched contents can only be checked once they're rendered
+	if f.Template && f.Encoding == "" {
+		if _, err := template.New(f.Path).Parse(f.Contents); err != nil {
+			return ErrFileTemplate
+		}
+	}
+
+	if f.Source == "" {
+		return nil
+	}
+	if f.Contents != "" {
+		return ErrFileSourceContents
+	}
+	u, err := url.Parse(f.Source)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return nil
+	default:
+		return ErrFileSourceScheme
+	}
 }
 
 func (m *FileMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -62,3 +170,43 @@ func (m FileMode) assertValid() error {
 	}
 	return nil
 }
+
+// FileOverwrite is the policy applied when writing a file which already
+// exists. The default is "always".
+type FileOverwrite string
+
+const (
+	OverwriteAlways      FileOverwrite = "always"
+	OverwriteNever       FileOverwrite = "never"
+	OverwriteIfDifferent FileOverwrite = "if-different"
+)
+
+func (o *FileOverwrite) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return o.unmarshal(unmarshal)
+}
+
+func (o *FileOverwrite) UnmarshalJSON(data []byte) error {
+	return o.unmarshal(func(to interface{}) error {
+		return json.Unmarshal(data, to)
+	})
+}
+
+type fileOverwrite FileOverwrite
+
+func (o *FileOverwrite) unmarshal(unmarshal func(interface{}) error) error {
+	to := fileOverwrite(*o)
+	if err := unmarshal(&to); err != nil {
+		return err
+	}
+	*o = FileOverwrite(to)
+	return o.assertValid()
+}
+
+func (o FileOverwrite) assertValid() error {
+	switch o {
+	case "", OverwriteAlways, OverwriteNever, OverwriteIfDifferent:
+		return nil
+	default:
+		return ErrFileOverwrite
+	}
+}
@@ -97,6 +97,119 @@ func TestFileModeUnmarshalYAML(t *testing.T) {
 }
 
 func TestFileAssertValid(t *testing.T) {
+	type in struct {
+		file File
+	}
+	type out struct {
+		err error
+	}
+
+	epoch, beforeEpoch := int64(0), int64(-1)
+	uid := 500
+	root := 0
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{file: File{Contents: "hello"}},
+			out: out{},
+		},
+		{
+			in:  in{file: File{Source: "https://example.com/hello"}},
+			out: out{},
+		},
+		{
+			in:  in{file: File{Size: 1 << 20}},
+			out: out{},
+		},
+		{
+			in:  in{file: File{Size: -1}},
+			out: out{err: ErrFileNegativeSize},
+		},
+		{
+			in:  in{file: File{Size: 1 << 20, Contents: "hello"}},
+			out: out{err: ErrFileSizeContents},
+		},
+		{
+			in:  in{file: File{Size: 1 << 20, Append: true}},
+			out: out{err: ErrFileSizeAppend},
+		},
+		{
+			in:  in{file: File{Contents: "aGVsbG8=", Encoding: "base64"}},
+			out: out{},
+		},
+		{
+			in:  in{file: File{Contents: "hello!", Encoding: "base64"}},
+			out: out{err: ErrFileBase64Contents},
+		},
+		{
+			in:  in{file: File{Contents: "hello", Encoding: "rot13"}},
+			out: out{err: ErrFileEncoding},
+		},
+		{
+			in:  in{file: File{Source: "ftp://example.com/hello"}},
+			out: out{err: ErrFileSourceScheme},
+		},
+		{
+			in:  in{file: File{Contents: "hello", Source: "http://example.com/hello"}},
+			out: out{err: ErrFileSourceContents},
+		},
+		{
+			in:  in{file: File{PermissionsOnly: true, Mode: 0600, Uid: &uid}},
+			out: out{},
+		},
+		{
+			in:  in{file: File{PermissionsOnly: true, Contents: "hello"}},
+			out: out{err: ErrFilePermissionsOnly},
+		},
+		{
+			in:  in{file: File{PermissionsOnly: true, Append: true}},
+			out: out{err: ErrFilePermissionsOnly},
+		},
+		{
+			in:  in{file: File{Contents: "hello", Mtime: &epoch}},
+			out: out{},
+		},
+		{
+			in:  in{file: File{Contents: "hello", Mtime: &beforeEpoch}},
+			out: out{err: ErrFileNegativeMtime},
+		},
+		{
+			in:  in{file: File{Contents: "hello", Uid: &root, Group: "core"}},
+			out: out{},
+		},
+		{
+			in:  in{file: File{Contents: "hello", Uid: &root, User: "core"}},
+			out: out{err: ErrFileUidUser},
+		},
+		{
+			in:  in{file: File{Contents: "hello", Gid: &root, Group: "core"}},
+			out: out{err: ErrFileGidGroup},
+		},
+		{
+			in:  in{file: File{Contents: "{{.hostname}}", Template: true}},
+			out: out{},
+		},
+		{
+			in:  in{file: File{Contents: "{{.hostname", Template: true}},
+			out: out{err: ErrFileTemplate},
+		},
+		{
+			in:  in{file: File{Contents: "e3suaG9zdG5hbWU=", Template: true, Encoding: "base64"}},
+			out: out{},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.file.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
+
+func TestFileModeAssertValid(t *testing.T) {
 	type in struct {
 		mode FileMode
 	}
@@ -137,3 +250,45 @@ func TestFileAssertValid(t *testing.T) {
 		}
 	}
 }
+
+func TestFileOverwriteAssertValid(t *testing.T) {
+	type in struct {
+		overwrite FileOverwrite
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{overwrite: FileOverwrite("")},
+			out: out{},
+		},
+		{
+			in:  in{overwrite: OverwriteAlways},
+			out: out{},
+		},
+		{
+			in:  in{overwrite: OverwriteNever},
+			out: out{},
+		},
+		{
+			in:  in{overwrite: OverwriteIfDifferent},
+			out: out{},
+		},
+		{
+			in:  in{overwrite: FileOverwrite("sometimes")},
+			out: out{err: ErrFileOverwrite},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.overwrite.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
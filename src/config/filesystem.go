@@ -17,19 +17,149 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
 )
 
 var (
 	ErrFilesystemRelativePath  = errors.New("device path not absolute")
+	ErrFilesystemEmptyTag      = errors.New("device label or uuid is empty")
 	ErrFilesystemInvalidFormat = errors.New("invalid filesystem format")
+	ErrFilesystemSwapFiles     = errors.New("files unsupported on swap")
+	ErrFilesystemReadOnlyFiles = errors.New("files unsupported on read-only mounts")
+	ErrFilesystemMountFlag     = errors.New("invalid mount flag")
+	ErrFilesystemMountPoint    = errors.New("mount point not absolute")
+	ErrFilesystemSwapMount     = errors.New("mount point unsupported on swap")
+	ErrFilesystemKeepMounted   = errors.New("keeping mounted requires a mount point")
+	ErrFilesystemInvalidUUID   = errors.New("invalid filesystem uuid")
+	ErrFilesystemExt4Format    = errors.New("ext4 options require the ext4 format")
+	ErrFilesystemExt4Init      = errors.New("ext4 options require the filesystem to be initialized")
+	ErrFilesystemReservedBlock = errors.New("reserved blocks percent must be between 0 and 50")
+	ErrFilesystemSwapResize    = errors.New("resizing unsupported on swap")
+	ErrFilesystemResizeInit    = errors.New("resizing requires an existing filesystem, initialize it only if missing")
 )
 
 type Filesystem struct {
-	Device     DevicePath       `json:"device,omitempty"     yaml:"device"`
-	Initialize bool             `json:"initialize,omitempty" yaml:"initialize"`
-	Format     FilesystemFormat `json:"format,omitempty"     yaml:"format"`
-	Options    MkfsOptions      `json:"options,omitempty"    yaml:"options"`
-	Files      []File           `json:"files,omitempty"      yaml:"files"`
+	Name            string           `json:"name,omitempty"            yaml:"name"`
+	Device          DevicePath       `json:"device,omitempty"          yaml:"device"`
+	Initialize      bool             `json:"initialize,omitempty"      yaml:"initialize"`
+	CreateIfMissing bool             `json:"createIfMissing,omitempty" yaml:"create_if_missing"`
+	Resize          bool             `json:"resize,omitempty"          yaml:"resize"`
+	WipeFilesystem  bool             `json:"wipeFilesystem,omitempty"  yaml:"wipe_filesystem"`
+	Force           bool             `json:"force,omitempty"           yaml:"force"`
+	Format          FilesystemFormat `json:"format,omitempty"          yaml:"format"`
+	Label           string           `json:"label,omitempty"           yaml:"label"`
+	UUID            string           `json:"uuid,omitempty"            yaml:"uuid"`
+	Options         MkfsOptions      `json:"options,omitempty"         yaml:"options"`
+	Ext4            *Ext4Options     `json:"ext4,omitempty"            yaml:"ext4"`
+	MountPoint      string           `json:"mountPoint,omitempty"      yaml:"mount_point"`
+	KeepMounted     bool             `json:"keepMounted,omitempty"     yaml:"keep_mounted"`
+	MountOptions    string           `json:"mountOptions,omitempty"    yaml:"mount_options"`
+	MountFlags      MountFlags       `json:"mountFlags,omitempty"      yaml:"mount_flags"`
+	Directories     []Directory      `json:"directories,omitempty"     yaml:"directories"`
+	Files           []File           `json:"files,omitempty"           yaml:"files"`
+	Links           []Link           `json:"links,omitempty"           yaml:"links"`
+	Remove          []Removal        `json:"remove,omitempty"          yaml:"remove"`
+}
+
+func (f *Filesystem) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return f.unmarshal(unmarshal)
+}
+
+func (f *Filesystem) UnmarshalJSON(data []byte) error {
+	return f.unmarshal(func(tf interface{}) error {
+		return json.Unmarshal(data, tf)
+	})
+}
+
+type filesystem Filesystem
+
+func (f *Filesystem) unmarshal(unmarshal func(interface{}) error) error {
+	tf := filesystem(*f)
+	if err := unmarshal(&tf); err != nil {
+		return err
+	}
+	*f = Filesystem(tf)
+	return f.assertValid()
+}
+
+func (f Filesystem) assertValid() error {
+	// swap areas can't be mounted, so there's nowhere to write files
+	if f.Format == "swap" && f.hasContents() {
+		return ErrFilesystemSwapFiles
+	}
+	if f.MountPoint != "" {
+		if f.Format == "swap" {
+			return ErrFilesystemSwapMount
+		}
+		if !filepath.IsAbs(f.MountPoint) {
+			return ErrFilesystemMountPoint
+		}
+	}
+	if f.KeepMounted && f.MountPoint == "" {
+		return ErrFilesystemKeepMounted
+	}
+	if f.Resize {
+		if f.Format == "swap" {
+			return ErrFilesystemSwapResize
+		}
+		// a filesystem which is always remade has nothing to grow
+		if f.Initialize && !f.CreateIfMissing {
+			return ErrFilesystemResizeInit
+		}
+	}
+	if f.MountFlags.has("ro") && f.hasContents() {
+		return ErrFilesystemReadOnlyFiles
+	}
+	if f.UUID != "" && !uuidRegexp.MatchString(f.UUID) {
+		return ErrFilesystemInvalidUUID
+	}
+	if max, ok := maxLabelLengths[f.Format]; ok && len(f.Label) > max {
+		return fmt.Errorf("%s labels may not exceed %d characters", f.Format, max)
+	}
+	if f.Ext4 != nil {
+		if f.Format != "ext4" {
+			return ErrFilesystemExt4Format
+		}
+		// the tuning is applied right after mkfs, existing filesystems are left be
+		if !f.Initialize {
+			return ErrFilesystemExt4Init
+		}
+		return f.Ext4.assertValid()
+	}
+	return nil
+}
+
+// Ext4Options tune a newly created ext4 filesystem with tune2fs. Lazy
+// initialization can only be disabled by mkfs itself, which then zeroes the
+// inode tables and journal up front rather than in the background after boot.
+type Ext4Options struct {
+	DisablePeriodicFsck   bool `json:"disablePeriodicFsck,omitempty"   yaml:"disable_periodic_fsck"`
+	ReservedBlocksPercent *int `json:"reservedBlocksPercent,omitempty" yaml:"reserved_blocks_percent"`
+	DisableLazyInit       bool `json:"disableLazyInit,omitempty"       yaml:"disable_lazy_init"`
+}
+
+func (o Ext4Options) assertValid() error {
+	if o.ReservedBlocksPercent != nil && (*o.ReservedBlocksPercent < 0 || *o.ReservedBlocksPercent > 50) {
+		return ErrFilesystemReservedBlock
+	}
+	return nil
+}
+
+var uuidRegexp = regexp.MustCompile("^[[:xdigit:]]{8}-[[:xdigit:]]{4}-[[:xdigit:]]{4}-[[:xdigit:]]{4}-[[:xdigit:]]{12}$")
+
+// hasContents returns true if any directories, files or links are to be
+// written to f, or any paths removed from it.
+func (f Filesystem) hasContents() bool {
+	return len(f.Directories) != 0 || len(f.Files) != 0 || len(f.Links) != 0 || len(f.Remove) != 0
+}
+
+// maxLabelLengths are the label length limits imposed by each format's mkfs.
+var maxLabelLengths = map[FilesystemFormat]int{
+	"ext4":  16,
+	"btrfs": 255,
+	"swap":  16,
 }
 
 type FilesystemFormat string
@@ -57,7 +187,7 @@ func (f *FilesystemFormat) unmarshal(unmarshal func(interface{}) error) error {
 
 func (f FilesystemFormat) assertValid() error {
 	switch f {
-	case "ext4", "btrfs":
+	case "ext4", "btrfs", "swap":
 		return nil
 	default:
 		return ErrFilesystemInvalidFormat
@@ -90,3 +220,47 @@ func (o *MkfsOptions) unmarshal(unmarshal func(interface{}) error) error {
 func (o MkfsOptions) assertValid() error {
 	return nil
 }
+
+type MountFlags []string
+
+func (m *MountFlags) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return m.unmarshal(unmarshal)
+}
+
+func (m *MountFlags) UnmarshalJSON(data []byte) error {
+	return m.unmarshal(func(tm interface{}) error {
+		return json.Unmarshal(data, tm)
+	})
+}
+
+type mountFlags MountFlags
+
+func (m *MountFlags) unmarshal(unmarshal func(interface{}) error) error {
+	tm := mountFlags(*m)
+	if err := unmarshal(&tm); err != nil {
+		return err
+	}
+	*m = MountFlags(tm)
+	return m.assertValid()
+}
+
+func (m MountFlags) assertValid() error {
+	for _, f := range m {
+		switch f {
+		case "ro", "nosuid", "nodev", "noexec", "noatime", "nodiratime", "relatime", "sync":
+		default:
+			return ErrFilesystemMountFlag
+		}
+	}
+	return nil
+}
+
+// has returns true if flag is present in m.
+func (m MountFlags) has(flag string) bool {
+	for _, f := range m {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
@@ -125,6 +125,22 @@ func TestDevicePathAssertValid(t *testing.T) {
 			in:  in{device: DevicePath("relative/path")},
 			out: out{err: errors.New("device path not absolute")},
 		},
+		{
+			in:  in{device: DevicePath("LABEL=ROOT")},
+			out: out{},
+		},
+		{
+			in:  in{device: DevicePath("UUID=0f9b2c1e-8e54-4b8c-9d2f-3e1c0a7b6d5e")},
+			out: out{},
+		},
+		{
+			in:  in{device: DevicePath("LABEL=")},
+			out: out{err: ErrFilesystemEmptyTag},
+		},
+		{
+			in:  in{device: DevicePath("PARTLABEL=ROOT")},
+			out: out{err: errors.New("device path not absolute")},
+		},
 	}
 
 	for i, test := range tests {
@@ -135,6 +151,133 @@ func TestDevicePathAssertValid(t *testing.T) {
 	}
 }
 
+func TestFilesystemAssertValid(t *testing.T) {
+	type in struct {
+		filesystem Filesystem
+	}
+	type out struct {
+		err error
+	}
+
+	zero, tooMany := 0, 51
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", Files: []File{{Path: "/foo"}}}},
+			out: out{},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "swap"}},
+			out: out{},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "swap", Files: []File{{Path: "/foo"}}}},
+			out: out{err: ErrFilesystemSwapFiles},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "swap", Directories: []Directory{{Path: "/foo"}}}},
+			out: out{err: ErrFilesystemSwapFiles},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", MountFlags: MountFlags{"ro"}}},
+			out: out{},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", MountFlags: MountFlags{"ro"}, Files: []File{{Path: "/foo"}}}},
+			out: out{err: ErrFilesystemReadOnlyFiles},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", MountPoint: "/var"}},
+			out: out{},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", MountPoint: "var"}},
+			out: out{err: ErrFilesystemMountPoint},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", MountPoint: "/var", KeepMounted: true}},
+			out: out{},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", KeepMounted: true}},
+			out: out{err: ErrFilesystemKeepMounted},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", Resize: true}},
+			out: out{},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "btrfs", Initialize: true, CreateIfMissing: true, Resize: true}},
+			out: out{},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", Initialize: true, Resize: true}},
+			out: out{err: ErrFilesystemResizeInit},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "swap", Resize: true}},
+			out: out{err: ErrFilesystemSwapResize},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "swap", MountPoint: "/swap"}},
+			out: out{err: ErrFilesystemSwapMount},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", UUID: "0a6b4c3d-27d1-4e4b-9d43-4fbfac7a4e0b"}},
+			out: out{},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", UUID: "0a6b4c3d-27d1-4e4b-9d43"}},
+			out: out{err: ErrFilesystemInvalidUUID},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", Label: "ROOT"}},
+			out: out{},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", Label: "this-label-is-too-long"}},
+			out: out{err: errors.New("ext4 labels may not exceed 16 characters")},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", Initialize: true, Ext4: &Ext4Options{DisablePeriodicFsck: true, ReservedBlocksPercent: &zero}}},
+			out: out{},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "btrfs", Initialize: true, Ext4: &Ext4Options{DisablePeriodicFsck: true}}},
+			out: out{err: ErrFilesystemExt4Format},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", Ext4: &Ext4Options{DisablePeriodicFsck: true}}},
+			out: out{err: ErrFilesystemExt4Init},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", Initialize: true, Ext4: &Ext4Options{DisableLazyInit: true}}},
+			out: out{},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", Ext4: &Ext4Options{DisableLazyInit: true}}},
+			out: out{err: ErrFilesystemExt4Init},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "ext4", Initialize: true, Ext4: &Ext4Options{ReservedBlocksPercent: &tooMany}}},
+			out: out{err: ErrFilesystemReservedBlock},
+		},
+		{
+			in:  in{filesystem: Filesystem{Format: "btrfs", Label: "this-label-is-too-long"}},
+			out: out{},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.filesystem.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
+
 func TestFilesystemFormatUnmarshalJSON(t *testing.T) {
 	type in struct {
 		data string
@@ -225,6 +368,10 @@ func TestFilesystemFormatAssertValid(t *testing.T) {
 			in:  in{format: FilesystemFormat("btrfs")},
 			out: out{},
 		},
+		{
+			in:  in{format: FilesystemFormat("swap")},
+			out: out{},
+		},
 		{
 			in:  in{format: FilesystemFormat("")},
 			out: out{err: errors.New("invalid filesystem format")},
@@ -300,3 +447,37 @@ func TestMkfsOptionsUnmarshalYAML(t *testing.T) {
 		}
 	}
 }
+
+func TestMountFlagsAssertValid(t *testing.T) {
+	type in struct {
+		flags MountFlags
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{flags: nil},
+			out: out{},
+		},
+		{
+			in:  in{flags: MountFlags{"ro", "noatime"}},
+			out: out{},
+		},
+		{
+			in:  in{flags: MountFlags{"bad"}},
+			out: out{err: ErrFilesystemMountFlag},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.flags.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
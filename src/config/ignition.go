@@ -0,0 +1,139 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"reflect"
+)
+
+var (
+	ErrConfigReferenceScheme  = errors.New("config reference must be an http or https url")
+	ErrConfigAppendAndReplace = errors.New("config append and replace are mutually exclusive")
+)
+
+// Ignition holds settings about how the config itself is handled.
+type Ignition struct {
+	Config IgnitionConfig `json:"config,omitempty" yaml:"config"`
+}
+
+// IgnitionConfig names further configs to fetch before any stage runs. Each
+// appended config is merged into this one in order; a replacement config is
+// used instead of this one entirely.
+type IgnitionConfig struct {
+	Append  []ConfigReference `json:"append,omitempty"  yaml:"append"`
+	Replace *ConfigReference  `json:"replace,omitempty" yaml:"replace"`
+}
+
+func (c *IgnitionConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return c.unmarshal(unmarshal)
+}
+
+func (c *IgnitionConfig) UnmarshalJSON(data []byte) error {
+	return c.unmarshal(func(tc interface{}) error {
+		return json.Unmarshal(data, tc)
+	})
+}
+
+type ignitionConfig IgnitionConfig
+
+func (c *IgnitionConfig) unmarshal(unmarshal func(interface{}) error) error {
+	tc := ignitionConfig(*c)
+	if err := unmarshal(&tc); err != nil {
+		return err
+	}
+	*c = IgnitionConfig(tc)
+	return c.assertValid()
+}
+
+func (c IgnitionConfig) assertValid() error {
+	if c.Replace != nil && len(c.Append) != 0 {
+		return ErrConfigAppendAndReplace
+	}
+	return nil
+}
+
+// ConfigReference locates a remote config and, optionally, its expected hash.
+type ConfigReference struct {
+	Source       string       `json:"source,omitempty"       yaml:"source"`
+	Verification Verification `json:"verification,omitempty" yaml:"verification"`
+}
+
+func (r *ConfigReference) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return r.unmarshal(unmarshal)
+}
+
+func (r *ConfigReference) UnmarshalJSON(data []byte) error {
+	return r.unmarshal(func(tr interface{}) error {
+		return json.Unmarshal(data, tr)
+	})
+}
+
+type configReference ConfigReference
+
+func (r *ConfigReference) unmarshal(unmarshal func(interface{}) error) error {
+	tr := configReference(*r)
+	if err := unmarshal(&tr); err != nil {
+		return err
+	}
+	*r = ConfigReference(tr)
+	return r.assertValid()
+}
+
+func (r ConfigReference) assertValid() error {
+	u, err := url.Parse(r.Source)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return nil
+	default:
+		return ErrConfigReferenceScheme
+	}
+}
+
+// Append returns c with the contents of child added after its own: every
+// list in child is appended to the corresponding list in c. The references
+// of both configs are dropped, since the caller is expected to have
+// resolved them already.
+func (c Config) Append(child Config) Config {
+	merged := reflect.New(reflect.TypeOf(c)).Elem()
+	appendValues(merged, reflect.ValueOf(c), reflect.ValueOf(child))
+	result := merged.Interface().(Config)
+	result.Ignition.Config = IgnitionConfig{}
+	return result
+}
+
+// appendValues stores the merge of parent and child into dst, concatenating
+// slices and recursing into structs. For anything else the parent wins.
+func appendValues(dst, parent, child reflect.Value) {
+	switch parent.Kind() {
+	case reflect.Struct:
+		for i := 0; i < parent.NumField(); i++ {
+			appendValues(dst.Field(i), parent.Field(i), child.Field(i))
+		}
+	case reflect.Slice:
+		if parent.Len()+child.Len() == 0 {
+			dst.Set(parent)
+			return
+		}
+		dst.Set(reflect.AppendSlice(reflect.AppendSlice(reflect.MakeSlice(parent.Type(), 0, parent.Len()+child.Len()), parent), child))
+	default:
+		dst.Set(parent)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfigAppend(t *testing.T) {
+	type in struct {
+		parent Config
+		child  Config
+	}
+	type out struct {
+		config Config
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{parent: Config{Version: 1}, child: Config{Version: 1}},
+			out: out{config: Config{Version: 1}},
+		},
+		{
+			in: in{
+				parent: Config{
+					Version:  1,
+					Ignition: Ignition{Config: IgnitionConfig{Append: []ConfigReference{{Source: "http://example.com/child"}}}},
+					Systemd:  Systemd{Units: []SystemdUnit{{Name: "a.service"}}},
+				},
+				child: Config{
+					Version: 1,
+					Systemd: Systemd{Units: []SystemdUnit{{Name: "b.service"}}},
+					Passwd:  Passwd{Users: []User{{Name: "core"}}},
+				},
+			},
+			out: out{config: Config{
+				Version: 1,
+				Systemd: Systemd{Units: []SystemdUnit{{Name: "a.service"}, {Name: "b.service"}}},
+				Passwd:  Passwd{Users: []User{{Name: "core"}}},
+			}},
+		},
+	}
+
+	for i, test := range tests {
+		config := test.in.parent.Append(test.in.child)
+		if !reflect.DeepEqual(test.out.config, config) {
+			t.Errorf("#%d: bad config: want %+v, got %+v", i, test.out.config, config)
+		}
+	}
+}
+
+func TestIgnitionConfigAssertValid(t *testing.T) {
+	type in struct {
+		config IgnitionConfig
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{config: IgnitionConfig{}},
+			out: out{},
+		},
+		{
+			in:  in{config: IgnitionConfig{Append: []ConfigReference{{Source: "http://example.com/a"}}}},
+			out: out{},
+		},
+		{
+			in: in{config: IgnitionConfig{
+				Append:  []ConfigReference{{Source: "http://example.com/a"}},
+				Replace: &ConfigReference{Source: "http://example.com/b"},
+			}},
+			out: out{err: ErrConfigAppendAndReplace},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.config.assertValid()
+		if test.out.err != err {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
+
+func TestConfigReferenceAssertValid(t *testing.T) {
+	type in struct {
+		ref ConfigReference
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{ref: ConfigReference{Source: "https://example.com/config.ign"}},
+			out: out{},
+		},
+		{
+			in:  in{ref: ConfigReference{Source: "file:///config.ign"}},
+			out: out{err: ErrConfigReferenceScheme},
+		},
+		{
+			in:  in{ref: ConfigReference{}},
+			out: out{err: ErrConfigReferenceScheme},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.ref.assertValid()
+		if test.out.err != err {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrLuksName        = errors.New("luks volume name is required and may not contain slashes or whitespace")
+	ErrLuksDevice      = errors.New("luks volume device is required")
+	ErrLuksRelativeKey = errors.New("luks key file path must be absolute")
+	ErrLuksInvalidSlot = errors.New("luks key slot must be between 0 and 31")
+)
+
+// maxLuksKeySlot is the last of the key slots LUKS2 offers.
+const maxLuksKeySlot = 31
+
+// Luks is a LUKS encrypted volume set up on Device and opened as
+// /dev/mapper/<Name>, which filesystems may then use. The key is read from
+// KeyFile, a path in the destination root, so that it never appears in the
+// config; the same file opens the volume on later boots through crypttab.
+// The key file must already be in the image: the storage stage runs before
+// any files in the config are written. Devices holding other signatures are
+// only formatted if WipeDevice is set.
+type Luks struct {
+	Name       string     `json:"name"                 yaml:"name"`
+	Device     DevicePath `json:"device"               yaml:"device"`
+	KeyFile    string     `json:"keyFile"              yaml:"key_file"`
+	KeySlot    *int       `json:"keySlot,omitempty"    yaml:"key_slot"`
+	WipeDevice bool       `json:"wipeDevice,omitempty" yaml:"wipe_device"`
+}
+
+func (n *Luks) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return n.unmarshal(unmarshal)
+}
+
+func (n *Luks) UnmarshalJSON(data []byte) error {
+	return n.unmarshal(func(tn interface{}) error {
+		return json.Unmarshal(data, tn)
+	})
+}
+
+type luks Luks
+
+func (n *Luks) unmarshal(unmarshal func(interface{}) error) error {
+	tn := luks(*n)
+	if err := unmarshal(&tn); err != nil {
+		return err
+	}
+	*n = Luks(tn)
+	return n.assertValid()
+}
+
+func (n Luks) assertValid() error {
+	if n.Name == "" || n.Name == "." || n.Name == ".." || strings.ContainsAny(n.Name, "/ \t\n") {
+		return ErrLuksName
+	}
+	if n.Device == "" {
+		return ErrLuksDevice
+	}
+	if !filepath.IsAbs(n.KeyFile) {
+		return ErrLuksRelativeKey
+	}
+	if n.KeySlot != nil && (*n.KeySlot < 0 || *n.KeySlot > maxLuksKeySlot) {
+		return ErrLuksInvalidSlot
+	}
+	return nil
+}
+
+// MapperPath returns the device node the opened volume appears as.
+func (n Luks) MapperPath() string {
+	return filepath.Join("/dev/mapper", n.Name)
+}
@@ -0,0 +1,72 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLuksAssertValid(t *testing.T) {
+	slot := func(n int) *int { return &n }
+
+	type in struct {
+		luks Luks
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{luks: Luks{Name: "data", Device: "/dev/sdb1", KeyFile: "/etc/luks/data"}},
+			out: out{},
+		},
+		{
+			in:  in{luks: Luks{Name: "data", Device: "/dev/sdb1", KeyFile: "/etc/luks/data", KeySlot: slot(1)}},
+			out: out{},
+		},
+		{
+			in:  in{luks: Luks{Device: "/dev/sdb1", KeyFile: "/etc/luks/data"}},
+			out: out{err: ErrLuksName},
+		},
+		{
+			in:  in{luks: Luks{Name: "da/ta", Device: "/dev/sdb1", KeyFile: "/etc/luks/data"}},
+			out: out{err: ErrLuksName},
+		},
+		{
+			in:  in{luks: Luks{Name: "data", KeyFile: "/etc/luks/data"}},
+			out: out{err: ErrLuksDevice},
+		},
+		{
+			in:  in{luks: Luks{Name: "data", Device: "/dev/sdb1", KeyFile: "luks/data"}},
+			out: out{err: ErrLuksRelativeKey},
+		},
+		{
+			in:  in{luks: Luks{Name: "data", Device: "/dev/sdb1", KeyFile: "/etc/luks/data", KeySlot: slot(32)}},
+			out: out{err: ErrLuksInvalidSlot},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.luks.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+)
+
+var (
+	ErrKernelModuleName = errors.New("kernel module names may only contain letters, digits, underscores and dashes")
+)
+
+// KernelModule is the name of a kernel module to load at boot, such as
+// dm_crypt. modprobe treats dashes and underscores alike.
+type KernelModule string
+
+var kernelModuleRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+func (m *KernelModule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return m.unmarshal(unmarshal)
+}
+
+func (m *KernelModule) UnmarshalJSON(data []byte) error {
+	return m.unmarshal(func(tm interface{}) error {
+		return json.Unmarshal(data, tm)
+	})
+}
+
+type kernelModule KernelModule
+
+func (m *KernelModule) unmarshal(unmarshal func(interface{}) error) error {
+	tm := kernelModule(*m)
+	if err := unmarshal(&tm); err != nil {
+		return err
+	}
+	*m = KernelModule(tm)
+	return m.assertValid()
+}
+
+func (m KernelModule) assertValid() error {
+	if !kernelModuleRegexp.MatchString(string(m)) {
+		return ErrKernelModuleName
+	}
+	return nil
+}
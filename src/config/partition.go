@@ -22,12 +22,28 @@ import (
 	"github.com/coreos/ignition/third_party/github.com/alecthomas/units"
 )
 
+// Partition describes a partition on a disk. Percentages of 0 are unset, so
+// a partition can't be placed at 0% of the disk: a Start of 2048, the first
+// usable sector, does that instead.
 type Partition struct {
-	Label    PartitionLabel     `json:"label,omitempty"    yaml:"label"`
-	Number   int                `json:"number"             yaml:"number"`
-	Size     PartitionDimension `json:"size"               yaml:"size"`
-	Start    PartitionDimension `json:"start"              yaml:"start"`
-	TypeGUID PartitionTypeGUID  `json:"typeGuid,omitempty" yaml:"type_guid"`
+	Label        PartitionLabel      `json:"label,omitempty"        yaml:"label"`
+	Number       int                 `json:"number"                 yaml:"number"`
+	Size         PartitionDimension  `json:"size"                   yaml:"size"`
+	Start        PartitionDimension  `json:"start"                  yaml:"start"`
+	TypeGUID     PartitionTypeGUID   `json:"typeGuid,omitempty"     yaml:"type_guid"`
+	GUID         PartitionGUID       `json:"guid,omitempty"         yaml:"guid"`
+	Attributes   PartitionAttributes `json:"attributes,omitempty"   yaml:"attributes"`
+	StartPercent uint                `json:"startPercent,omitempty" yaml:"start_percent"`
+	SizePercent  uint                `json:"sizePercent,omitempty"  yaml:"size_percent"`
+	ShouldExist  bool                `json:"shouldExist,omitempty"  yaml:"should_exist"`
+	MaxSize      PartitionDimension  `json:"maxSize,omitempty"      yaml:"max_size"`
+}
+
+// PartitionSelector picks out existing partitions, such as an OEM partition,
+// by label, type GUID or both.
+type PartitionSelector struct {
+	Label    PartitionLabel    `json:"label,omitempty"    yaml:"label"`
+	TypeGUID PartitionTypeGUID `json:"typeGuid,omitempty" yaml:"type_guid"`
 }
 
 type PartitionLabel string
@@ -102,6 +118,10 @@ func (n *PartitionDimension) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// PartitionTypeGUID is either a GPT type GUID, an MBR type code such as "83",
+// or the common name of a type, such as "linux" or "swap". Names are resolved
+// for the disk's table type, and unknown ones refused, before the disk is
+// partitioned.
 type PartitionTypeGUID string
 
 func (d *PartitionTypeGUID) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -130,8 +150,83 @@ func (d PartitionTypeGUID) assertValid() error {
 	if err != nil {
 		return fmt.Errorf("error matching type-guid regexp: %v", err)
 	}
+	if !ok && !partitionTypeNameRegexp.MatchString(string(d)) && !mbrTypeCodeRegexp.MatchString(string(d)) {
+		return fmt.Errorf(`partition type-guid must have the form "01234567-89AB-CDEF-EDCB-A98765432101", be an mbr type code such as "83" or name a type such as "linux", got: %q`, string(d))
+	}
+	return nil
+}
+
+// partitionTypeNameRegexp matches the names which may stand in for a type
+// GUID. Which names are known is up to the partitioner.
+var partitionTypeNameRegexp = regexp.MustCompile("^[a-zA-Z][a-zA-Z0-9-]*$")
+
+// mbrTypeCodeRegexp matches the one byte type codes of MBR partitions.
+var mbrTypeCodeRegexp = regexp.MustCompile("^[[:xdigit:]]{2}$")
+
+type PartitionGUID string
+
+func (d *PartitionGUID) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return d.unmarshal(unmarshal)
+}
+
+func (d *PartitionGUID) UnmarshalJSON(data []byte) error {
+	return d.unmarshal(func(td interface{}) error {
+		return json.Unmarshal(data, td)
+	})
+}
+
+type partitionGUID PartitionGUID
+
+func (d *PartitionGUID) unmarshal(unmarshal func(interface{}) error) error {
+	td := partitionGUID(*d)
+	if err := unmarshal(&td); err != nil {
+		return err
+	}
+	*d = PartitionGUID(td)
+	return d.assertValid()
+}
+
+func (d PartitionGUID) assertValid() error {
+	ok, err := regexp.MatchString("^[[:xdigit:]]{8}-[[:xdigit:]]{4}-[[:xdigit:]]{4}-[[:xdigit:]]{4}-[[:xdigit:]]{12}$", string(d))
+	if err != nil {
+		return fmt.Errorf("error matching guid regexp: %v", err)
+	}
 	if !ok {
-		return fmt.Errorf(`partition type-guid must have the form "01234567-89AB-CDEF-EDCB-A98765432101", got: %q`, string(d))
+		return fmt.Errorf(`partition guid must have the form "01234567-89AB-CDEF-EDCB-A98765432101", got: %q`, string(d))
+	}
+	return nil
+}
+
+// PartitionAttributes lists the GPT attribute bits to set on a partition.
+type PartitionAttributes []uint
+
+func (a *PartitionAttributes) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return a.unmarshal(unmarshal)
+}
+
+func (a *PartitionAttributes) UnmarshalJSON(data []byte) error {
+	return a.unmarshal(func(ta interface{}) error {
+		return json.Unmarshal(data, ta)
+	})
+}
+
+type partitionAttributes PartitionAttributes
+
+func (a *PartitionAttributes) unmarshal(unmarshal func(interface{}) error) error {
+	ta := partitionAttributes(*a)
+	if err := unmarshal(&ta); err != nil {
+		return err
+	}
+	*a = PartitionAttributes(ta)
+	return a.assertValid()
+}
+
+func (a PartitionAttributes) assertValid() error {
+	// GPT partition entries hold a 64-bit attribute field
+	for _, bit := range a {
+		if bit > 63 {
+			return fmt.Errorf("partition attribute bits must be between 0 and 63, got: %d", bit)
+		}
 	}
 	return nil
 }
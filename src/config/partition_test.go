@@ -0,0 +1,133 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPartitionGUIDUnmarshalJSON(t *testing.T) {
+	type in struct {
+		data string
+	}
+	type out struct {
+		guid PartitionGUID
+		err  error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{data: `"6BB2F1C7-8F3E-4B5B-9D2A-0F3C7E1A2B4D"`},
+			out: out{guid: PartitionGUID("6BB2F1C7-8F3E-4B5B-9D2A-0F3C7E1A2B4D")},
+		},
+		{
+			in:  in{data: `"bad"`},
+			out: out{guid: PartitionGUID("bad"), err: errors.New(`partition guid must have the form "01234567-89AB-CDEF-EDCB-A98765432101", got: "bad"`)},
+		},
+	}
+
+	for i, test := range tests {
+		var guid PartitionGUID
+		err := json.Unmarshal([]byte(test.in.data), &guid)
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+		if !reflect.DeepEqual(test.out.guid, guid) {
+			t.Errorf("#%d: bad guid: want %#v, got %#v", i, test.out.guid, guid)
+		}
+	}
+}
+
+func TestPartitionAttributesAssertValid(t *testing.T) {
+	type in struct {
+		attributes PartitionAttributes
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{attributes: PartitionAttributes{2, 63}},
+			out: out{},
+		},
+		{
+			in:  in{attributes: PartitionAttributes{64}},
+			out: out{err: errors.New("partition attribute bits must be between 0 and 63, got: 64")},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.attributes.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
+
+func TestPartitionTypeGUIDAssertValid(t *testing.T) {
+	type in struct {
+		typeGUID PartitionTypeGUID
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{typeGUID: "0FC63DAF-8483-4772-8E79-3D69D8477DE4"},
+			out: out{},
+		},
+		{
+			in:  in{typeGUID: "linux"},
+			out: out{},
+		},
+		{
+			in:  in{typeGUID: "83"},
+			out: out{},
+		},
+		{
+			in:  in{typeGUID: "0FC63DAF-8483"},
+			out: out{err: errors.New(`partition type-guid must have the form "01234567-89AB-CDEF-EDCB-A98765432101", be an mbr type code such as "83" or name a type such as "linux", got: "0FC63DAF-8483"`)},
+		},
+		{
+			in:  in{typeGUID: "linux filesystem"},
+			out: out{err: errors.New(`partition type-guid must have the form "01234567-89AB-CDEF-EDCB-A98765432101", be an mbr type code such as "83" or name a type such as "linux", got: "linux filesystem"`)},
+		},
+		{
+			in:  in{typeGUID: "183"},
+			out: out{err: errors.New(`partition type-guid must have the form "01234567-89AB-CDEF-EDCB-A98765432101", be an mbr type code such as "83" or name a type such as "linux", got: "183"`)},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.typeGUID.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type Passwd struct {
+	Groups []Group `json:"groups,omitempty" yaml:"groups"`
+	Users  []User  `json:"users,omitempty"  yaml:"users"`
+}
+
+type Group struct {
+	Name   string `json:"name"             yaml:"name"`
+	Gid    *uint  `json:"gid,omitempty"    yaml:"gid"`
+	System bool   `json:"system,omitempty" yaml:"system"`
+}
+
+type User struct {
+	Name              string   `json:"name"                        yaml:"name"`
+	Uid               *uint    `json:"uid,omitempty"               yaml:"uid"`
+	PrimaryGroup      string   `json:"primaryGroup,omitempty"      yaml:"primary_group"`
+	HomeDir           string   `json:"homeDir,omitempty"           yaml:"home_dir"`
+	Shell             string   `json:"shell,omitempty"             yaml:"shell"`
+	System            bool     `json:"system,omitempty"            yaml:"system"`
+	PasswordHash      string   `json:"passwordHash,omitempty"      yaml:"password_hash"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty" yaml:"ssh_authorized_keys"`
+}
+
+// passwordHashRegexp matches crypt(3) strings such as "$6$salt$hash".
+var passwordHashRegexp = regexp.MustCompile(`^\$[0-9a-z]+\$[./0-9A-Za-z$=,]+$`)
+
+// userNameRegexp matches the names accepted by the default NAME_REGEX of
+// useradd and groupadd.
+var userNameRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_-]*\$?$`)
+
+func (u *User) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return u.unmarshal(unmarshal)
+}
+
+func (u *User) UnmarshalJSON(data []byte) error {
+	return u.unmarshal(func(tu interface{}) error {
+		return json.Unmarshal(data, tu)
+	})
+}
+
+type user User
+
+func (u *User) unmarshal(unmarshal func(interface{}) error) error {
+	tu := user(*u)
+	if err := unmarshal(&tu); err != nil {
+		return err
+	}
+	*u = User(tu)
+	return u.assertValid()
+}
+
+func (u User) assertValid() error {
+	if u.Name == "" {
+		return fmt.Errorf("user name is required")
+	}
+	if len(u.Name) > 32 || !userNameRegexp.MatchString(u.Name) {
+		return fmt.Errorf("invalid user name: %q", u.Name)
+	}
+	if u.HomeDir != "" && !filepath.IsAbs(u.HomeDir) {
+		return fmt.Errorf("user %q: home directory must be absolute, got: %q", u.Name, u.HomeDir)
+	}
+	if u.Shell != "" && !filepath.IsAbs(u.Shell) {
+		return fmt.Errorf("user %q: shell must be absolute, got: %q", u.Name, u.Shell)
+	}
+	// the hash itself is left out of the error, it's nobody's business
+	if u.PasswordHash != "" && !passwordHashRegexp.MatchString(u.PasswordHash) {
+		return fmt.Errorf("user %q: password hash must be a crypt string", u.Name)
+	}
+	for _, key := range u.SSHAuthorizedKeys {
+		if strings.TrimSpace(key) == "" || strings.ContainsAny(key, "\r\n") {
+			return fmt.Errorf("user %q: ssh authorized keys must each be a single non-empty line", u.Name)
+		}
+	}
+	return nil
+}
+
+func (g *Group) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return g.unmarshal(unmarshal)
+}
+
+func (g *Group) UnmarshalJSON(data []byte) error {
+	return g.unmarshal(func(tg interface{}) error {
+		return json.Unmarshal(data, tg)
+	})
+}
+
+type group Group
+
+func (g *Group) unmarshal(unmarshal func(interface{}) error) error {
+	tg := group(*g)
+	if err := unmarshal(&tg); err != nil {
+		return err
+	}
+	*g = Group(tg)
+	return g.assertValid()
+}
+
+func (g Group) assertValid() error {
+	if g.Name == "" {
+		return fmt.Errorf("group name is required")
+	}
+	if len(g.Name) > 32 || !userNameRegexp.MatchString(g.Name) {
+		return fmt.Errorf("invalid group name: %q", g.Name)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestUserAssertValid(t *testing.T) {
+	type in struct {
+		user User
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{user: User{Name: "core", HomeDir: "/home/core", Shell: "/bin/bash"}},
+			out: out{},
+		},
+		{
+			in:  in{user: User{}},
+			out: out{err: errors.New("user name is required")},
+		},
+		{
+			in:  in{user: User{Name: "Core"}},
+			out: out{err: errors.New(`invalid user name: "Core"`)},
+		},
+		{
+			in:  in{user: User{Name: "core", HomeDir: "home/core"}},
+			out: out{err: errors.New(`user "core": home directory must be absolute, got: "home/core"`)},
+		},
+		{
+			in:  in{user: User{Name: "core", Shell: "bash"}},
+			out: out{err: errors.New(`user "core": shell must be absolute, got: "bash"`)},
+		},
+		{
+			in:  in{user: User{Name: "core", PasswordHash: "$6$rounds=4096$saltsalt$HdE9P8T1TBKCFoCk0Hbbg3hCV.CLd8vyOwtSmd0xWrfyHWKDLzNVzUK.ehs9cUMv2Sr3oWXE6zTvIPAkZ8Qj31"}},
+			out: out{},
+		},
+		{
+			in:  in{user: User{Name: "core", PasswordHash: "hunter2"}},
+			out: out{err: errors.New(`user "core": password hash must be a crypt string`)},
+		},
+		{
+			in:  in{user: User{Name: "core", SSHAuthorizedKeys: []string{"ssh-ed25519 AAAA core@host"}}},
+			out: out{},
+		},
+		{
+			in:  in{user: User{Name: "core", SSHAuthorizedKeys: []string{"ssh-rsa AAAA\nssh-rsa BBBB"}}},
+			out: out{err: errors.New(`user "core": ssh authorized keys must each be a single non-empty line`)},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.user.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
+
+func TestGroupAssertValid(t *testing.T) {
+	type in struct {
+		group Group
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{group: Group{Name: "docker", System: true}},
+			out: out{},
+		},
+		{
+			in:  in{group: Group{}},
+			out: out{err: errors.New("group name is required")},
+		},
+		{
+			in:  in{group: Group{Name: "9docker"}},
+			out: out{err: errors.New(`invalid group name: "9docker"`)},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.group.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
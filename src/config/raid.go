@@ -17,13 +17,20 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"strings"
 )
 
 type Raid struct {
-	Name    string       `json:"name"              yaml:"name"`
-	Level   string       `json:"level"             yaml:"level"`
-	Devices []DevicePath `json:"devices,omitempty" yaml:"devices"`
-	Spares  int          `json:"spares,omitempty"  yaml:"spares"`
+	Name        string       `json:"name"                  yaml:"name"`
+	Level       string       `json:"level"                 yaml:"level"`
+	Devices     []RaidDevice `json:"devices,omitempty"     yaml:"devices"`
+	Spares      int          `json:"spares,omitempty"      yaml:"spares"`
+	Metadata    RaidMetadata `json:"metadata,omitempty"    yaml:"metadata"`
+	Bitmap      RaidBitmap   `json:"bitmap,omitempty"      yaml:"bitmap"`
+	WaitForSync bool         `json:"waitForSync,omitempty" yaml:"wait_for_sync"`
+	Mode        RaidMode     `json:"mode,omitempty"        yaml:"mode"`
+	SpareGroup  string       `json:"spareGroup,omitempty"  yaml:"spare_group"`
 }
 
 func (n *Raid) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -48,18 +55,164 @@ func (n *Raid) unmarshal(unmarshal func(interface{}) error) error {
 }
 
 func (n Raid) assertValid() error {
+	members := len(n.Devices) - n.Spares
+	maxMissing := 0
 	switch n.Level {
 	case "linear", "raid0", "0", "stripe":
 		if n.Spares != 0 {
 			return fmt.Errorf("spares unsupported for %q arrays", n.Level)
 		}
+		if n.SpareGroup != "" {
+			return fmt.Errorf("spare groups unsupported for %q arrays", n.Level)
+		}
 	case "raid1", "1", "mirror":
+		maxMissing = members - 1
 	case "raid4", "4":
+		maxMissing = 1
 	case "raid5", "5":
+		maxMissing = 1
 	case "raid6", "6":
+		maxMissing = 2
 	case "raid10", "10":
+		maxMissing = members / 2
 	default:
 		return fmt.Errorf("unrecognized raid level: %q", n.Level)
 	}
+
+	// mdadm treats the trailing devices as spares, which can't be missing
+	missing := 0
+	for i, d := range n.Devices {
+		if d != RaidDeviceMissing {
+			continue
+		}
+		if i >= members {
+			return fmt.Errorf("raid spares may not be missing")
+		}
+		missing++
+	}
+	if missing > 0 && missing > maxMissing {
+		return fmt.Errorf("%q arrays of %d devices support at most %d missing, got: %d", n.Level, members, maxMissing, missing)
+	}
+
+	if err := n.Metadata.assertValid(); err != nil {
+		return err
+	}
+	if err := n.Mode.assertValid(); err != nil {
+		return err
+	}
+	if err := n.Bitmap.assertValid(); err != nil {
+		return err
+	}
+	if n.Bitmap == "internal" && !n.Metadata.supportsInternalBitmap() {
+		return fmt.Errorf("internal bitmaps unsupported with %q metadata", n.Metadata)
+	}
+	if strings.ContainsAny(n.SpareGroup, " \t\n") {
+		return fmt.Errorf("raid spare group may not contain whitespace, got: %q", n.SpareGroup)
+	}
+	return nil
+}
+
+// level returns the array's level by its canonical name, so that levels
+// given by their aliases compare equal.
+func (n Raid) level() string {
+	switch n.Level {
+	case "0", "stripe":
+		return "raid0"
+	case "1", "mirror":
+		return "raid1"
+	case "4", "5", "6", "10":
+		return "raid" + n.Level
+	default:
+		return n.Level
+	}
+}
+
+// RaidDeviceMissing stands in for an array member which will be added later,
+// creating the array degraded.
+const RaidDeviceMissing = RaidDevice("missing")
+
+// RaidDevice is an array member: either a device path or RaidDeviceMissing.
+type RaidDevice string
+
+func (d *RaidDevice) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return d.unmarshal(unmarshal)
+}
+
+func (d *RaidDevice) UnmarshalJSON(data []byte) error {
+	return d.unmarshal(func(td interface{}) error {
+		return json.Unmarshal(data, td)
+	})
+}
+
+type raidDevice RaidDevice
+
+func (d *RaidDevice) unmarshal(unmarshal func(interface{}) error) error {
+	td := raidDevice(*d)
+	if err := unmarshal(&td); err != nil {
+		return err
+	}
+	*d = RaidDevice(td)
+	return d.assertValid()
+}
+
+func (d RaidDevice) assertValid() error {
+	if d == RaidDeviceMissing {
+		return nil
+	}
+	return DevicePath(d).assertValid()
+}
+
+// RaidMode selects what happens to an array whose members already carry md
+// superblocks: by default it is created afresh, while RaidModeAssemble
+// reattaches to the existing array instead. Arrays without superblocks are
+// always created.
+type RaidMode string
+
+const (
+	RaidModeCreate   = RaidMode("create")
+	RaidModeAssemble = RaidMode("assemble")
+)
+
+func (m RaidMode) assertValid() error {
+	switch m {
+	case "", RaidModeCreate, RaidModeAssemble:
+		return nil
+	}
+	return fmt.Errorf("unrecognized raid mode: %q", m)
+}
+
+// RaidMetadata is the md superblock format, as accepted by mdadm --metadata.
+type RaidMetadata string
+
+func (m RaidMetadata) assertValid() error {
+	switch m {
+	case "", "default":
+	case "0", "0.90":
+	case "1", "1.0", "1.1", "1.2":
+	case "ddf", "imsm":
+	default:
+		return fmt.Errorf("unrecognized raid metadata version: %q", m)
+	}
+	return nil
+}
+
+// supportsInternalBitmap reports whether md keeps an internal write-intent
+// bitmap for arrays with this metadata. Container formats manage their own.
+func (m RaidMetadata) supportsInternalBitmap() bool {
+	return m != "ddf" && m != "imsm"
+}
+
+// RaidBitmap is the write-intent bitmap for an array: "internal", "none" or
+// the absolute path of an external bitmap file.
+type RaidBitmap string
+
+func (b RaidBitmap) assertValid() error {
+	switch b {
+	case "", "internal", "none":
+		return nil
+	}
+	if !filepath.IsAbs(string(b)) {
+		return fmt.Errorf("raid bitmap must be \"internal\", \"none\" or an absolute path, got: %q", b)
+	}
 	return nil
 }
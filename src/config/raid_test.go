@@ -0,0 +1,107 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRaidAssertValid(t *testing.T) {
+	type in struct {
+		raid Raid
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid1"}},
+			out: out{},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid1", Metadata: "1.0"}},
+			out: out{},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid1", Metadata: "2.0"}},
+			out: out{err: errors.New(`unrecognized raid metadata version: "2.0"`)},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid1", Bitmap: "internal"}},
+			out: out{},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid1", Metadata: "imsm", Bitmap: "internal"}},
+			out: out{err: errors.New(`internal bitmaps unsupported with "imsm" metadata`)},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid1", SpareGroup: "pool"}},
+			out: out{},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid0", SpareGroup: "pool"}},
+			out: out{err: errors.New(`spare groups unsupported for "raid0" arrays`)},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid1", SpareGroup: "spare pool"}},
+			out: out{err: errors.New(`raid spare group may not contain whitespace, got: "spare pool"`)},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid1", Bitmap: "bitmap"}},
+			out: out{err: errors.New(`raid bitmap must be "internal", "none" or an absolute path, got: "bitmap"`)},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid5", Devices: []RaidDevice{"/dev/sda", "missing", "/dev/sdc"}}},
+			out: out{},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid5", Devices: []RaidDevice{"/dev/sda", "missing", "missing"}}},
+			out: out{err: errors.New(`"raid5" arrays of 3 devices support at most 1 missing, got: 2`)},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid0", Devices: []RaidDevice{"/dev/sda", "missing"}}},
+			out: out{err: errors.New(`"raid0" arrays of 2 devices support at most 0 missing, got: 1`)},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid1", Devices: []RaidDevice{"/dev/sda", "/dev/sdb", "missing"}, Spares: 1}},
+			out: out{err: errors.New("raid spares may not be missing")},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid1", Mode: "assemble"}},
+			out: out{},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid1", Mode: "adopt"}},
+			out: out{err: errors.New(`unrecognized raid mode: "adopt"`)},
+		},
+		{
+			in:  in{raid: Raid{Name: "md0", Level: "raid7"}},
+			out: out{err: errors.New(`unrecognized raid level: "raid7"`)},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.raid.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
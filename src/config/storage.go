@@ -15,7 +15,9 @@
 package config
 
 type Storage struct {
-	Disks       []Disk       `json:"disks,omitempty"       yaml:"disks"`
-	Arrays      []Raid       `json:"raid,omitempty"        yaml:"raid"`
-	Filesystems []Filesystem `json:"filesystems,omitempty" yaml:"filesystems"`
+	Disks        []Disk        `json:"disks,omitempty"        yaml:"disks"`
+	Arrays       []Raid        `json:"raid,omitempty"         yaml:"raid"`
+	VolumeGroups []VolumeGroup `json:"volumeGroups,omitempty" yaml:"volume_groups"`
+	Luks         []Luks        `json:"luks,omitempty"         yaml:"luks"`
+	Filesystems  []Filesystem  `json:"filesystems,omitempty"  yaml:"filesystems"`
 }
@@ -15,5 +15,6 @@
 package config
 
 type Systemd struct {
-	Units []SystemdUnit `json:"units,omitempty" yaml:"units"`
+	Units    []SystemdUnit `json:"units,omitempty"    yaml:"units"`
+	Commands []BootCommand `json:"commands,omitempty" yaml:"commands"`
 }
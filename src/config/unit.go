@@ -21,11 +21,42 @@ import (
 )
 
 type SystemdUnit struct {
-	Name     SystemdUnitName     `json:"name,omitempty"     yaml:"name"`
-	Enable   bool                `json:"enable,omitempty"   yaml:"enable"`
-	Mask     bool                `json:"mask,omitempty"     yaml:"mask"`
-	Contents string              `json:"contents,omitempty" yaml:"contents"`
-	DropIns  []SystemdUnitDropIn `json:"dropins,omitempty"  yaml:"dropins"`
+	Name      SystemdUnitName     `json:"name,omitempty"      yaml:"name"`
+	Enable    bool                `json:"enable,omitempty"    yaml:"enable"`
+	Disable   bool                `json:"disable,omitempty"   yaml:"disable"`
+	Mask      bool                `json:"mask,omitempty"      yaml:"mask"`
+	Runtime   bool                `json:"runtime,omitempty"   yaml:"runtime"`
+	Contents  string              `json:"contents,omitempty"  yaml:"contents"`
+	DropIns   []SystemdUnitDropIn `json:"dropins,omitempty"   yaml:"dropins"`
+	Condition *Condition          `json:"condition,omitempty" yaml:"condition"`
+}
+
+func (u *SystemdUnit) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return u.unmarshal(unmarshal)
+}
+
+func (u *SystemdUnit) UnmarshalJSON(data []byte) error {
+	return u.unmarshal(func(tu interface{}) error {
+		return json.Unmarshal(data, tu)
+	})
+}
+
+type systemdUnit SystemdUnit
+
+func (u *SystemdUnit) unmarshal(unmarshal func(interface{}) error) error {
+	tu := systemdUnit(*u)
+	if err := unmarshal(&tu); err != nil {
+		return err
+	}
+	*u = SystemdUnit(tu)
+	return u.assertValid()
+}
+
+func (u SystemdUnit) assertValid() error {
+	if u.Enable && u.Disable {
+		return errors.New("systemd unit cannot be both enabled and disabled")
+	}
+	return nil
 }
 
 type SystemdUnitDropIn struct {
@@ -90,7 +121,7 @@ func (n *SystemdUnitDropInName) unmarshal(unmarshal func(interface{}) error) err
 
 func (n SystemdUnitDropInName) assertValid() error {
 	switch filepath.Ext(string(n)) {
-	case "conf":
+	case ".conf":
 		return nil
 	default:
 		return errors.New("invalid systemd unit drop-in extension")
@@ -98,8 +129,14 @@ func (n SystemdUnitDropInName) assertValid() error {
 }
 
 type NetworkdUnit struct {
-	Name     NetworkdUnitName `json:"name,omitempty"     yaml:"name"`
-	Contents string           `json:"contents,omitempty" yaml:"contents"`
+	Name     NetworkdUnitName     `json:"name,omitempty"     yaml:"name"`
+	Contents string               `json:"contents,omitempty" yaml:"contents"`
+	DropIns  []NetworkdUnitDropIn `json:"dropins,omitempty"  yaml:"dropins"`
+}
+
+type NetworkdUnitDropIn struct {
+	Name     SystemdUnitDropInName `json:"name,omitempty"     yaml:"name"`
+	Contents string                `json:"contents,omitempty" yaml:"contents"`
 }
 
 type NetworkdUnitName string
@@ -202,3 +202,72 @@ func TestNetworkdUnitNameUnmarshalYAML(t *testing.T) {
 		}
 	}
 }
+
+func TestSystemdUnitAssertValid(t *testing.T) {
+	type in struct {
+		unit SystemdUnit
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{unit: SystemdUnit{Name: "test.service", Enable: true}},
+			out: out{},
+		},
+		{
+			in:  in{unit: SystemdUnit{Name: "test.service", Disable: true}},
+			out: out{},
+		},
+		{
+			in:  in{unit: SystemdUnit{Name: "test.service", Enable: true, Disable: true}},
+			out: out{err: errors.New("systemd unit cannot be both enabled and disabled")},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.unit.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
+
+func TestSystemdUnitDropInNameUnmarshalJSON(t *testing.T) {
+	type in struct {
+		data string
+	}
+	type out struct {
+		name SystemdUnitDropInName
+		err  error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{data: `"10-override.conf"`},
+			out: out{name: SystemdUnitDropInName("10-override.conf")},
+		},
+		{
+			in:  in{data: `"10-override.network"`},
+			out: out{name: SystemdUnitDropInName("10-override.network"), err: errors.New("invalid systemd unit drop-in extension")},
+		},
+	}
+
+	for i, test := range tests {
+		var name SystemdUnitDropInName
+		err := json.Unmarshal([]byte(test.in.data), &name)
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+		if !reflect.DeepEqual(test.out.name, name) {
+			t.Errorf("#%d: bad name: want %#v, got %#v", i, test.out.name, name)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ValidationError holds every problem Validate found in a config.
+type ValidationError []error
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("invalid config: %s", strings.Join(msgs, "; "))
+}
+
+// Validate checks the config as a whole, rechecking each section and the
+// references between them (devices claimed twice, duplicate names, relative
+// paths). Unlike parsing, it doesn't stop at the first problem: all of them
+// are returned in a ValidationError so they can be fixed in one go. The
+// engine runs it before any stage, so nothing is modified on the basis of a
+// config which would fail partway through.
+func (c Config) Validate() error {
+	v := validator{}
+	v.report(assertVersion(c.Version))
+	v.validateIgnition(c.Ignition)
+	v.validateStorage(c.Storage)
+	v.validateSystemd(c.Systemd)
+	v.validateNetworkd(c.Networkd)
+	v.validatePasswd(c.Passwd)
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+type validator struct {
+	errs ValidationError
+}
+
+func (v *validator) report(err error) {
+	if err != nil {
+		v.errs = append(v.errs, err)
+	}
+}
+
+func (v *validator) reportf(format string, a ...interface{}) {
+	v.report(fmt.Errorf(format, a...))
+}
+
+func (v *validator) validateIgnition(i Ignition) {
+	v.report(i.Config.assertValid())
+	refs := i.Config.Append
+	if i.Config.Replace != nil {
+		refs = append(refs, *i.Config.Replace)
+	}
+	for _, r := range refs {
+		v.report(r.assertValid())
+		v.report(r.Verification.Hash.assertValid())
+	}
+}
+
+func (v *validator) validateStorage(s Storage) {
+	// every device may be put to only one use
+	users := map[DevicePath]string{}
+	claim := func(dev DevicePath, user string) {
+		if prev, ok := users[dev]; ok {
+			v.reportf("device %q used by both %s and %s", dev, prev, user)
+			return
+		}
+		users[dev] = user
+	}
+
+	for _, d := range s.Disks {
+		v.report(d.assertValid())
+		if _, _, ok := d.Device.Tag(); ok {
+			v.reportf("disk %q must be referenced by path", d.Device)
+		}
+		if len(d.Partitions) != 0 {
+			claim(d.Device, "a partition table")
+		}
+	}
+	disks := map[DevicePath]bool{}
+	for _, d := range s.Disks {
+		if disks[d.Device] {
+			v.reportf("disk %q listed more than once", d.Device)
+		}
+		disks[d.Device] = true
+	}
+
+	arrays := map[string]bool{}
+	spareGroups := map[string]Raid{}
+	for _, r := range s.Arrays {
+		// spares only move between arrays which can use them alike
+		if r.SpareGroup != "" {
+			if first, ok := spareGroups[r.SpareGroup]; !ok {
+				spareGroups[r.SpareGroup] = r
+			} else if first.level() != r.level() {
+				v.reportf("raid %q: level %q differs from the %q of raid %q in spare group %q", r.Name, r.Level, first.Level, first.Name, r.SpareGroup)
+			}
+		}
+		v.report(r.assertValid())
+		if r.Name == "" {
+			v.reportf("raid name is required")
+		} else if arrays[r.Name] {
+			v.reportf("raid %q defined more than once", r.Name)
+		}
+		arrays[r.Name] = true
+		members := map[RaidDevice]bool{}
+		for _, d := range r.Devices {
+			if d == RaidDeviceMissing {
+				continue
+			}
+			v.report(d.assertValid())
+			if _, _, ok := DevicePath(d).Tag(); ok {
+				v.reportf("raid %q: member %q must be referenced by path", r.Name, d)
+			}
+			if members[d] {
+				v.reportf("raid %q: member %q listed more than once", r.Name, d)
+				continue
+			}
+			members[d] = true
+			claim(DevicePath(d), fmt.Sprintf("raid %q", r.Name))
+		}
+	}
+
+	groups := map[string]bool{}
+	for _, g := range s.VolumeGroups {
+		v.report(g.assertValid())
+		if groups[g.Name] {
+			v.reportf("volume group %q defined more than once", g.Name)
+		}
+		groups[g.Name] = true
+		for _, d := range g.Devices {
+			if _, _, ok := d.Tag(); ok {
+				v.reportf("volume group %q: member %q must be referenced by path", g.Name, d)
+			}
+			claim(d, fmt.Sprintf("volume group %q", g.Name))
+		}
+	}
+
+	volumes := map[string]bool{}
+	for _, l := range s.Luks {
+		v.report(l.assertValid())
+		if l.Device != "" {
+			v.report(l.Device.assertValid())
+		}
+		if volumes[l.Name] {
+			v.reportf("luks volume %q defined more than once", l.Name)
+		}
+		volumes[l.Name] = true
+		if _, _, ok := l.Device.Tag(); ok {
+			v.reportf("luks volume %q: device %q must be referenced by path", l.Name, l.Device)
+		}
+		claim(l.Device, fmt.Sprintf("luks volume %q", l.Name))
+	}
+
+	named := map[string]Filesystem{}
+	for _, f := range s.Filesystems {
+		if f.Name == "" {
+			continue
+		}
+		if _, ok := named[f.Name]; ok {
+			v.reportf("filesystem %q defined more than once", f.Name)
+		}
+		named[f.Name] = f
+	}
+
+	mounts := map[string]bool{}
+	for _, f := range s.Filesystems {
+		if f.Device == "" {
+			v.reportf("filesystem device is required")
+			continue
+		}
+		v.report(f.Device.assertValid())
+		v.report(f.assertValid())
+		claim(f.Device, "a filesystem")
+		if f.MountPoint != "" {
+			if mounts[f.MountPoint] {
+				v.reportf("mount point %q used by more than one filesystem", f.MountPoint)
+			}
+			mounts[f.MountPoint] = true
+		}
+		v.validateContents(f)
+		v.validateTargets(f, named)
+	}
+}
+
+// validateTargets checks the files of f which are written to another
+// filesystem name one which can hold them.
+func (v *validator) validateTargets(f Filesystem, named map[string]Filesystem) {
+	for _, file := range f.Files {
+		if file.Filesystem == "" {
+			continue
+		}
+		target, ok := named[file.Filesystem]
+		switch {
+		case !ok:
+			v.reportf("filesystem %q: file %q targets unknown filesystem %q", f.Device, file.Path, file.Filesystem)
+		case target.Format == "swap":
+			v.reportf("filesystem %q: file %q targets filesystem %q: %v", f.Device, file.Path, file.Filesystem, ErrFilesystemSwapFiles)
+		case target.MountFlags.has("ro"):
+			v.reportf("filesystem %q: file %q targets filesystem %q: %v", f.Device, file.Path, file.Filesystem, ErrFilesystemReadOnlyFiles)
+		}
+	}
+}
+
+func (v *validator) validateContents(f Filesystem) {
+	paths := map[string]bool{}
+	check := func(kind, path string) {
+		if !filepath.IsAbs(path) {
+			v.reportf("filesystem %q: %s path %q not absolute", f.Device, kind, path)
+		}
+		if paths[path] {
+			v.reportf("filesystem %q: path %q listed more than once", f.Device, path)
+		}
+		paths[path] = true
+	}
+
+	for _, d := range f.Directories {
+		check("directory", d.Path)
+		v.report(d.Mode.assertValid())
+	}
+	for _, file := range f.Files {
+		// appending to a file more than once is fine
+		if file.Append {
+			if !filepath.IsAbs(file.Path) {
+				v.reportf("filesystem %q: file path %q not absolute", f.Device, file.Path)
+			}
+		} else {
+			check("file", file.Path)
+		}
+		v.report(file.assertValid())
+	}
+	for _, l := range f.Links {
+		check("link", l.Path)
+		if l.Target == "" {
+			v.reportf("filesystem %q: link %q has no target", f.Device, l.Path)
+		}
+	}
+	// removals happen first, so the paths may be written again afterwards
+	for _, r := range f.Remove {
+		if !filepath.IsAbs(r.Path) {
+			v.reportf("filesystem %q: removal path %q not absolute", f.Device, r.Path)
+		} else if filepath.Clean(r.Path) == "/" {
+			v.reportf("filesystem %q: refusing to remove the root", f.Device)
+		}
+	}
+}
+
+func (v *validator) validateSystemd(s Systemd) {
+	names := map[SystemdUnitName]bool{}
+	for _, u := range s.Units {
+		v.report(u.Name.assertValid())
+		v.report(u.assertValid())
+		if names[u.Name] {
+			v.reportf("systemd unit %q defined more than once", u.Name)
+		}
+		names[u.Name] = true
+
+		dropins := map[SystemdUnitDropInName]bool{}
+		for _, d := range u.DropIns {
+			v.report(d.Name.assertValid())
+			if dropins[d.Name] {
+				v.reportf("systemd unit %q: dropin %q defined more than once", u.Name, d.Name)
+			}
+			dropins[d.Name] = true
+		}
+	}
+	// boot commands are written as units of their own name
+	for _, c := range s.Commands {
+		v.report(c.assertValid())
+		if names[c.Name] {
+			v.reportf("boot command %q clashes with another unit of that name", c.Name)
+		}
+		names[c.Name] = true
+	}
+}
+
+func (v *validator) validateNetworkd(n Networkd) {
+	names := map[NetworkdUnitName]bool{}
+	for _, u := range n.Units {
+		v.report(u.Name.assertValid())
+		if names[u.Name] {
+			v.reportf("networkd unit %q defined more than once", u.Name)
+		}
+		names[u.Name] = true
+
+		dropins := map[SystemdUnitDropInName]bool{}
+		for _, d := range u.DropIns {
+			v.report(d.Name.assertValid())
+			if dropins[d.Name] {
+				v.reportf("networkd unit %q: dropin %q defined more than once", u.Name, d.Name)
+			}
+			dropins[d.Name] = true
+		}
+	}
+}
+
+func (v *validator) validatePasswd(p Passwd) {
+	groups := map[string]bool{}
+	for _, g := range p.Groups {
+		v.report(g.assertValid())
+		if groups[g.Name] {
+			v.reportf("group %q defined more than once", g.Name)
+		}
+		groups[g.Name] = true
+	}
+
+	users := map[string]bool{}
+	for _, u := range p.Users {
+		v.report(u.assertValid())
+		if users[u.Name] {
+			v.reportf("user %q defined more than once", u.Name)
+		}
+		users[u.Name] = true
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	type in struct {
+		config Config
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{config: Config{Version: 1}},
+			out: out{},
+		},
+		{
+			in:  in{config: Config{Version: 2}},
+			out: out{err: ValidationError{VersionError{Version: 2}}},
+		},
+		{
+			in: in{config: Config{Version: 1, Storage: Storage{
+				Disks:       []Disk{{Device: "/dev/sda", Partitions: []Partition{{Number: 1}}}},
+				Arrays:      []Raid{{Name: "md0", Level: "raid1", Devices: []RaidDevice{"/dev/sdb", "/dev/sdc"}}},
+				Filesystems: []Filesystem{{Device: "/dev/md/md0", Format: "ext4", MountPoint: "/var"}},
+			}}},
+			out: out{},
+		},
+		{
+			in: in{config: Config{Version: 1, Storage: Storage{
+				Disks:       []Disk{{Device: "/dev/sda", Partitions: []Partition{{Number: 1}}}},
+				Arrays:      []Raid{{Name: "md0", Level: "raid1", Devices: []RaidDevice{"/dev/sda", "/dev/sdb"}}},
+				Filesystems: []Filesystem{{Device: "/dev/sdb", Format: "ext4"}},
+			}}},
+			out: out{err: ValidationError{
+				errors.New(`device "/dev/sda" used by both a partition table and raid "md0"`),
+				errors.New(`device "/dev/sdb" used by both raid "md0" and a filesystem`),
+			}},
+		},
+		{
+			in: in{config: Config{Version: 1, Storage: Storage{
+				Arrays: []Raid{
+					{Name: "md0", Level: "raid1", Devices: []RaidDevice{"/dev/sda", "/dev/sda"}},
+					{Name: "md1", Level: "raid1", Devices: []RaidDevice{"/dev/sdb", "/dev/sda"}},
+				},
+			}}},
+			out: out{err: ValidationError{
+				errors.New(`raid "md0": member "/dev/sda" listed more than once`),
+				errors.New(`device "/dev/sda" used by both raid "md0" and raid "md1"`),
+			}},
+		},
+		{
+			in: in{config: Config{Version: 1, Storage: Storage{
+				Arrays: []Raid{
+					{Name: "md0", Level: "raid1", Devices: []RaidDevice{"/dev/sda", "/dev/sdb"}, SpareGroup: "pool"},
+					{Name: "md1", Level: "mirror", Devices: []RaidDevice{"/dev/sdc", "/dev/sdd"}, SpareGroup: "pool"},
+					{Name: "md2", Level: "raid5", Devices: []RaidDevice{"/dev/sde", "/dev/sdf", "/dev/sdg"}, SpareGroup: "pool"},
+				},
+			}}},
+			out: out{err: ValidationError{
+				errors.New(`raid "md2": level "raid5" differs from the "raid1" of raid "md0" in spare group "pool"`),
+			}},
+		},
+		{
+			in: in{config: Config{Version: 1, Storage: Storage{
+				Disks:       []Disk{{Device: "LABEL=disk"}},
+				Arrays:      []Raid{{Name: "md0", Level: "raid1", Devices: []RaidDevice{"/dev/sdb", "UUID=1234"}}},
+				Filesystems: []Filesystem{{Device: "LABEL=ROOT", Format: "ext4"}, {Device: "UUID=", Format: "ext4"}},
+			}}},
+			out: out{err: ValidationError{
+				errors.New(`disk "LABEL=disk" must be referenced by path`),
+				errors.New(`raid "md0": member "UUID=1234" must be referenced by path`),
+				ErrFilesystemEmptyTag,
+			}},
+		},
+		{
+			in: in{config: Config{Version: 1, Storage: Storage{
+				Filesystems: []Filesystem{
+					{Name: "root", Device: "/dev/sda", Format: "ext4", Files: []File{{Path: "/a", Filesystem: "var"}, {Path: "/b", Filesystem: "swap"}, {Path: "/c", Filesystem: "data"}}},
+					{Name: "var", Device: "/dev/sdb", Format: "ext4"},
+					{Name: "swap", Device: "/dev/sdc", Format: "swap"},
+					{Name: "var", Device: "/dev/sdd", Format: "ext4"},
+				},
+			}}},
+			out: out{err: ValidationError{
+				errors.New(`filesystem "var" defined more than once`),
+				errors.New(`filesystem "/dev/sda": file "/b" targets filesystem "swap": files unsupported on swap`),
+				errors.New(`filesystem "/dev/sda": file "/c" targets unknown filesystem "data"`),
+			}},
+		},
+		{
+			in: in{config: Config{Version: 1, Storage: Storage{
+				Luks: []Luks{
+					{Name: "data", Device: "/dev/sdb", KeyFile: "/etc/luks/data"},
+					{Name: "data", Device: "LABEL=data", KeyFile: "/etc/luks/data"},
+				},
+				Filesystems: []Filesystem{{Device: "/dev/sdb", Format: "ext4"}, {Device: "/dev/mapper/data", Format: "ext4"}},
+			}}},
+			out: out{err: ValidationError{
+				errors.New(`luks volume "data" defined more than once`),
+				errors.New(`luks volume "data": device "LABEL=data" must be referenced by path`),
+				errors.New(`device "/dev/sdb" used by both luks volume "data" and a filesystem`),
+			}},
+		},
+		{
+			in: in{config: Config{Version: 1, Storage: Storage{
+				Filesystems: []Filesystem{
+					{Format: "ext4"},
+					{Device: "/dev/sda", Format: "ext4", MountPoint: "/var", Files: []File{{Path: "etc/hosts"}}},
+					{Device: "/dev/sdb", Format: "ext4", MountPoint: "/var", Links: []Link{{Path: "/a", Target: "/b"}, {Path: "/a", Target: "/c"}}},
+				},
+			}}},
+			out: out{err: ValidationError{
+				errors.New("filesystem device is required"),
+				errors.New(`filesystem "/dev/sda": file path "etc/hosts" not absolute`),
+				errors.New(`mount point "/var" used by more than one filesystem`),
+				errors.New(`filesystem "/dev/sdb": path "/a" listed more than once`),
+			}},
+		},
+		{
+			in: in{config: Config{Version: 1, Storage: Storage{
+				Filesystems: []Filesystem{
+					{Device: "/dev/sda", Format: "ext4", Remove: []Removal{{Path: "/etc/a.conf"}, {Path: "etc/b.conf"}, {Path: "/"}}, Files: []File{{Path: "/etc/a.conf"}}},
+				},
+			}}},
+			out: out{err: ValidationError{
+				errors.New(`filesystem "/dev/sda": removal path "etc/b.conf" not absolute`),
+				errors.New(`filesystem "/dev/sda": refusing to remove the root`),
+			}},
+		},
+		{
+			in: in{config: Config{Version: 1,
+				Systemd:  Systemd{Units: []SystemdUnit{{Name: "a.service"}, {Name: "a.service"}}},
+				Networkd: Networkd{Units: []NetworkdUnit{{Name: "a.network", DropIns: []NetworkdUnitDropIn{{Name: "a.conf"}, {Name: "a.conf"}}}}},
+				Passwd:   Passwd{Users: []User{{Name: "core"}, {Name: "core"}}},
+			}},
+			out: out{err: ValidationError{
+				errors.New(`systemd unit "a.service" defined more than once`),
+				errors.New(`networkd unit "a.network": dropin "a.conf" defined more than once`),
+				errors.New(`user "core" defined more than once`),
+			}},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.config.Validate()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrHashMalformed    = errors.New("malformed hash specifier")
+	ErrHashUnrecognized = errors.New("unrecognized hash function")
+	ErrHashWrongSize    = errors.New("incorrect size for hash sum")
+)
+
+type Verification struct {
+	Hash FileHash `json:"hash,omitempty" yaml:"hash"`
+}
+
+// FileHash is a hash specifier of the form "<function>-<hex sum>".
+type FileHash string
+
+func (h *FileHash) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return h.unmarshal(unmarshal)
+}
+
+func (h *FileHash) UnmarshalJSON(data []byte) error {
+	return h.unmarshal(func(th interface{}) error {
+		return json.Unmarshal(data, th)
+	})
+}
+
+type fileHash FileHash
+
+func (h *FileHash) unmarshal(unmarshal func(interface{}) error) error {
+	th := fileHash(*h)
+	if err := unmarshal(&th); err != nil {
+		return err
+	}
+	*h = FileHash(th)
+	return h.assertValid()
+}
+
+func (h FileHash) assertValid() error {
+	if h == "" {
+		return nil
+	}
+	_, _, err := h.Parse()
+	return err
+}
+
+// hashSizes are the sum sizes, in bytes, of the supported hash functions.
+var hashSizes = map[string]int{
+	"sha512": 64,
+}
+
+// Parse splits h into its hash function and hex encoded sum.
+func (h FileHash) Parse() (function string, sum string, err error) {
+	parts := strings.SplitN(string(h), "-", 2)
+	if len(parts) != 2 {
+		return "", "", ErrHashMalformed
+	}
+	function, sum = parts[0], strings.ToLower(parts[1])
+
+	size, ok := hashSizes[function]
+	if !ok {
+		return "", "", ErrHashUnrecognized
+	}
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != size {
+		return "", "", ErrHashWrongSize
+	}
+	return function, sum, nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFileHashAssertValid(t *testing.T) {
+	type in struct {
+		hash FileHash
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{hash: FileHash("")},
+			out: out{},
+		},
+		{
+			in:  in{hash: FileHash("sha512-" + strings.Repeat("0a", 64))},
+			out: out{},
+		},
+		{
+			in:  in{hash: FileHash("sha512")},
+			out: out{err: ErrHashMalformed},
+		},
+		{
+			in:  in{hash: FileHash("md5-" + strings.Repeat("0a", 16))},
+			out: out{err: ErrHashUnrecognized},
+		},
+		{
+			in:  in{hash: FileHash("sha512-" + strings.Repeat("0a", 32))},
+			out: out{err: ErrHashWrongSize},
+		},
+		{
+			in:  in{hash: FileHash("sha512-" + strings.Repeat("zz", 64))},
+			out: out{err: ErrHashWrongSize},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.hash.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
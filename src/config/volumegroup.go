@@ -0,0 +1,92 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+type VolumeGroup struct {
+	Name    string          `json:"name"              yaml:"name"`
+	Devices []DevicePath    `json:"devices,omitempty" yaml:"devices"`
+	Volumes []LogicalVolume `json:"volumes,omitempty" yaml:"volumes"`
+}
+
+type LogicalVolume struct {
+	Name string             `json:"name"           yaml:"name"`
+	Size PartitionDimension `json:"size,omitempty" yaml:"size"`
+}
+
+// lvmNameRegexp matches the names lvm accepts for volume groups and logical volumes.
+var lvmNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9+_.][a-zA-Z0-9+_.-]*$`)
+
+func (n *VolumeGroup) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return n.unmarshal(unmarshal)
+}
+
+func (n *VolumeGroup) UnmarshalJSON(data []byte) error {
+	return n.unmarshal(func(tn interface{}) error {
+		return json.Unmarshal(data, tn)
+	})
+}
+
+type volumeGroup VolumeGroup
+
+func (n *VolumeGroup) unmarshal(unmarshal func(interface{}) error) error {
+	tn := volumeGroup(*n)
+	if err := unmarshal(&tn); err != nil {
+		return err
+	}
+	*n = VolumeGroup(tn)
+	return n.assertValid()
+}
+
+func (n VolumeGroup) assertValid() error {
+	if err := assertLvmName(n.Name); err != nil {
+		return fmt.Errorf("volume group: %v", err)
+	}
+	if len(n.Devices) == 0 {
+		return fmt.Errorf("volume group %q: at least one device is required", n.Name)
+	}
+
+	names := map[string]bool{}
+	for i, lv := range n.Volumes {
+		if err := assertLvmName(lv.Name); err != nil {
+			return fmt.Errorf("volume group %q: logical volume: %v", n.Name, err)
+		}
+		if names[lv.Name] {
+			return fmt.Errorf("volume group %q: logical volume names collide: %q", n.Name, lv.Name)
+		}
+		names[lv.Name] = true
+
+		// a size of 0 takes all of the remaining space, leaving none for later volumes
+		if lv.Size == 0 && i != len(n.Volumes)-1 {
+			return fmt.Errorf("volume group %q: only the last logical volume may fill the group", n.Name)
+		}
+	}
+	return nil
+}
+
+func assertLvmName(name string) error {
+	if name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if name == "." || name == ".." || !lvmNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid name: %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestVolumeGroupAssertValid(t *testing.T) {
+	type in struct {
+		vg VolumeGroup
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in: in{vg: VolumeGroup{Name: "data", Devices: []DevicePath{"/dev/sdb"}, Volumes: []LogicalVolume{
+				{Name: "db", Size: 2048},
+				{Name: "logs"},
+			}}},
+			out: out{},
+		},
+		{
+			in:  in{vg: VolumeGroup{Devices: []DevicePath{"/dev/sdb"}}},
+			out: out{err: errors.New("volume group: name is required")},
+		},
+		{
+			in:  in{vg: VolumeGroup{Name: "-data", Devices: []DevicePath{"/dev/sdb"}}},
+			out: out{err: errors.New(`volume group: invalid name: "-data"`)},
+		},
+		{
+			in:  in{vg: VolumeGroup{Name: "data"}},
+			out: out{err: errors.New(`volume group "data": at least one device is required`)},
+		},
+		{
+			in: in{vg: VolumeGroup{Name: "data", Devices: []DevicePath{"/dev/sdb"}, Volumes: []LogicalVolume{
+				{Name: "db", Size: 2048},
+				{Name: "db", Size: 2048},
+			}}},
+			out: out{err: errors.New(`volume group "data": logical volume names collide: "db"`)},
+		},
+		{
+			in: in{vg: VolumeGroup{Name: "data", Devices: []DevicePath{"/dev/sdb"}, Volumes: []LogicalVolume{
+				{Name: "db"},
+				{Name: "logs", Size: 2048},
+			}}},
+			out: out{err: errors.New(`volume group "data": only the last logical volume may fill the group`)},
+		},
+	}
+
+	for i, test := range tests {
+		err := test.in.vg.assertValid()
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
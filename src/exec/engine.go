@@ -15,21 +15,41 @@
 package exec
 
 import (
+	"crypto/sha512"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/coreos/ignition/config"
 	"github.com/coreos/ignition/src/exec/stages"
+	"github.com/coreos/ignition/src/exec/util"
 	"github.com/coreos/ignition/src/log"
 	"github.com/coreos/ignition/src/providers"
 	"github.com/coreos/ignition/src/registry"
 )
 
 const (
-	DefaultFetchTimeout = time.Minute
+	DefaultFetchTimeout        = time.Minute
+	DefaultFileFetchTimeout    = 2 * time.Minute
+	DefaultFileFetchAttempts   = 5
+	DefaultFileFetchBackoff    = 500 * time.Millisecond
+	DefaultFileFetchMaxBackoff = 15 * time.Second
+	DefaultDeviceTimeout       = 5 * time.Minute
+	DefaultOpTimeout           = 30 * time.Minute
+	DefaultPartitionAttempts   = 5
+	DefaultSentinelDir         = "/etc/ignition"
+	DefaultResultPath          = "/var/lib/ignition/result.json"
+
+	// only the storage stage is destructive to repeat, so only its runs
+	// are recorded and skipped
+	sentinelStage = "storage"
 )
 
 var (
@@ -39,11 +59,34 @@ var (
 
 // Engine represents the entity that fetches and executes a configuration.
 type Engine struct {
-	ConfigCache  string
-	FetchTimeout time.Duration
-	Logger       log.Logger
-	Root         string
-	providers    *registry.Registry
+	ConfigCache       string
+	DaemonReload      bool
+	DeactivateGroups  bool
+	DeviceTimeout     time.Duration
+	DryRun            bool
+	FetchTimeout      time.Duration
+	FileFetchTimeout  time.Duration
+	FileFetchRetry    RetryOptions
+	Force             bool
+	LinkUnits         bool
+	Logger            log.Logger
+	OpTimeout         time.Duration
+	PartitionAttempts int
+	ProviderTimeout   time.Duration
+	ResultPath        string
+	Root              string
+	SentinelDir       string
+	StopArrays        bool
+	SystemctlUnits    bool
+	providers         *registry.Registry
+	providerOrder     []string
+}
+
+// RetryOptions tunes how failed operations are retried with exponential backoff.
+type RetryOptions struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
 }
 
 func (e Engine) Init() Engine {
@@ -54,6 +97,7 @@ func (e Engine) Init() Engine {
 // AddProvider registers a configuration provider with the engine.
 func (e *Engine) AddProvider(provider providers.Provider) {
 	e.providers.Register(provider)
+	e.providerOrder = append(e.providerOrder, provider.Name())
 }
 
 // GetProvider returns the specified provider.
@@ -74,12 +118,64 @@ func (e Engine) Providers() []providers.Provider {
 // Run executes the stage of the given name. It returns true if the stage
 // successfully ran and false if there were any errors.
 func (e Engine) Run(stageName string) bool {
-	cfg, err := e.acquireConfig()
+	cfg, metadata, err := e.acquireConfig()
 	switch err {
 	case nil:
-		e.Logger.PushPrefix(stageName)
+		// refuse to start on a config which would fail partway through
+		if err := cfg.Validate(); err != nil {
+			e.Logger.Crit("%v", err)
+			return false
+		}
+		hash, err := configHash(cfg)
+		if err != nil {
+			e.Logger.Crit("failed to hash config: %v", err)
+			return false
+		}
+		report := util.NewReport(stageName)
+		defer func() {
+			if err := e.writeResult(report); err != nil {
+				e.Logger.Warning("failed to write the result of stage %q: %v", stageName, err)
+			}
+		}()
+		if e.alreadyRan(stageName, hash, cfg) {
+			if !e.Force {
+				e.Logger.Info("stage %q already ran with this config, skipping (use -force to run it again)", stageName)
+				report.Skip("run stage", stageName)
+				return true
+			}
+			e.Logger.Info("stage %q already ran with this config, running it again as forced", stageName)
+		}
+
+		e.Logger.PushPrefix("%s", stageName)
 		defer e.Logger.PopPrefix()
-		return stages.Get(stageName).Create(&e.Logger, e.Root).Run(cfg)
+		ok := stages.Get(stageName).Create(&e.Logger, e.Root, stages.Options{
+			DryRun:            e.DryRun,
+			FetchTimeout:      e.FileFetchTimeout,
+			FetchAttempts:     e.FileFetchRetry.Attempts,
+			FetchBackoff:      e.FileFetchRetry.Backoff,
+			FetchMaxBackoff:   e.FileFetchRetry.MaxBackoff,
+			DaemonReload:      e.DaemonReload,
+			LinkUnits:         e.LinkUnits,
+			SystemctlUnits:    e.SystemctlUnits,
+			DeviceTimeout:     e.DeviceTimeout,
+			OpTimeout:         e.OpTimeout,
+			PartitionAttempts: e.PartitionAttempts,
+			Metadata:          metadata,
+			StopArrays:        e.StopArrays,
+			DeactivateGroups:  e.DeactivateGroups,
+			Report:            report,
+		}).Run(cfg)
+		if ok {
+			report.Add("run stage", stageName, nil)
+		} else {
+			report.Add("run stage", stageName, fmt.Errorf("stage %q failed", stageName))
+		}
+		if ok && !e.DryRun {
+			if err := e.recordRun(stageName, hash, cfg); err != nil {
+				e.Logger.Warning("failed to record the run of stage %q: %v", stageName, err)
+			}
+		}
+		return ok
 	case config.ErrCloudConfig, config.ErrScript:
 		e.Logger.Info("%v: ignoring and exiting...", err)
 		return true
@@ -89,48 +185,252 @@ func (e Engine) Run(stageName string) bool {
 	}
 }
 
-// acquireConfig returns the configuration, first checking a local cache
-// before attempting to fetch it from the registered providers.
-func (e Engine) acquireConfig() (cfg config.Config, err error) {
+// configHash returns a digest of cfg. It's taken over the parsed config, so
+// configs differing only in formatting hash the same.
+func configHash(cfg config.Config) (string, error) {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	sum := sha512.Sum512(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// sentinelPath returns where the successful run of the named stage is
+// recorded, or "" if its runs aren't recorded. The sentinel belongs on the
+// root filesystem, so runs aren't recorded when cfg mounts a filesystem over
+// SentinelDir: a later boot might not see the sentinel, or see a stale one.
+func (e Engine) sentinelPath(stageName string, cfg config.Config) string {
+	if e.SentinelDir == "" || stageName != sentinelStage {
+		return ""
+	}
+	for _, fs := range cfg.Storage.Filesystems {
+		if fs.MountPoint == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(fs.MountPoint, e.SentinelDir); err == nil && !strings.HasPrefix(rel, "..") {
+			e.Logger.Warning("not recording runs: %q is mounted over %q", fs.MountPoint, e.SentinelDir)
+			return ""
+		}
+	}
+	return filepath.Join(e.Root, e.SentinelDir, stageName+".done")
+}
+
+// alreadyRan reports whether the named stage last ran successfully with the
+// config cfg, hashing to hash.
+func (e Engine) alreadyRan(stageName, hash string, cfg config.Config) bool {
+	path := e.sentinelPath(stageName, cfg)
+	if path == "" {
+		return false
+	}
+	b, err := ioutil.ReadFile(path)
+	return err == nil && strings.TrimSpace(string(b)) == hash
+}
+
+// recordRun records that the named stage ran successfully with the config
+// cfg, hashing to hash, so that running it again can be skipped.
+func (e Engine) recordRun(stageName, hash string, cfg config.Config) error {
+	path := e.sentinelPath(stageName, cfg)
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(hash+"\n"), 0644)
+}
+
+// providerChain returns the registered providers in the order they were added.
+func (e Engine) providerChain() []providers.Provider {
+	providers := make([]providers.Provider, 0, len(e.providerOrder))
+	for _, name := range e.providerOrder {
+		providers = append(providers, e.GetProvider(name))
+	}
+	return providers
+}
+
+// metadataCache returns where the metadata of the provider which supplied
+// the cached config is kept, so that later stages render templates against
+// the same values.
+func (e Engine) metadataCache() string {
+	return e.ConfigCache + ".metadata"
+}
+
+// acquireConfig returns the configuration and the metadata of the provider
+// which supplied it, first checking a local cache before attempting to fetch
+// them from the registered providers. Fetched configs are cached.
+func (e Engine) acquireConfig() (cfg config.Config, metadata map[string]string, err error) {
+	cfg, metadata, cached, err := e.readConfig()
+	if err != nil || cached {
+		return
+	}
+
+	// Populate the config cache.
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		e.Logger.Crit("failed to marshal cached config: %v", err)
+		return
+	}
+	if err = ioutil.WriteFile(e.ConfigCache, b, 0640); err != nil {
+		e.Logger.Crit("failed to write cached config: %v", err)
+		return
+	}
+	if metadata == nil {
+		return
+	}
+	if b, err = json.Marshal(metadata); err != nil {
+		e.Logger.Crit("failed to marshal cached metadata: %v", err)
+		return
+	}
+	if err = ioutil.WriteFile(e.metadataCache(), b, 0640); err != nil {
+		e.Logger.Crit("failed to write cached metadata: %v", err)
+		return
+	}
+
+	return
+}
+
+// readConfig returns the configuration and the metadata of the provider
+// which supplied it from the local cache, or failing that fetches them from
+// the registered providers and resolves the configs they reference. Nothing
+// is written; cached reports whether they came from the cache.
+func (e Engine) readConfig() (cfg config.Config, metadata map[string]string, cached bool, err error) {
 	// First try read the config @ e.ConfigCache.
 	b, err := ioutil.ReadFile(e.ConfigCache)
 	if err == nil {
+		cached = true
 		if err = json.Unmarshal(b, &cfg); err != nil {
 			e.Logger.Crit("failed to parse cached config: %v", err)
+			return
+		}
+		// not every provider supplies metadata
+		if b, err = ioutil.ReadFile(e.metadataCache()); os.IsNotExist(err) {
+			err = nil
+		} else if err != nil {
+			e.Logger.Crit("failed to read cached metadata: %v", err)
+		} else if err = json.Unmarshal(b, &metadata); err != nil {
+			e.Logger.Crit("failed to parse cached metadata: %v", err)
 		}
 		return
 	}
 
 	// (Re)Fetch the config if the cache is unreadable.
-	cfg, err = fetchConfig(e.Providers(), e.FetchTimeout)
+	if e.ProviderTimeout != 0 {
+		cfg, metadata, err = e.fetchConfigChain(e.providerChain(), e.ProviderTimeout)
+	} else {
+		cfg, metadata, err = e.fetchConfig(e.Providers(), e.FetchTimeout)
+	}
 	if err != nil {
 		e.Logger.Crit("failed to fetch config: %v", err)
 		return
 	}
+	if cfg, err = e.resolveReferences(cfg, nil); err != nil {
+		e.Logger.Crit("failed to resolve referenced configs: %v", err)
+		return
+	}
 	e.Logger.Debug("fetched config: %+v", cfg)
+	return
+}
 
-	// Populate the config cache.
-	b, err = json.Marshal(cfg)
+// resolveReferences fetches the configs cfg appends or is replaced by,
+// recursively, and returns the result. parents holds the sources of the
+// configs which led to cfg, so that references back to any of them can be
+// refused rather than followed forever.
+func (e Engine) resolveReferences(cfg config.Config, parents []string) (config.Config, error) {
+	refs := cfg.Ignition.Config
+	if refs.Replace != nil {
+		return e.fetchReference(*refs.Replace, parents)
+	}
+	for _, ref := range refs.Append {
+		child, err := e.fetchReference(ref, parents)
+		if err != nil {
+			return config.Config{}, err
+		}
+		cfg = cfg.Append(child)
+	}
+	cfg.Ignition.Config = config.IgnitionConfig{}
+	return cfg, nil
+}
+
+// fetchReference fetches, verifies and parses the config ref points to,
+// resolving its own references in turn.
+func (e Engine) fetchReference(ref config.ConfigReference, parents []string) (config.Config, error) {
+	for _, parent := range parents {
+		if parent == ref.Source {
+			return config.Config{}, fmt.Errorf("config %q is referenced in a cycle", ref.Source)
+		}
+	}
+
+	data, err := e.fetcher().FetchVerified(ref.Source, ref.Verification.Hash)
 	if err != nil {
-		e.Logger.Crit("failed to marshal cached config: %v", err)
-		return
+		return config.Config{}, err
 	}
-	if err = ioutil.WriteFile(e.ConfigCache, b, 0640); err != nil {
-		e.Logger.Crit("failed to write cached config: %v", err)
-		return
+	cfg, err := config.Parse(data)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("config %q: %v", ref.Source, err)
 	}
+	return e.resolveReferences(cfg, append(parents[:len(parents):len(parents)], ref.Source))
+}
 
-	return
+// fetcher returns a Util for fetching remote contents with the engine's fetch
+// settings.
+func (e Engine) fetcher() util.Util {
+	return util.Util{
+		FetchTimeout:    e.FileFetchTimeout,
+		FetchAttempts:   e.FileFetchRetry.Attempts,
+		FetchBackoff:    e.FileFetchRetry.Backoff,
+		FetchMaxBackoff: e.FileFetchRetry.MaxBackoff,
+		Logger:          &e.Logger,
+	}
 }
 
-// fetchConfig returns the configuration from the first available provider or
-// returns an error if none of the providers are available.
-func fetchConfig(providers []providers.Provider, timeout time.Duration) (config.Config, error) {
+// fetchConfig returns the configuration and metadata from the first
+// available provider or returns an error if none of the providers are
+// available.
+func (e Engine) fetchConfig(providers []providers.Provider, timeout time.Duration) (config.Config, map[string]string, error) {
 	if provider, err := selectProvider(providers, timeout); err == nil {
-		return provider.FetchConfig()
+		return e.fetchFrom(provider)
 	} else {
-		return config.Config{}, err
+		return config.Config{}, nil, err
+	}
+}
+
+// fetchConfigChain returns the configuration from the first of the providers,
+// tried in order, to come online within timeout. Providers which report they
+// will never come online are skipped without waiting.
+func (e Engine) fetchConfigChain(ps []providers.Provider, timeout time.Duration) (config.Config, map[string]string, error) {
+	for _, p := range ps {
+		var provider providers.Provider
+		err := e.Logger.LogOp(func() (err error) {
+			provider, err = selectProvider([]providers.Provider{p}, timeout)
+			return
+		}, "waiting for provider %q", p.Name())
+		if err == nil {
+			return e.fetchFrom(provider)
+		}
+	}
+	return config.Config{}, nil, ErrNoProviders
+}
+
+// fetchFrom returns the configuration of the online provider, along with its
+// metadata if it supplies any. Only templated files need the metadata, so
+// failing to fetch it is merely warned about; those files fail to render
+// later on.
+func (e Engine) fetchFrom(provider providers.Provider) (config.Config, map[string]string, error) {
+	cfg, err := provider.FetchConfig()
+	if err != nil {
+		return cfg, nil, err
+	}
+	mp, ok := provider.(providers.MetadataProvider)
+	if !ok {
+		return cfg, nil, nil
+	}
+	metadata, err := mp.Metadata()
+	if err != nil {
+		e.Logger.Warning("failed to fetch metadata from provider %q: %v", provider.Name(), err)
+		return cfg, nil, nil
 	}
+	return cfg, metadata, nil
 }
 
 // selectProvider chooses the first online provider, given a list of providers
@@ -15,12 +15,20 @@
 package exec
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/exec/util"
+	"github.com/coreos/ignition/src/log"
 	"github.com/coreos/ignition/src/providers"
 	"github.com/coreos/ignition/src/registry"
 )
@@ -40,6 +48,14 @@ func (p mockProvider) IsOnline() bool                      { return p.online }
 func (p mockProvider) ShouldRetry() bool                   { return p.retry }
 func (p mockProvider) BackoffDuration() time.Duration      { return p.backoff }
 
+type mockMetadataProvider struct {
+	mockProvider
+	metadata    map[string]string
+	metadataErr error
+}
+
+func (p mockMetadataProvider) Metadata() (map[string]string, error) { return p.metadata, p.metadataErr }
+
 func registryFromList(name string, registrants []registry.Registrant) *registry.Registry {
 	registry := registry.Create(name)
 	for _, registrant := range registrants {
@@ -179,7 +195,7 @@ func TestFetchConfigs(t *testing.T) {
 	}
 
 	for i, test := range tests {
-		config, err := fetchConfig(test.in.providers, test.in.timeout)
+		config, _, err := Engine{Logger: log.NewTest()}.fetchConfig(test.in.providers, test.in.timeout)
 		if !reflect.DeepEqual(test.out.config, config) {
 			t.Errorf("#%d: bad provider: want %+v, got %+v", i, test.out.config, config)
 		}
@@ -243,3 +259,276 @@ func TestSelectProvider(t *testing.T) {
 		}
 	}
 }
+
+func TestFetchConfigChain(t *testing.T) {
+	type in struct {
+		providers []providers.Provider
+	}
+	type out struct {
+		config config.Config
+		err    error
+	}
+
+	first := mockProvider{name: "first", online: true, config: config.Config{Version: 1}}
+	second := mockProvider{name: "second", online: true, config: config.Config{Version: 2}}
+	offline := mockProvider{name: "offline", online: false}
+	offlineRetry := mockProvider{name: "offlineRetry", online: false, retry: true, backoff: time.Millisecond}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{providers: nil},
+			out: out{err: ErrNoProviders},
+		},
+		{
+			in:  in{providers: []providers.Provider{first, second}},
+			out: out{config: first.config},
+		},
+		{
+			in:  in{providers: []providers.Provider{second, first}},
+			out: out{config: second.config},
+		},
+		{
+			in:  in{providers: []providers.Provider{offline, offlineRetry, second}},
+			out: out{config: second.config},
+		},
+		{
+			in:  in{providers: []providers.Provider{offline, offlineRetry}},
+			out: out{err: ErrNoProviders},
+		},
+	}
+
+	e := Engine{Logger: log.NewTest()}
+	for i, test := range tests {
+		config, _, err := e.fetchConfigChain(test.in.providers, 10*time.Millisecond)
+		if !reflect.DeepEqual(test.out.config, config) {
+			t.Errorf("#%d: bad config: want %+v, got %+v", i, test.out.config, config)
+		}
+		if test.out.err != err {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
+
+func TestFetchFrom(t *testing.T) {
+	type in struct {
+		provider providers.Provider
+	}
+	type out struct {
+		config   config.Config
+		metadata map[string]string
+		ok       bool
+	}
+
+	cfg := config.Config{Version: 1}
+	metadata := map[string]string{"instance_id": "i-1234"}
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{provider: mockProvider{config: cfg}},
+			out: out{config: cfg, ok: true},
+		},
+		{
+			in:  in{provider: mockMetadataProvider{mockProvider: mockProvider{config: cfg}, metadata: metadata}},
+			out: out{config: cfg, metadata: metadata, ok: true},
+		},
+		{
+			in:  in{provider: mockMetadataProvider{mockProvider: mockProvider{config: cfg}, metadataErr: errors.New("unreachable")}},
+			out: out{config: cfg, ok: true},
+		},
+		{
+			in:  in{provider: mockMetadataProvider{mockProvider: mockProvider{err: errors.New("unreachable")}}},
+			out: out{ok: false},
+		},
+	}
+
+	e := Engine{Logger: log.NewTest()}
+	for i, test := range tests {
+		config, metadata, err := e.fetchFrom(test.in.provider)
+		if got := (out{config: config, metadata: metadata, ok: err == nil}); !reflect.DeepEqual(test.out, got) {
+			t.Errorf("#%d: bad result: want %+v, got %+v", i, test.out, got)
+		}
+	}
+}
+
+func TestResolveReferences(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/child":
+			fmt.Fprint(w, `{"ignitionVersion": 1, "systemd": {"units": [{"name": "child.service"}]}}`)
+		case "/replacement":
+			fmt.Fprintf(w, `{"ignitionVersion": 1, "ignition": {"config": {"append": [{"source": "%s/child"}]}}}`, server.URL)
+		case "/a":
+			fmt.Fprintf(w, `{"ignitionVersion": 1, "ignition": {"config": {"append": [{"source": "%s/b"}]}}}`, server.URL)
+		case "/b":
+			fmt.Fprintf(w, `{"ignitionVersion": 1, "ignition": {"config": {"replace": {"source": "%s/a"}}}}`, server.URL)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	type in struct {
+		config config.Config
+	}
+	type out struct {
+		config config.Config
+		err    error
+	}
+
+	ref := func(path string) config.ConfigReference {
+		return config.ConfigReference{Source: server.URL + path}
+	}
+	child := config.Config{Version: 1, Systemd: config.Systemd{Units: []config.SystemdUnit{{Name: "child.service"}}}}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{config: config.Config{Version: 1}},
+			out: out{config: config.Config{Version: 1}},
+		},
+		{
+			in: in{config: config.Config{
+				Version:  1,
+				Ignition: config.Ignition{Config: config.IgnitionConfig{Append: []config.ConfigReference{ref("/child")}}},
+				Systemd:  config.Systemd{Units: []config.SystemdUnit{{Name: "parent.service"}}},
+			}},
+			out: out{config: config.Config{
+				Version: 1,
+				Systemd: config.Systemd{Units: []config.SystemdUnit{{Name: "parent.service"}, {Name: "child.service"}}},
+			}},
+		},
+		{
+			in: in{config: config.Config{
+				Version:  1,
+				Ignition: config.Ignition{Config: config.IgnitionConfig{Replace: &config.ConfigReference{Source: server.URL + "/replacement"}}},
+				Systemd:  config.Systemd{Units: []config.SystemdUnit{{Name: "parent.service"}}},
+			}},
+			out: out{config: child},
+		},
+		{
+			in: in{config: config.Config{
+				Version:  1,
+				Ignition: config.Ignition{Config: config.IgnitionConfig{Append: []config.ConfigReference{ref("/a")}}},
+			}},
+			out: out{err: fmt.Errorf("config %q is referenced in a cycle", server.URL+"/a")},
+		},
+	}
+
+	e := Engine{Logger: log.NewTest(), FileFetchRetry: RetryOptions{Attempts: 1}}
+	for i, test := range tests {
+		config, err := e.resolveReferences(test.in.config, nil)
+		if !reflect.DeepEqual(test.out.config, config) {
+			t.Errorf("#%d: bad config: want %+v, got %+v", i, test.out.config, config)
+		}
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
+
+func TestSentinel(t *testing.T) {
+	root, err := ioutil.TempDir("", "ignition-sentinel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	first, err := configHash(config.Config{Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := configHash(config.Config{Version: 1, Systemd: config.Systemd{Units: []config.SystemdUnit{{Name: "a.service"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("different configs hashed the same: %s", first)
+	}
+
+	cfg := config.Config{Version: 1}
+	e := Engine{Root: root, SentinelDir: DefaultSentinelDir, Logger: log.NewTest()}
+	if e.alreadyRan("storage", first, cfg) {
+		t.Errorf("stage ran before it was recorded")
+	}
+	if err := e.recordRun("storage", first, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !e.alreadyRan("storage", first, cfg) {
+		t.Errorf("recorded run not found")
+	}
+	if e.alreadyRan("storage", second, cfg) {
+		t.Errorf("recorded run matched a different config")
+	}
+	if e.alreadyRan("prepivot", first, cfg) {
+		t.Errorf("recorded run matched a different stage")
+	}
+
+	if err := e.recordRun("files", first, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if e.alreadyRan("files", first, cfg) {
+		t.Errorf("run of a non-destructive stage recorded")
+	}
+	mounted := config.Config{Version: 1, Storage: config.Storage{Filesystems: []config.Filesystem{{Device: "/dev/sdb1", MountPoint: "/etc"}}}}
+	if e.alreadyRan("storage", first, mounted) {
+		t.Errorf("run found beneath a configured mount")
+	}
+
+	e.SentinelDir = ""
+	if e.alreadyRan("storage", first, cfg) {
+		t.Errorf("run found with recording disabled")
+	}
+}
+
+func TestWriteResult(t *testing.T) {
+	root, err := ioutil.TempDir("", "ignition-result")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	e := Engine{Root: root, ResultPath: DefaultResultPath}
+	run := func(stage string, targets ...string) {
+		report := util.NewReport(stage)
+		for _, target := range targets {
+			report.Add("write file", target, nil)
+		}
+		if err := e.writeResult(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("storage", "/a")
+	run("files", "/b")
+	run("storage", "/c")
+
+	// skipping a stage which already ran leaves its entries be
+	skipped := util.NewReport("storage")
+	skipped.Skip("run stage", "storage")
+	if err := e.writeResult(skipped); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(e.resultPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res result
+	if err := json.Unmarshal(b, &res); err != nil {
+		t.Fatal(err)
+	}
+	expected := result{Entries: []util.ReportEntry{
+		{Stage: "files", Operation: "write file", Target: "/b", Status: util.StatusDone},
+		{Stage: "storage", Operation: "write file", Target: "/c", Status: util.StatusDone},
+	}}
+	if !reflect.DeepEqual(expected, res) {
+		t.Errorf("bad result: want %+v, got %+v", expected, res)
+	}
+}
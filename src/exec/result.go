@@ -0,0 +1,97 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/coreos/ignition/src/exec/util"
+)
+
+// result is the summary written to ResultPath. It accumulates across stages,
+// each run of a stage replacing the entries of its previous run.
+type result struct {
+	Entries []util.ReportEntry `json:"entries"`
+}
+
+// resultPath returns where the summary of the actions taken is written, or ""
+// if it isn't written.
+func (e Engine) resultPath() string {
+	if e.ResultPath == "" {
+		return ""
+	}
+	return filepath.Join(e.Root, e.ResultPath)
+}
+
+// writeResult merges the entries of report into the summary at resultPath.
+func (e Engine) writeResult(report *util.Report) error {
+	path := e.resultPath()
+	if path == "" || e.DryRun {
+		return nil
+	}
+
+	var prev result
+	if b, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &prev); err != nil {
+			e.Logger.Warning("discarding unparsable result %q: %v", path, err)
+			prev = result{}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	b, err := json.MarshalIndent(mergeResult(prev, report.Entries()), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// mergeResult returns prev with the entries of the stages in entries
+// replaced by entries. A stage skipped as having already run keeps the
+// entries of the run which did the work.
+func mergeResult(prev result, entries []util.ReportEntry) result {
+	stages := map[string]bool{}
+	for _, e := range entries {
+		if !skippedStage(e) {
+			stages[e.Stage] = true
+		}
+	}
+	recorded := map[string]bool{}
+	merged := result{Entries: []util.ReportEntry{}}
+	for _, e := range prev.Entries {
+		if !stages[e.Stage] {
+			merged.Entries = append(merged.Entries, e)
+			recorded[e.Stage] = true
+		}
+	}
+	for _, e := range entries {
+		if stages[e.Stage] || !recorded[e.Stage] {
+			merged.Entries = append(merged.Entries, e)
+		}
+	}
+	return merged
+}
+
+// skippedStage reports whether e records a whole stage being skipped.
+func skippedStage(e util.ReportEntry) bool {
+	return e.Operation == "run stage" && e.Status == util.StatusSkipped
+}
@@ -0,0 +1,155 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The files stage writes the files and units described in the config without
+// touching the disks: no partitioning, RAID, volume groups or mkfs. Each
+// filesystem's contents are written wherever it is already mounted, making
+// the stage safe to apply repeatedly to a provisioned machine or a container.
+
+package files
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/exec/stages"
+	"github.com/coreos/ignition/src/exec/util"
+	"github.com/coreos/ignition/src/log"
+)
+
+const (
+	name = "files"
+)
+
+func init() {
+	stages.Register(creator{})
+}
+
+type creator struct{}
+
+func (creator) Create(logger *log.Logger, root string, opts stages.Options) stages.Stage {
+	return &stage{
+		Util: util.Util{
+			DestDir:         root,
+			DryRun:          opts.DryRun,
+			FetchTimeout:    opts.FetchTimeout,
+			FetchAttempts:   opts.FetchAttempts,
+			FetchBackoff:    opts.FetchBackoff,
+			FetchMaxBackoff: opts.FetchMaxBackoff,
+			LinkUnits:       opts.LinkUnits,
+			SystemctlUnits:  opts.SystemctlUnits,
+			Metadata:        opts.Metadata,
+			Logger:          logger,
+			Report:          opts.Report,
+		},
+		daemonReload: opts.DaemonReload,
+	}
+}
+
+func (creator) Name() string {
+	return name
+}
+
+type stage struct {
+	util.Util
+
+	daemonReload bool
+}
+
+func (stage) Name() string {
+	return name
+}
+
+func (s stage) Run(config config.Config) bool {
+	if err := s.writeFilesystems(config); err != nil {
+		s.Logger.Crit("failed to write files: %v", err)
+		return false
+	}
+
+	if err := s.createUnits(config); err != nil {
+		s.Logger.Crit("failed to create units: %v", err)
+		return false
+	}
+
+	if err := s.WriteModules(config.Modules); err != nil {
+		s.Logger.Crit("failed to write kernel modules: %v", err)
+		return false
+	}
+	return true
+}
+
+// writeFilesystems writes the contents of each filesystem in
+// config.Storage.Filesystems where it's currently mounted.
+func (s stage) writeFilesystems(config config.Config) error {
+	s.Logger.PushPrefix("writeFilesystems")
+	defer s.Logger.PopPrefix()
+
+	for _, fs := range util.TargetFiles(config.Storage.Filesystems) {
+		if len(fs.Directories) == 0 && len(fs.Files) == 0 && len(fs.Links) == 0 && len(fs.Remove) == 0 {
+			continue
+		}
+
+		dev, err := s.ResolveDevice(fs.Device)
+		if err != nil {
+			return err
+		}
+		fs.Device = dev
+
+		mnt, err := s.mountedAt(fs)
+		if err != nil {
+			return err
+		}
+		u := s.Util
+		u.DestDir = mnt
+		if err := u.WriteContents(fs); err != nil {
+			return fmt.Errorf("failed to create files on %q: %v", fs.Device, err)
+		}
+	}
+	return nil
+}
+
+// mountedAt returns where fs can be written: wherever its device is mounted,
+// or failing that its mount point beneath the destination root, which is
+// assumed to be prepared by whoever set up the root. Filesystems which are
+// neither are an error, since mounting them is left to the storage stage.
+func (s stage) mountedAt(fs config.Filesystem) (string, error) {
+	out, err := exec.Command("/bin/lsblk", "-n", "-r", "-o", "MOUNTPOINT", string(fs.Device)).Output()
+	if err == nil {
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if mnt := strings.TrimSpace(lines[0]); mnt != "" {
+			s.Logger.Info("%q is mounted at %q", fs.Device, mnt)
+			return mnt, nil
+		}
+	}
+
+	if fs.MountPoint != "" {
+		mnt := s.JoinPath(fs.MountPoint)
+		s.Logger.Info("%q doesn't appear to be mounted, writing beneath %q", fs.Device, mnt)
+		return mnt, nil
+	}
+	return "", fmt.Errorf("%q isn't mounted and has no mount point", fs.Device)
+}
+
+// createUnits creates the units listed under systemd.units and networkd.units.
+func (s stage) createUnits(config config.Config) error {
+	if err := s.CreateUnits(config.Systemd, config.Networkd); err != nil {
+		return err
+	}
+	if s.daemonReload && (len(config.Systemd.Units) != 0 || len(config.Systemd.Commands) != 0) {
+		return s.ReloadSystemd()
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package files
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/exec/util"
+	"github.com/coreos/ignition/src/log"
+)
+
+func TestRunTwice(t *testing.T) {
+	root, err := ioutil.TempDir("", "ignition-files-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	cfg := config.Config{Systemd: config.Systemd{Units: []config.SystemdUnit{
+		{Name: "enabled.service", Enable: true, Contents: "[Service]\nExecStart=/bin/true\n"},
+		{Name: "disabled.service", Disable: true},
+		{Name: "masked.service", Mask: true},
+	}}}
+	logger := log.NewTest()
+	s := stage{Util: util.Util{DestDir: root, Logger: &logger}}
+	for run := 0; run < 2; run++ {
+		if !s.Run(cfg) {
+			t.Fatalf("run %d failed", run)
+		}
+	}
+
+	preset, err := ioutil.ReadFile(filepath.Join(root, "etc/systemd/system-preset/20-ignition.preset"))
+	if err != nil {
+		t.Fatalf("failed to read presets: %v", err)
+	}
+	if want := "enable enabled.service\ndisable disabled.service\n"; string(preset) != want {
+		t.Errorf("bad presets: want %q, got %q", want, string(preset))
+	}
+	if target, err := os.Readlink(filepath.Join(root, "etc/systemd/system/masked.service")); err != nil || target != "/dev/null" {
+		t.Errorf("bad mask: want link to %q, got %q (%v)", "/dev/null", target, err)
+	}
+}
@@ -31,11 +31,23 @@ func init() {
 
 type creator struct{}
 
-func (creator) Create(logger *log.Logger, root string) stages.Stage {
-	return &stage{util.Util{
-		DestDir: root,
-		Logger:  logger,
-	}}
+func (creator) Create(logger *log.Logger, root string, opts stages.Options) stages.Stage {
+	return &stage{
+		Util: util.Util{
+			DestDir:         root,
+			RuntimeDir:      "/",
+			DryRun:          opts.DryRun,
+			FetchTimeout:    opts.FetchTimeout,
+			FetchAttempts:   opts.FetchAttempts,
+			FetchBackoff:    opts.FetchBackoff,
+			FetchMaxBackoff: opts.FetchMaxBackoff,
+			LinkUnits:       opts.LinkUnits,
+			SystemctlUnits:  opts.SystemctlUnits,
+			Logger:          logger,
+			Report:          opts.Report,
+		},
+		daemonReload: opts.DaemonReload,
+	}
 }
 
 func (creator) Name() string {
@@ -44,6 +56,8 @@ func (creator) Name() string {
 
 type stage struct {
 	util.Util
+
+	daemonReload bool
 }
 
 func (stage) Name() string {
@@ -51,95 +65,75 @@ func (stage) Name() string {
 }
 
 func (s stage) Run(config config.Config) bool {
+	// users may refer to the groups, so those come first
+	if err := s.createGroups(config); err != nil {
+		s.Logger.Crit("failed to create groups: %v", err)
+		return false
+	}
+
+	if err := s.createUsers(config); err != nil {
+		s.Logger.Crit("failed to create users: %v", err)
+		return false
+	}
+
 	if err := s.createUnits(config); err != nil {
 		s.Logger.Crit("failed to create units: %v", err)
 		return false
 	}
+
+	if err := s.WriteModules(config.Modules); err != nil {
+		s.Logger.Crit("failed to write kernel modules: %v", err)
+		return false
+	}
 	return true
 }
 
-// createUnits creates the units listed under systemd.units and networkd.units.
-func (s stage) createUnits(config config.Config) error {
-	for _, unit := range config.Systemd.Units {
-		if err := s.writeSystemdUnit(unit); err != nil {
-			return err
-		}
-		if unit.Enable {
-			if err := s.Logger.LogOp(
-				func() error { return s.EnableUnit(unit) },
-				"enabling unit %q", unit.Name,
-			); err != nil {
-				return err
-			}
-		}
-		if unit.Mask {
-			if err := s.Logger.LogOp(
-				func() error { return s.MaskUnit(unit) },
-				"masking unit %q", unit.Name,
-			); err != nil {
-				return err
-			}
-		}
+// createGroups creates the groups listed under passwd.groups.
+func (s stage) createGroups(config config.Config) error {
+	if len(config.Passwd.Groups) == 0 {
+		return nil
 	}
-	for _, unit := range config.Networkd.Units {
-		if err := s.writeNetworkdUnit(unit); err != nil {
+	s.Logger.PushPrefix("createGroups")
+	defer s.Logger.PopPrefix()
+
+	for _, group := range config.Passwd.Groups {
+		if err := s.EnsureGroup(group); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// writeSystemdUnit creates the specified unit and any dropins for that unit.
-// If the contents of the unit or are empty, the unit is not created. The same
-// applies to the unit's dropins.
-func (s stage) writeSystemdUnit(unit config.SystemdUnit) error {
-	return s.Logger.LogOp(func() error {
-		for _, dropin := range unit.DropIns {
-			if dropin.Contents == "" {
-				continue
-			}
-
-			f := util.FileFromUnitDropin(unit, dropin)
-			if err := s.Logger.LogOp(
-				func() error { return s.WriteFile(f) },
-				"writing dropin %q at %q", dropin.Name, f.Path,
-			); err != nil {
-				return err
-			}
-		}
-
-		if unit.Contents == "" {
-			return nil
-		}
+// createUsers creates the users listed under passwd.users, updating any
+// which already exist.
+func (s stage) createUsers(config config.Config) error {
+	if len(config.Passwd.Users) == 0 {
+		return nil
+	}
+	s.Logger.PushPrefix("createUsers")
+	defer s.Logger.PopPrefix()
 
-		f := util.FileFromSystemdUnit(unit)
-		if err := s.Logger.LogOp(
-			func() error { return s.WriteFile(f) },
-			"writing unit %q at %q", unit.Name, f.Path,
-		); err != nil {
+	for _, user := range config.Passwd.Users {
+		if err := s.EnsureUser(user); err != nil {
 			return err
 		}
-
-		return nil
-	}, "writing unit %q", unit.Name)
-}
-
-// writeNetworkdUnit creates the specified unit. If the contents of the unit or
-// are empty, the unit is not created.
-func (s stage) writeNetworkdUnit(unit config.NetworkdUnit) error {
-	return s.Logger.LogOp(func() error {
-		if unit.Contents == "" {
-			return nil
+		if len(user.SSHAuthorizedKeys) == 0 {
+			continue
 		}
-
-		f := util.FileFromNetworkdUnit(unit)
-		if err := s.Logger.LogOp(
-			func() error { return s.WriteFile(f) },
-			"writing unit %q at %q", unit.Name, f.Path,
-		); err != nil {
+		if err := s.AuthorizeKeys(user); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		return nil
-	}, "writing unit %q", unit.Name)
+// createUnits creates the units listed under systemd.units and networkd.units.
+func (s stage) createUnits(config config.Config) error {
+	if err := s.CreateUnits(config.Systemd, config.Networkd); err != nil {
+		return err
+	}
+	if s.daemonReload && (len(config.Systemd.Units) != 0 || len(config.Systemd.Commands) != 0) {
+		return s.ReloadSystemd()
+	}
+	return nil
 }
@@ -15,7 +15,10 @@
 package stages
 
 import (
+	"time"
+
 	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/exec/util"
 	"github.com/coreos/ignition/src/log"
 	"github.com/coreos/ignition/src/registry"
 )
@@ -27,12 +30,31 @@ type Stage interface {
 }
 
 // StageCreator is responsible for instantiating a particular stage given a
-// logger and root path under the root partition.
+// logger, root path under the root partition and options.
 type StageCreator interface {
-	Create(logger *log.Logger, root string) Stage
+	Create(logger *log.Logger, root string, opts Options) Stage
 	Name() string
 }
 
+// Options holds the engine settings which affect how stages perform their work.
+type Options struct {
+	DryRun            bool              // log actions rather than performing them.
+	FetchTimeout      time.Duration     // total deadline for fetching remote file contents.
+	FetchAttempts     int               // maximum attempts at fetching remote file contents.
+	FetchBackoff      time.Duration     // initial delay between fetch attempts.
+	FetchMaxBackoff   time.Duration     // maximum delay between fetch attempts.
+	DaemonReload      bool              // reload a running systemd after writing units.
+	LinkUnits         bool              // enable units by linking them rather than by preset.
+	SystemctlUnits    bool              // enable units with systemctl --root, when it's available.
+	DeviceTimeout     time.Duration     // how long to wait for devices to appear.
+	OpTimeout         time.Duration     // how long a long-running storage operation may take.
+	PartitionAttempts int               // attempts at partitioning a disk which is busy.
+	Metadata          map[string]string // provider metadata file templates are rendered against.
+	StopArrays        bool              // stop all raid arrays before partitioning.
+	DeactivateGroups  bool              // deactivate all volume groups before partitioning.
+	Report            *util.Report      // records the actions taken, may be nil.
+}
+
 var stages = registry.Create("stages")
 
 func Register(stage StageCreator) {
@@ -0,0 +1,289 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/sgdisk"
+)
+
+const (
+	// BLKGETSIZE64 from linux/fs.h, _IOR(0x12, 114, size_t)
+	blkGetSize64 = 0x80081272
+	// BLKRRPART from linux/fs.h, _IO(0x12, 95)
+	blkRRPart = 0x125f
+
+	// sectors a disk can't allocate: everything before the first aligned
+	// sector, and on GPT disks the backup header and entries at the end
+	firstUsableSector = 2048
+	backupGPTSectors  = 33
+)
+
+// trailingSectors returns how many sectors at the end of a disk the partition
+// table of type table keeps for itself. MBR tables keep none.
+func trailingSectors(table config.PartitionTableType) uint64 {
+	if table == "mbr" {
+		return 0
+	}
+	return backupGPTSectors
+}
+
+// deviceSize returns the size of the block device dev in bytes.
+func deviceSize(dev string) (uint64, error) {
+	f, err := os.Open(dev)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var size uint64
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkGetSize64, uintptr(unsafe.Pointer(&size))); errno != 0 {
+		return 0, fmt.Errorf("BLKGETSIZE64 failed on %q: %v", dev, errno)
+	}
+	return size, nil
+}
+
+// rereadTable has the kernel reread the freshly written partition table of
+// dev. udev usually notices the new table on its own, so this is best-effort.
+func (s stage) rereadTable(dev string) {
+	if err := s.RunOp(
+		func() error { return rereadPartitions(dev) },
+		"rereading partition table on %q", dev,
+	); err != nil {
+		s.Logger.Warning("failed to reread partition table on %q: %v", dev, err)
+	}
+}
+
+// rereadPartitions asks the kernel to reread the partition table of dev.
+func rereadPartitions(dev string) error {
+	f, err := os.Open(dev)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkRRPart, 0); errno != 0 {
+		return fmt.Errorf("BLKRRPART failed on %q: %v", dev, errno)
+	}
+	return nil
+}
+
+// resolvePartitions returns a copy of parts with any percentage-based starts
+// and sizes translated into sectors of dev, as resolveSectors does. Growing
+// partitions with a max size also need the partitions already on dev, which
+// existing lists when called.
+func (s stage) resolvePartitions(dev string, parts []config.Partition, table config.PartitionTableType, existing func() ([]config.Partition, error)) ([]config.Partition, error) {
+	dimensioned, capped := false, false
+	for _, p := range parts {
+		dimensioned = dimensioned || p.StartPercent != 0 || p.SizePercent != 0 || p.MaxSize != 0
+		capped = capped || p.MaxSize != 0
+	}
+	if !dimensioned {
+		return append([]config.Partition{}, parts...), nil
+	}
+
+	size, err := deviceSize(dev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine size of %q: %v", dev, err)
+	}
+	var onDisk []config.Partition
+	if capped && existing != nil {
+		if onDisk, err = existing(); err != nil {
+			return nil, fmt.Errorf("failed to read existing partitions on %q: %v", dev, err)
+		}
+	}
+
+	resolved := resolveSectors(parts, size/512, table, onDisk)
+	for i, p := range parts {
+		if p.StartPercent != 0 || p.SizePercent != 0 || p.MaxSize != 0 {
+			s.Logger.Debug("partition %d on %q resolved to start %d, size %d sectors",
+				p.Number, dev, resolved[i].Start, resolved[i].Size)
+		}
+	}
+	return resolved, nil
+}
+
+// resolveSectors returns a copy of parts with any percentage-based starts
+// and sizes translated into sectors of a disk of sectors. Resolved starts and
+// sizes are rounded down to the 2048-sector (1MiB) alignment used for
+// explicit starts, and starts are kept clear of the table at the beginning.
+// Growing partitions with a max size are given that size when the disk,
+// holding a partition table of type table and the partitions onDisk, has
+// more room left than that.
+func resolveSectors(parts []config.Partition, sectors uint64, table config.PartitionTableType, onDisk []config.Partition) []config.Partition {
+	resolved := make([]config.Partition, len(parts))
+	copy(resolved, parts)
+
+	// partitions the config recreates make way, those it keeps stay put
+	replaced := map[int]bool{}
+	for _, p := range parts {
+		if !p.ShouldExist {
+			replaced[p.Number] = true
+		}
+	}
+	kept := []config.Partition{}
+	for _, e := range onDisk {
+		if !replaced[e.Number] {
+			kept = append(kept, e)
+		}
+	}
+
+	for i, p := range resolved {
+		if p.StartPercent != 0 {
+			start := percentOf(sectors, p.StartPercent)
+			if start < firstUsableSector {
+				start = firstUsableSector
+			}
+			resolved[i].Start = config.PartitionDimension(start)
+		}
+		if p.SizePercent != 0 {
+			resolved[i].Size = config.PartitionDimension(percentOf(sectors, p.SizePercent))
+		}
+		if p.MaxSize != 0 {
+			resolved[i].Size = config.PartitionDimension(cappedGrowth(resolved[:i], kept, resolved[i], sectors, table))
+		}
+	}
+	return resolved
+}
+
+// gptExisting returns a lister of the partitions op leaves on its disk.
+func gptExisting(op *sgdisk.Operation) func() ([]config.Partition, error) {
+	return func() ([]config.Partition, error) {
+		extents, err := op.Existing()
+		kept := []config.Partition{}
+		for _, e := range extents {
+			kept = append(kept, extentPartition(e.Number, e.Offset, e.Length))
+		}
+		return kept, err
+	}
+}
+
+// extentPartition returns the partition lying on the given extent of a disk.
+func extentPartition(number int, offset, length uint64) config.Partition {
+	return config.Partition{
+		Number: number,
+		Start:  config.PartitionDimension(offset),
+		Size:   config.PartitionDimension(length),
+	}
+}
+
+// checkCapacity returns an error if the partitions in parts, once resolved,
+// can't fit on dev, holding a partition table of type table: either their
+// sizes add up to more than the disk holds, or one of them is placed to end
+// beyond it. Partitions which grow to fill the disk are counted as empty.
+// Partitions kept at whatever size they already have leave the space needed
+// unknown, so disks with any are not checked.
+func (s stage) checkCapacity(dev string, parts []config.Partition, table config.PartitionTableType) error {
+	var total, end uint64
+	for _, p := range parts {
+		if p.ShouldExist && p.Size == 0 {
+			s.Logger.Info("not checking capacity of %q: partition %d is kept at its existing size", dev, p.Number)
+			return nil
+		}
+		total += uint64(p.Size)
+		if p.Start != 0 && uint64(p.Start+p.Size) > end {
+			end = uint64(p.Start + p.Size)
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	size, err := deviceSize(dev)
+	if err != nil {
+		return fmt.Errorf("failed to determine size of %q: %v", dev, err)
+	}
+	sectors := size / 512
+	last := sectors - trailingSectors(table)
+	usable := uint64(0)
+	if sectors > firstUsableSector+trailingSectors(table) {
+		usable = last - firstUsableSector
+	}
+	s.Logger.Info("%q holds %d sectors (%d usable), partitions need %d", dev, sectors, usable, total)
+
+	if total > usable {
+		return fmt.Errorf("partitions need %d sectors but %q only has %d usable", total, dev, usable)
+	}
+	if end > last {
+		return fmt.Errorf("partitions extend to sector %d but %q ends at sector %d", end, dev, last)
+	}
+	return nil
+}
+
+// cappedGrowth returns the size of the growing partition p on a disk of
+// sectors holding a partition table of type table: p.MaxSize if more space
+// than that is left from p's start up to the next of the kept partitions
+// already on the disk, or 0 to fill what's left otherwise. Partitions left
+// for the partitioner to place are assumed to follow those before them, on
+// the next 2048-sector boundary clear of the kept partitions.
+func cappedGrowth(before []config.Partition, kept []config.Partition, p config.Partition, sectors uint64, table config.PartitionTableType) uint64 {
+	next := uint64(firstUsableSector)
+	for _, q := range before {
+		if q.ShouldExist {
+			// already on the disk, so among kept
+			continue
+		}
+		start := uint64(q.Start)
+		if start == 0 {
+			start = next
+		}
+		if end := alignUp(start + uint64(q.Size)); end > next {
+			next = end
+		}
+	}
+	start := uint64(p.Start)
+	if start == 0 {
+		start = next
+		for moved := true; moved; {
+			moved = false
+			for _, e := range kept {
+				if start >= uint64(e.Start) && start < uint64(e.Start+e.Size) {
+					start = alignUp(uint64(e.Start + e.Size))
+					moved = true
+				}
+			}
+		}
+	}
+
+	limit := uint64(0)
+	if sectors > trailingSectors(table) {
+		limit = sectors - trailingSectors(table)
+	}
+	for _, e := range kept {
+		if uint64(e.Start) >= start && uint64(e.Start) < limit {
+			limit = uint64(e.Start)
+		}
+	}
+
+	if start+uint64(p.MaxSize) < limit {
+		return uint64(p.MaxSize)
+	}
+	return 0
+}
+
+// alignUp rounds sector up to the next 2048-sector (1MiB) boundary.
+func alignUp(sector uint64) uint64 {
+	return (sector + 2048 - 1) &^ (2048 - 1)
+}
+
+// percentOf returns pct percent of sectors, aligned down to 2048 sectors.
+func percentOf(sectors uint64, pct uint) uint64 {
+	return (sectors / 100 * uint64(pct)) &^ (2048 - 1)
+}
@@ -0,0 +1,267 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/exec/util"
+	"github.com/coreos/ignition/src/log"
+)
+
+func TestCappedGrowth(t *testing.T) {
+	type in struct {
+		before  []config.Partition
+		kept    []config.Partition
+		p       config.Partition
+		sectors uint64
+		table   config.PartitionTableType
+	}
+	type out struct {
+		size uint64
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{p: config.Partition{MaxSize: 1000}, sectors: 10000, table: "gpt"},
+			out: out{size: 1000},
+		},
+		{
+			// the backup GPT leaves too little room to cap
+			in:  in{p: config.Partition{MaxSize: 1000}, sectors: 2048 + 1000 + 33, table: "gpt"},
+			out: out{size: 0},
+		},
+		{
+			in:  in{p: config.Partition{MaxSize: 1000}, sectors: 2048 + 1000 + 33 + 1, table: "gpt"},
+			out: out{size: 1000},
+		},
+		{
+			// an MBR table keeps nothing at the end of the disk
+			in:  in{p: config.Partition{MaxSize: 1000}, sectors: 2048 + 1000 + 33, table: "mbr"},
+			out: out{size: 1000},
+		},
+		{
+			in:  in{p: config.Partition{MaxSize: 1000}, sectors: 2048 + 1000, table: "mbr"},
+			out: out{size: 0},
+		},
+		{
+			// auto-placed partitions before are assumed to start aligned after the last
+			in: in{
+				before:  []config.Partition{{Number: 1, Start: 2048, Size: 10000}, {Number: 2, Size: 2048}},
+				p:       config.Partition{Number: 3, MaxSize: 1000},
+				sectors: 14336 + 1000 + 33,
+				table:   "gpt",
+			},
+			out: out{size: 0},
+		},
+		{
+			in: in{
+				before:  []config.Partition{{Number: 1, Start: 2048, Size: 10000}, {Number: 2, Size: 2048}},
+				p:       config.Partition{Number: 3, MaxSize: 1000},
+				sectors: 14336 + 1000 + 33 + 1,
+				table:   "gpt",
+			},
+			out: out{size: 1000},
+		},
+		{
+			// a partition already on the disk bounds the growth
+			in: in{
+				kept:    []config.Partition{{Number: 3, Start: 4096, Size: 2048}},
+				p:       config.Partition{Number: 1, MaxSize: 4096},
+				sectors: 100000,
+				table:   "gpt",
+			},
+			out: out{size: 0},
+		},
+		{
+			// and is skipped over by auto-placed partitions
+			in: in{
+				kept:    []config.Partition{{Number: 1, Start: 2048, Size: 2048}},
+				p:       config.Partition{Number: 2, MaxSize: 1000},
+				sectors: 10000,
+				table:   "gpt",
+			},
+			out: out{size: 1000},
+		},
+		{
+			in: in{
+				kept:    []config.Partition{{Number: 1, Start: 2048, Size: 2048}},
+				p:       config.Partition{Number: 2, MaxSize: 1000},
+				sectors: 4096 + 1000 + 33,
+				table:   "gpt",
+			},
+			out: out{size: 0},
+		},
+		{
+			// kept partitions in the config are counted where they lie
+			in: in{
+				before:  []config.Partition{{Number: 1, ShouldExist: true}},
+				kept:    []config.Partition{{Number: 1, Start: 2048, Size: 4096}},
+				p:       config.Partition{Number: 2, MaxSize: 1000},
+				sectors: 6144 + 1000 + 33 + 1,
+				table:   "gpt",
+			},
+			out: out{size: 1000},
+		},
+	}
+
+	for i, test := range tests {
+		size := cappedGrowth(test.in.before, test.in.kept, test.in.p, test.in.sectors, test.in.table)
+		if size != test.out.size {
+			t.Errorf("#%d: bad size: want %d, got %d", i, test.out.size, size)
+		}
+	}
+}
+
+func TestCheckCapacityKept(t *testing.T) {
+	type in struct {
+		parts []config.Partition
+	}
+	type out struct {
+		ok bool
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			// kept at its existing size, which is unknown: nothing is checked
+			in:  in{parts: []config.Partition{{Number: 1, ShouldExist: true}, {Number: 2, Size: 1000}}},
+			out: out{ok: true},
+		},
+		{
+			// the missing device's size is needed for the check
+			in:  in{parts: []config.Partition{{Number: 1, ShouldExist: true, Size: 1000}, {Number: 2, Size: 1000}}},
+			out: out{ok: false},
+		},
+		{
+			in:  in{parts: []config.Partition{{Number: 1, Size: 1000}}},
+			out: out{ok: false},
+		},
+	}
+
+	for i, test := range tests {
+		logger := log.NewTest()
+		s := stage{Util: util.Util{Logger: &logger}}
+		err := s.checkCapacity("/dev/ignition-missing", test.in.parts, "gpt")
+		if ok := err == nil; ok != test.out.ok {
+			t.Errorf("#%d: bad result: want %v, got %v (%v)", i, test.out.ok, ok, err)
+		}
+	}
+}
+
+func TestResolveSectors(t *testing.T) {
+	type in struct {
+		parts   []config.Partition
+		sectors uint64
+		table   config.PartitionTableType
+		onDisk  []config.Partition
+	}
+	type out struct {
+		parts []config.Partition
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{parts: []config.Partition{{Number: 1, Size: 4096}}, sectors: 100000, table: "gpt"},
+			out: out{parts: []config.Partition{{Number: 1, Size: 4096}}},
+		},
+		{
+			// 10% of 1000000 sectors is 100000, aligned down to 98304
+			in:  in{parts: []config.Partition{{Number: 1, StartPercent: 10, SizePercent: 10}}, sectors: 1000000, table: "gpt"},
+			out: out{parts: []config.Partition{{Number: 1, Start: 98304, Size: 98304, StartPercent: 10, SizePercent: 10}}},
+		},
+		{
+			// starts are kept clear of the table at the beginning
+			in:  in{parts: []config.Partition{{Number: 1, StartPercent: 1, SizePercent: 50}}, sectors: 100000, table: "gpt"},
+			out: out{parts: []config.Partition{{Number: 1, Start: 2048, Size: 49152, StartPercent: 1, SizePercent: 50}}},
+		},
+		{
+			in:  in{parts: []config.Partition{{Number: 1, Size: 2048}, {Number: 2, SizePercent: 100}}, sectors: 204800, table: "mbr"},
+			out: out{parts: []config.Partition{{Number: 1, Size: 2048}, {Number: 2, Size: 204800, SizePercent: 100}}},
+		},
+		{
+			// the partition the config recreates makes way
+			in: in{
+				parts:   []config.Partition{{Number: 1, MaxSize: 1000}},
+				sectors: 200000,
+				table:   "gpt",
+				onDisk:  []config.Partition{{Number: 1, Start: 2048, Size: 100000}},
+			},
+			out: out{parts: []config.Partition{{Number: 1, Size: 1000, MaxSize: 1000}}},
+		},
+		{
+			// one it leaves alone is grown around
+			in: in{
+				parts:   []config.Partition{{Number: 2, MaxSize: 1000}},
+				sectors: 200000,
+				table:   "gpt",
+				onDisk:  []config.Partition{{Number: 1, Start: 2048, Size: 100000}},
+			},
+			out: out{parts: []config.Partition{{Number: 2, Size: 1000, MaxSize: 1000}}},
+		},
+		{
+			in: in{
+				parts:   []config.Partition{{Number: 2, MaxSize: 100000}},
+				sectors: 200000,
+				table:   "gpt",
+				onDisk:  []config.Partition{{Number: 1, Start: 2048, Size: 100000}},
+			},
+			out: out{parts: []config.Partition{{Number: 2, MaxSize: 100000}}},
+		},
+	}
+
+	for i, test := range tests {
+		parts := resolveSectors(test.in.parts, test.in.sectors, test.in.table, test.in.onDisk)
+		if !reflect.DeepEqual(test.out.parts, parts) {
+			t.Errorf("#%d: bad partitions: want %+v, got %+v", i, test.out.parts, parts)
+		}
+	}
+}
+
+func TestPercentOf(t *testing.T) {
+	type in struct {
+		sectors uint64
+		pct     uint
+	}
+	type out struct {
+		sectors uint64
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{in: in{sectors: 204800, pct: 50}, out: out{sectors: 102400}},
+		{in: in{sectors: 1000000, pct: 10}, out: out{sectors: 98304}},
+		{in: in{sectors: 100000, pct: 1}, out: out{sectors: 0}},
+		{in: in{sectors: 204800, pct: 100}, out: out{sectors: 204800}},
+	}
+
+	for i, test := range tests {
+		if sectors := percentOf(test.in.sectors, test.in.pct); sectors != test.out.sectors {
+			t.Errorf("#%d: bad sectors: want %d, got %d", i, test.out.sectors, sectors)
+		}
+	}
+}
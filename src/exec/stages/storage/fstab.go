@@ -0,0 +1,154 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/exec/util"
+)
+
+const (
+	fstabPath = "/etc/fstab"
+)
+
+// writeFstab writes an /etc/fstab entry to the destination root for each
+// filesystem in config.Storage.Filesystems which specifies a MountPoint,
+// replacing any existing entry for the same mount point. Entries refer to the
+// filesystem by label or UUID so they remain stable across reboots.
+func (s stage) writeFstab(config config.Config) error {
+	entries := []string{}
+	for _, fs := range config.Storage.Filesystems {
+		if fs.MountPoint == "" {
+			continue
+		}
+
+		spec, err := s.filesystemSpec(fs)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, fstabEntry(fs, spec))
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+	s.Logger.PushPrefix("writeFstab")
+	defer s.Logger.PopPrefix()
+
+	return s.Logger.LogOp(
+		func() error { return s.replaceLines(fstabPath, entries, 1) },
+		"writing %d entries to %q", len(entries), fstabPath,
+	)
+}
+
+// replaceLines writes lines to the file at path in the destination root,
+// replacing the existing lines whose whitespace separated field (counting
+// from 0) matches that of one of lines, and preserving the rest. This keeps
+// tables such as fstab free of duplicates when the stage is rerun.
+func (s stage) replaceLines(path string, lines []string, field int) error {
+	contents, err := ioutil.ReadFile(s.JoinPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	replaced := map[string]bool{}
+	for _, line := range lines {
+		if key, ok := lineField(line, field); ok {
+			replaced[key] = true
+		}
+	}
+	kept := []string{}
+	for _, line := range strings.SplitAfter(string(contents), "\n") {
+		if line == "" {
+			continue
+		}
+		if key, ok := lineField(line, field); ok && replaced[key] {
+			continue
+		}
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		kept = append(kept, line)
+	}
+
+	return s.WriteFile(&config.File{
+		Path:     path,
+		Contents: strings.Join(append(kept, lines...), ""),
+		Mode:     util.DefaultFilePermissions,
+	})
+}
+
+// lineField returns the numbered whitespace separated field of a table line,
+// or false for comments and lines too short to have one.
+func lineField(line string, field int) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) <= field || strings.HasPrefix(fields[0], "#") {
+		return "", false
+	}
+	return fields[field], true
+}
+
+// filesystemSpec returns the fstab spec identifying fs, preferring its
+// configured label or UUID and falling back to the UUID found on the device.
+func (s stage) filesystemSpec(fs config.Filesystem) (string, error) {
+	if fs.Label != "" {
+		return "LABEL=" + fs.Label, nil
+	}
+	if fs.UUID != "" {
+		return "UUID=" + fs.UUID, nil
+	}
+	if s.DryRun {
+		// the device hasn't been formatted, so there's no UUID to find
+		return fmt.Sprintf("UUID=<uuid of %s>", fs.Device), nil
+	}
+
+	out, err := exec.Command("/sbin/blkid", "-p", "-s", "UUID", "-o", "value", string(fs.Device)).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine UUID of %q: %v", fs.Device, err)
+	}
+	uuid := strings.TrimSpace(string(out))
+	if uuid == "" {
+		return "", fmt.Errorf("no label or UUID found for %q", fs.Device)
+	}
+	return "UUID=" + uuid, nil
+}
+
+// fstabEntry returns the fstab line mounting fs, identified by spec.
+func fstabEntry(fs config.Filesystem, spec string) string {
+	opts := []string{}
+	opts = append(opts, fs.MountFlags...)
+	if fs.MountOptions != "" {
+		opts = append(opts, fs.MountOptions)
+	}
+	if len(opts) == 0 {
+		opts = append(opts, "defaults")
+	}
+
+	pass := 2
+	switch {
+	case fs.Format == "btrfs":
+		pass = 0
+	case fs.MountPoint == "/":
+		pass = 1
+	}
+
+	return fmt.Sprintf("%s %s %s %s 0 %d\n", spec, fs.MountPoint, fs.Format, strings.Join(opts, ","), pass)
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/exec/util"
+	"github.com/coreos/ignition/src/log"
+)
+
+func TestReplaceLines(t *testing.T) {
+	type in struct {
+		existing string
+		lines    []string
+		field    int
+	}
+	type out struct {
+		contents string
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{lines: []string{"data UUID=1 /key luks\n"}, field: 0},
+			out: out{contents: "data UUID=1 /key luks\n"},
+		},
+		{
+			in:  in{existing: "# comment\nold UUID=2 /key luks", lines: []string{"data UUID=1 /key luks\n"}, field: 0},
+			out: out{contents: "# comment\nold UUID=2 /key luks\ndata UUID=1 /key luks\n"},
+		},
+		{
+			in:  in{existing: "data UUID=0 /key luks\nold UUID=2 /key luks\n", lines: []string{"data UUID=1 /key luks\n"}, field: 0},
+			out: out{contents: "old UUID=2 /key luks\ndata UUID=1 /key luks\n"},
+		},
+		{
+			in:  in{existing: "LABEL=A /var ext4 defaults 0 2\nLABEL=B /srv ext4 defaults 0 2\n", lines: []string{"LABEL=C /var ext4 defaults 0 2\n"}, field: 1},
+			out: out{contents: "LABEL=B /srv ext4 defaults 0 2\nLABEL=C /var ext4 defaults 0 2\n"},
+		},
+	}
+
+	for i, test := range tests {
+		root, err := ioutil.TempDir("", "ignition-storage-")
+		if err != nil {
+			t.Fatalf("failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(root)
+		path := filepath.Join(root, "etc", "table")
+		if test.in.existing != "" {
+			os.MkdirAll(filepath.Dir(path), 0755)
+			ioutil.WriteFile(path, []byte(test.in.existing), 0644)
+		}
+
+		logger := log.NewTest()
+		s := stage{Util: util.Util{DestDir: root, Logger: &logger}}
+		if err := s.replaceLines("/etc/table", test.in.lines, test.in.field); err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if contents, _ := ioutil.ReadFile(path); string(contents) != test.out.contents {
+			t.Errorf("#%d: bad contents: want %q, got %q", i, test.out.contents, string(contents))
+		}
+	}
+}
+
+func TestWriteFstabRerun(t *testing.T) {
+	root, err := ioutil.TempDir("", "ignition-storage-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	cfg := config.Config{Storage: config.Storage{Filesystems: []config.Filesystem{
+		{Device: "/dev/sdb1", Format: "ext4", Label: "VAR", MountPoint: "/var"},
+	}}}
+	logger := log.NewTest()
+	s := stage{Util: util.Util{DestDir: root, Logger: &logger}}
+	for run := 0; run < 2; run++ {
+		if err := s.writeFstab(cfg); err != nil {
+			t.Fatalf("run %d: unexpected error: %v", run, err)
+		}
+	}
+
+	want := "LABEL=VAR /var ext4 defaults 0 2\n"
+	if contents, _ := ioutil.ReadFile(filepath.Join(root, fstabPath)); string(contents) != want {
+		t.Errorf("bad fstab: want %q, got %q", want, string(contents))
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/ignition/config"
+)
+
+const (
+	crypttabPath = "/etc/crypttab"
+)
+
+// createLuks sets up and opens the LUKS volumes described in
+// config.Storage.Luks. Devices already holding a LUKS volume are opened
+// rather than reformatted, and those holding any other signature are refused
+// unless the volume asks for the device to be wiped. cryptsetup is only ever
+// given the path of the key file, so the key itself never reaches the logs.
+func (s stage) createLuks(config config.Config) error {
+	if len(config.Storage.Luks) == 0 {
+		return nil
+	}
+	s.Logger.PushPrefix("createLuks")
+	defer s.Logger.PopPrefix()
+
+	devs := []string{}
+	for _, l := range config.Storage.Luks {
+		devs = append(devs, string(l.Device))
+	}
+
+	if err := s.waitOnDevices(devs, "luks volumes"); err != nil {
+		return err
+	}
+
+	cryptsetup, err := s.findBinary("/sbin/cryptsetup")
+	if err != nil {
+		return err
+	}
+
+	for _, l := range config.Storage.Luks {
+		key := s.JoinPath(l.KeyFile)
+		if _, err := os.Stat(key); err != nil && !s.DryRun {
+			return fmt.Errorf("luks volume %q: key file %q: %v", l.Name, l.KeyFile, err)
+		}
+
+		isLuks := s.Logger.LogCmd(
+			exec.Command(cryptsetup, "isLuks", string(l.Device)),
+			"checking for a luks volume on %q", l.Device,
+		) == nil
+		if isLuks {
+			s.Logger.Info("%q already holds a luks volume, not reformatting it", l.Device)
+			s.Report.Skip("format luks volume", l.Name)
+		} else {
+			if err := s.clearLuksDevice(l); err != nil {
+				return err
+			}
+
+			args := []string{"luksFormat", "--batch-mode", "--key-file", key}
+			if l.KeySlot != nil {
+				args = append(args, "--key-slot", strconv.Itoa(*l.KeySlot))
+			}
+			args = append(args, string(l.Device))
+
+			err := s.RunCmdTimeout(
+				cryptsetup, args,
+				"formatting luks volume %q on %q", l.Name, l.Device,
+			)
+			s.Report.Add("format luks volume", l.Name, err)
+			if err != nil {
+				return fmt.Errorf("luksFormat failed: %v", err)
+			}
+		}
+
+		if _, err := os.Stat(l.MapperPath()); err == nil {
+			s.Logger.Info("luks volume %q is already open", l.Name)
+			s.Report.Skip("open luks volume", l.Name)
+			continue
+		}
+		err := s.RunCmdTimeout(
+			cryptsetup, []string{"luksOpen", "--key-file", key, string(l.Device), l.Name},
+			"opening luks volume %q on %q", l.Name, l.Device,
+		)
+		s.Report.Add("open luks volume", l.Name, err)
+		if err != nil {
+			return fmt.Errorf("luksOpen failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// clearLuksDevice makes sure formatting l destroys nothing unasked for: any
+// signatures on its device are wiped if l.WipeDevice is set, and refused
+// otherwise.
+func (s stage) clearLuksDevice(l config.Luks) error {
+	if l.WipeDevice {
+		return s.wipeSignatures(l.Device)
+	}
+	sigs, err := s.listSignatures(l.Device)
+	if err != nil {
+		return err
+	}
+	if len(sigs) != 0 {
+		return fmt.Errorf("refusing to format luks volume %q: %q holds %s (set wipeDevice to override)", l.Name, l.Device, strings.Join(sigs, ", "))
+	}
+	return nil
+}
+
+// writeCrypttab writes an /etc/crypttab entry to the destination root for
+// each volume in config.Storage.Luks, so that they're opened again on later
+// boots with the same key file. Entries refer to the device by the UUID of
+// its LUKS header, which remains stable across reboots. Existing entries for
+// the same volume names are replaced.
+func (s stage) writeCrypttab(config config.Config) error {
+	if len(config.Storage.Luks) == 0 {
+		return nil
+	}
+	s.Logger.PushPrefix("writeCrypttab")
+	defer s.Logger.PopPrefix()
+
+	entries := []string{}
+	for _, l := range config.Storage.Luks {
+		spec, err := s.luksSpec(l)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, fmt.Sprintf("%s %s %s luks\n", l.Name, spec, l.KeyFile))
+	}
+
+	return s.Logger.LogOp(
+		func() error { return s.replaceLines(crypttabPath, entries, 0) },
+		"writing %d entries to %q", len(entries), crypttabPath,
+	)
+}
+
+// luksSpec returns the crypttab spec identifying the device of l.
+func (s stage) luksSpec(l config.Luks) (string, error) {
+	return s.filesystemSpec(config.Filesystem{Device: l.Device})
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/sfdisk"
+)
+
+// bootableAttribute is the GPT legacy BIOS bootable attribute bit, which
+// becomes the bootable flag of MBR partitions.
+const bootableAttribute = 2
+
+// mbrExisting returns a lister of the partitions op leaves on its disk.
+func mbrExisting(op *sfdisk.Operation) func() ([]config.Partition, error) {
+	return func() ([]config.Partition, error) {
+		extents, err := op.Existing()
+		kept := []config.Partition{}
+		for _, e := range extents {
+			kept = append(kept, extentPartition(e.Number, e.Offset, e.Length))
+		}
+		return kept, err
+	}
+}
+
+// partitionMBR partitions disk with an MBR table through sfdisk, since
+// sgdisk only writes GPT ones. It returns true if the partitions were
+// already in place and nothing was done.
+func (s stage) partitionMBR(disk config.Disk) (bool, error) {
+	sfdiskPath, err := s.findBinary("/sbin/sfdisk")
+	if err != nil {
+		return false, err
+	}
+	op := sfdisk.Begin(s.Logger, string(disk.Device))
+	op.Binary(sfdiskPath)
+	op.DryRun(s.DryRun)
+	if disk.WipeTable {
+		s.Logger.Info("wiping partition table requested on %q", disk.Device)
+		op.WipeTable(true)
+	}
+
+	for _, num := range disk.DeletePartitions {
+		op.DeletePartition(num)
+	}
+
+	parts, err := s.resolvePartitions(string(disk.Device), disk.Partitions, disk.TableType, mbrExisting(op))
+	if err != nil {
+		return false, err
+	}
+	if err := s.checkCapacity(string(disk.Device), parts, disk.TableType); err != nil {
+		return false, err
+	}
+
+	for _, part := range parts {
+		code, err := sfdisk.TypeCode(string(part.TypeGUID))
+		if err != nil {
+			return false, fmt.Errorf("partition %d: %v", part.Number, err)
+		}
+		bootable := false
+		for _, bit := range part.Attributes {
+			bootable = bootable || bit == bootableAttribute
+		}
+		op.CreatePartition(sfdisk.Partition{
+			Number:   part.Number,
+			Offset:   uint64(part.Start),
+			Length:   uint64(part.Size),
+			TypeCode: code,
+			Bootable: bootable,
+		})
+	}
+
+	if !disk.WipeTable {
+		if match, err := op.Matches(); err != nil {
+			s.Logger.Warning("unable to compare existing partitions on %q: %v", disk.Device, err)
+		} else if match {
+			s.Logger.Info("existing partitions on %q match, nothing to do", disk.Device)
+			return true, nil
+		}
+	}
+
+	if err := op.Commit(); err != nil {
+		return false, fmt.Errorf("commit failure: %v", err)
+	}
+
+	s.rereadTable(string(disk.Device))
+	return false, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/exec/util"
+)
+
+const (
+	mdadmConfPath = "/etc/mdadm.conf"
+)
+
+// writeMdadmConf writes the arrays reported by mdadm --detail --scan to the
+// destination root's mdadm.conf, so they reassemble under their names and
+// UUIDs on boot. Any ARRAY lines already there are replaced, while other
+// settings such as MAILADDR are kept. Spare groups are recorded too, since
+// it's mdadm --monitor which moves spares between the arrays of a group.
+func (s stage) writeMdadmConf(config config.Config) error {
+	if len(config.Storage.Arrays) == 0 {
+		return nil
+	}
+	s.Logger.PushPrefix("writeMdadmConf")
+	defer s.Logger.PopPrefix()
+
+	arrays, err := s.scanArrays(config.Storage.Arrays)
+	if err != nil {
+		return err
+	}
+	if arrays, err = withSpareGroups(arrays, config.Storage.Arrays); err != nil {
+		return err
+	}
+
+	return s.Logger.LogOp(
+		func() error { return s.replaceArrays(arrays) },
+		"writing %d arrays to %q", len(arrays), mdadmConfPath,
+	)
+}
+
+// scanArrays returns the ARRAY lines mdadm reports for the assembled arrays
+// among mds. Other arrays on the host are none of the destination's business.
+func (s stage) scanArrays(mds []config.Raid) ([]string, error) {
+	if s.DryRun {
+		// the arrays haven't been created, so there's nothing to scan
+		arrays := []string{}
+		for _, md := range mds {
+			dev := raidDevice(md.Name)
+			arrays = append(arrays, fmt.Sprintf("ARRAY %s UUID=<uuid of %s>", dev, dev))
+		}
+		return arrays, nil
+	}
+
+	mdadm, err := s.findBinary("/sbin/mdadm")
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	cmd := exec.Command(mdadm, "--detail", "--scan")
+	cmd.Stdout = &out
+	if err := s.Logger.LogCmd(cmd, "scanning arrays"); err != nil {
+		return nil, fmt.Errorf("failed to scan arrays: %v", err)
+	}
+	return filterArrays(out.String(), mds), nil
+}
+
+// filterArrays returns the ARRAY lines of the mdadm --detail --scan output
+// scan which describe one of mds.
+func filterArrays(scan string, mds []config.Raid) []string {
+	arrays := []string{}
+	for _, line := range strings.Split(scan, "\n") {
+		if !strings.HasPrefix(line, "ARRAY ") {
+			continue
+		}
+		for _, md := range mds {
+			if describesArray(line, md) {
+				arrays = append(arrays, strings.TrimSpace(line))
+				break
+			}
+		}
+	}
+	return arrays
+}
+
+// describesArray reports whether the ARRAY line names the device of md, by
+// any of its aliases.
+func describesArray(line string, md config.Raid) bool {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "ARRAY" {
+		return false
+	}
+	for _, dev := range raidAliases(md.Name) {
+		if fields[1] == dev {
+			return true
+		}
+	}
+	return false
+}
+
+// withSpareGroups adds the spare group of each of mds which has one to its
+// line in arrays. An array with a spare group which wasn't scanned is an
+// error, since it would be left out of its group.
+func withSpareGroups(arrays []string, mds []config.Raid) ([]string, error) {
+	grouped := append([]string{}, arrays...)
+	for _, md := range mds {
+		if md.SpareGroup == "" {
+			continue
+		}
+		found := false
+		for i, line := range grouped {
+			if describesArray(line, md) {
+				grouped[i] = line + " spare-group=" + md.SpareGroup
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%q wasn't found among the assembled arrays", raidDevice(md.Name))
+		}
+	}
+	return grouped, nil
+}
+
+// replaceArrays writes arrays to the destination root's mdadm.conf in place
+// of any ARRAY lines, along with their continuation lines, already there.
+func (s stage) replaceArrays(arrays []string) error {
+	contents, err := ioutil.ReadFile(s.JoinPath(mdadmConfPath))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	kept := []string{}
+	inArray := false
+	for _, line := range strings.Split(strings.TrimRight(string(contents), "\n"), "\n") {
+		// lines beginning with whitespace continue the previous one
+		if inArray && strings.TrimLeft(line, " \t") != line {
+			continue
+		}
+		inArray = strings.HasPrefix(line, "ARRAY")
+		if !inArray && line != "" {
+			kept = append(kept, line)
+		}
+	}
+
+	return s.WriteFile(&config.File{
+		Path:     mdadmConfPath,
+		Contents: strings.Join(append(kept, arrays...), "\n") + "\n",
+		Mode:     util.DefaultFilePermissions,
+	})
+}
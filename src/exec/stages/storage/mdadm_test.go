@@ -0,0 +1,190 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/exec/util"
+	"github.com/coreos/ignition/src/log"
+)
+
+const testScan = `ARRAY /dev/md/data metadata=1.2 name=host:data UUID=11111111:11111111:11111111:11111111
+ARRAY /dev/md0 metadata=1.2 name=host:0 UUID=22222222:22222222:22222222:22222222
+ARRAY /dev/md/other metadata=1.2 name=host:other UUID=33333333:33333333:33333333:33333333
+`
+
+func TestFilterArrays(t *testing.T) {
+	type in struct {
+		mds []config.Raid
+	}
+	type out struct {
+		arrays []string
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{mds: nil},
+			out: out{arrays: []string{}},
+		},
+		{
+			in: in{mds: []config.Raid{{Name: "data"}}},
+			out: out{arrays: []string{
+				"ARRAY /dev/md/data metadata=1.2 name=host:data UUID=11111111:11111111:11111111:11111111",
+			}},
+		},
+		{
+			in: in{mds: []config.Raid{{Name: "/dev/md0"}, {Name: "/dev/md/data"}}},
+			out: out{arrays: []string{
+				"ARRAY /dev/md/data metadata=1.2 name=host:data UUID=11111111:11111111:11111111:11111111",
+				"ARRAY /dev/md0 metadata=1.2 name=host:0 UUID=22222222:22222222:22222222:22222222",
+			}},
+		},
+		{
+			in: in{mds: []config.Raid{{Name: "md0"}}},
+			out: out{arrays: []string{
+				"ARRAY /dev/md0 metadata=1.2 name=host:0 UUID=22222222:22222222:22222222:22222222",
+			}},
+		},
+	}
+
+	for i, test := range tests {
+		if arrays := filterArrays(testScan, test.in.mds); !reflect.DeepEqual(test.out.arrays, arrays) {
+			t.Errorf("#%d: bad arrays: want %q, got %q", i, test.out.arrays, arrays)
+		}
+	}
+}
+
+func TestWithSpareGroups(t *testing.T) {
+	type in struct {
+		arrays []string
+		mds    []config.Raid
+	}
+	type out struct {
+		arrays []string
+		err    error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{arrays: []string{"ARRAY /dev/md/data UUID=1"}, mds: []config.Raid{{Name: "data"}}},
+			out: out{arrays: []string{"ARRAY /dev/md/data UUID=1"}},
+		},
+		{
+			in: in{
+				arrays: []string{"ARRAY /dev/md/data UUID=1", "ARRAY /dev/md0 UUID=2"},
+				mds:    []config.Raid{{Name: "data", SpareGroup: "pool"}, {Name: "/dev/md0", SpareGroup: "pool"}},
+			},
+			out: out{arrays: []string{"ARRAY /dev/md/data UUID=1 spare-group=pool", "ARRAY /dev/md0 UUID=2 spare-group=pool"}},
+		},
+		{
+			in:  in{arrays: []string{"ARRAY /dev/md/data UUID=1"}, mds: []config.Raid{{Name: "logs", SpareGroup: "pool"}}},
+			out: out{err: errors.New(`"/dev/md/logs" wasn't found among the assembled arrays`)},
+		},
+	}
+
+	for i, test := range tests {
+		arrays, err := withSpareGroups(test.in.arrays, test.in.mds)
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+		if !reflect.DeepEqual(test.out.arrays, arrays) {
+			t.Errorf("#%d: bad arrays: want %q, got %q", i, test.out.arrays, arrays)
+		}
+	}
+}
+
+func TestReplaceArrays(t *testing.T) {
+	type in struct {
+		existing string
+		arrays   []string
+	}
+	type out struct {
+		contents string
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{arrays: []string{"ARRAY /dev/md/data UUID=1"}},
+			out: out{contents: "ARRAY /dev/md/data UUID=1\n"},
+		},
+		{
+			in:  in{existing: "MAILADDR root\nARRAY /dev/md/old UUID=0\n   devices=/dev/sdb,/dev/sdc\nDEVICE partitions\n", arrays: []string{"ARRAY /dev/md/data UUID=1"}},
+			out: out{contents: "MAILADDR root\nDEVICE partitions\nARRAY /dev/md/data UUID=1\n"},
+		},
+	}
+
+	for i, test := range tests {
+		root, err := ioutil.TempDir("", "ignition-storage-")
+		if err != nil {
+			t.Fatalf("failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(root)
+		path := filepath.Join(root, mdadmConfPath)
+		if test.in.existing != "" {
+			os.MkdirAll(filepath.Dir(path), 0755)
+			ioutil.WriteFile(path, []byte(test.in.existing), 0644)
+		}
+
+		logger := log.NewTest()
+		s := stage{Util: util.Util{DestDir: root, Logger: &logger}}
+		if err := s.replaceArrays(test.in.arrays); err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if contents, _ := ioutil.ReadFile(path); string(contents) != test.out.contents {
+			t.Errorf("#%d: bad contents: want %q, got %q", i, test.out.contents, string(contents))
+		}
+	}
+}
+
+func TestScanArraysDryRun(t *testing.T) {
+	mds := []config.Raid{
+		{Name: "data", SpareGroup: "pool"},
+		{Name: "/dev/md0", SpareGroup: "pool"},
+	}
+	logger := log.NewTest()
+	s := stage{Util: util.Util{Logger: &logger, DryRun: true}}
+
+	arrays, err := s.scanArrays(mds)
+	if err == nil {
+		arrays, err = withSpareGroups(arrays, mds)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		"ARRAY /dev/md/data UUID=<uuid of /dev/md/data> spare-group=pool",
+		"ARRAY /dev/md0 UUID=<uuid of /dev/md0> spare-group=pool",
+	}
+	if !reflect.DeepEqual(want, arrays) {
+		t.Errorf("bad arrays: want %q, got %q", want, arrays)
+	}
+}
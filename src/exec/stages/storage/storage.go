@@ -13,28 +13,41 @@
 // limitations under the License.
 
 // The storage stage is responsible for partitioning disks, creating RAID
-// arrays, formatting partitions, writing files, writing systemd units, and
-// writing network units.
+// arrays and LVM volumes, formatting partitions, writing files, writing
+// systemd units, and writing network units.
 
 package storage
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/coreos/ignition/config"
 	"github.com/coreos/ignition/src/exec/stages"
 	"github.com/coreos/ignition/src/exec/util"
 	"github.com/coreos/ignition/src/log"
+	"github.com/coreos/ignition/src/sfdisk"
 	"github.com/coreos/ignition/src/sgdisk"
 	"github.com/coreos/ignition/src/systemd"
 )
 
 const (
 	name = "storage"
+
+	// bounds on waiting for a newly created array's initial sync
+	raidSyncTimeout      = 30 * time.Minute
+	raidSyncPollInterval = 5 * time.Second
 )
 
 func init() {
@@ -43,11 +56,25 @@ func init() {
 
 type creator struct{}
 
-func (creator) Create(logger *log.Logger, root string) stages.Stage {
-	return &stage{util.Util{
-		DestDir: root,
-		Logger:  logger,
-	}}
+func (creator) Create(logger *log.Logger, root string, opts stages.Options) stages.Stage {
+	return &stage{
+		Util: util.Util{
+			DestDir:         root,
+			DryRun:          opts.DryRun,
+			FetchTimeout:    opts.FetchTimeout,
+			FetchAttempts:   opts.FetchAttempts,
+			FetchBackoff:    opts.FetchBackoff,
+			FetchMaxBackoff: opts.FetchMaxBackoff,
+			DeviceTimeout:   opts.DeviceTimeout,
+			OpTimeout:       opts.OpTimeout,
+			Metadata:        opts.Metadata,
+			Logger:          logger,
+			Report:          opts.Report,
+		},
+		stopArrays:        opts.StopArrays,
+		deactivateGroups:  opts.DeactivateGroups,
+		partitionAttempts: opts.PartitionAttempts,
+	}
 }
 
 func (creator) Name() string {
@@ -56,6 +83,10 @@ func (creator) Name() string {
 
 type stage struct {
 	util.Util
+
+	stopArrays        bool
+	deactivateGroups  bool
+	partitionAttempts int // 0 leaves sgdisk's default
 }
 
 func (stage) Name() string {
@@ -63,37 +94,290 @@ func (stage) Name() string {
 }
 
 func (s stage) Run(config config.Config) bool {
+	if err := s.releaseDevices(); err != nil {
+		s.Logger.Crit("failed to release devices: %v", err)
+		return false
+	}
 
 	if err := s.createPartitions(config); err != nil {
 		s.Logger.Crit("create partitions failed: %v", err)
 		return false
 	}
 
+	if err := s.waitOnDeviceGroups(memberDeviceGroups(config.Storage)); err != nil {
+		s.Logger.Crit("%v", err)
+		return false
+	}
+
 	if err := s.createRaids(config); err != nil {
 		s.Logger.Crit("failed to create raids: %v", err)
 		return false
 	}
 
+	if err := s.createVolumeGroups(config); err != nil {
+		s.Logger.Crit("failed to create volume groups: %v", err)
+		return false
+	}
+
+	if err := s.createLuks(config); err != nil {
+		s.Logger.Crit("failed to create luks volumes: %v", err)
+		return false
+	}
+
+	fss, err := s.resolveFilesystemDevices(config.Storage.Filesystems)
+	if err != nil {
+		s.Logger.Crit("failed to resolve filesystem devices: %v", err)
+		return false
+	}
+	config.Storage.Filesystems = util.TargetFiles(fss)
+
 	if err := s.createFilesystems(config); err != nil {
 		s.Logger.Crit("failed to create filesystems: %v", err)
 		return false
 	}
 
+	mounts, err := s.mountFilesystems(config)
+	defer s.unmountFilesystems(mounts)
+	if err != nil {
+		s.Logger.Crit("failed to mount filesystems: %v", err)
+		return false
+	}
+
+	if err := s.writeFstab(config); err != nil {
+		s.Logger.Crit("failed to write fstab: %v", err)
+		return false
+	}
+
+	if err := s.writeCrypttab(config); err != nil {
+		s.Logger.Crit("failed to write crypttab: %v", err)
+		return false
+	}
+
+	if err := s.writeMdadmConf(config); err != nil {
+		s.Logger.Crit("failed to write mdadm.conf: %v", err)
+		return false
+	}
+
 	return true
 }
 
-// waitOnDevices waits for the devices enumerated in devs as a logged operation
-// using ctxt for the logging and systemd unit identity.
+// releaseDevices deactivates every volume group and stops every raid array
+// on the host, when asked to, so that arrays and volumes udev assembled from
+// the remains on reused disks don't hold them open. This is indiscriminate:
+// it doesn't stop at the devices the config touches.
+func (s stage) releaseDevices() error {
+	if !s.stopArrays && !s.deactivateGroups {
+		return nil
+	}
+	s.Logger.PushPrefix("releaseDevices")
+	defer s.Logger.PopPrefix()
+
+	// volumes may sit atop arrays, so they go first
+	if s.deactivateGroups {
+		if err := s.RunCmd(
+			exec.Command("/sbin/vgchange", "-an"),
+			"deactivating all volume groups",
+		); err != nil {
+			return fmt.Errorf("vgchange failed: %v", err)
+		}
+	}
+	if s.stopArrays {
+		if err := s.RunCmd(
+			exec.Command("/sbin/mdadm", "--stop", "--scan"),
+			"stopping all raid arrays",
+		); err != nil {
+			return fmt.Errorf("mdadm failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// waitOnDevices waits for the devices enumerated in devs, then for the
+// device nodes any of them link to. udev may create a link such as
+// /dev/disk/by-id/... before it's done with the node, so having the link
+// alone isn't enough to go on.
 func (s stage) waitOnDevices(devs []string, ctxt string) error {
-	if err := s.LogOp(
-		func() error { return systemd.WaitOnDevices(devs, ctxt) },
+	if err := s.waitOnDeviceUnits(devs, ctxt); err != nil {
+		return err
+	}
+	if s.DryRun {
+		// nothing was waited on, so there may be no links to resolve
+		return nil
+	}
+
+	nodes, err := s.resolveDeviceLinks(devs)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s devs: %v", ctxt, err)
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	return s.waitOnDeviceUnits(nodes, ctxt+" nodes")
+}
+
+// resolveDeviceLinks returns the canonical paths of those of devs which are
+// links, once each.
+func (s stage) resolveDeviceLinks(devs []string) ([]string, error) {
+	seen := map[string]bool{}
+	nodes := []string{}
+	for _, dev := range devs {
+		node, err := filepath.EvalSymlinks(dev)
+		if err != nil {
+			return nil, err
+		}
+		if node == dev || seen[node] {
+			continue
+		}
+		s.Logger.Info("%q resolves to %q", dev, node)
+		seen[node] = true
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// waitOnDeviceUnits waits for the devices enumerated in devs as a logged
+// operation using ctxt for the logging and systemd unit identity. It gives up
+// once s.DeviceTimeout has elapsed, listing the devices which never appeared.
+func (s stage) waitOnDeviceUnits(devs []string, ctxt string) error {
+	if err := s.RunOp(
+		func() error { return systemd.WaitOnDevices(devs, ctxt, s.DeviceTimeout) },
 		"waiting for devices %v", devs,
 	); err != nil {
+		if missing, ok := err.(systemd.MissingDevicesError); ok {
+			for _, dev := range missing.Devices {
+				s.Logger.Err("%s device %q never appeared", ctxt, dev)
+			}
+		}
 		return fmt.Errorf("failed to wait on %s devs: %v", ctxt, err)
 	}
 	return nil
 }
 
+// deviceGroup is a set of devices waited on together, named for the logs.
+type deviceGroup struct {
+	ctxt string
+	devs []string
+}
+
+// waitOnDeviceGroups waits for each of groups concurrently, so their udev
+// settle times overlap rather than add up. Every group is waited on to
+// completion, and any failures are reported against their own group.
+func (s stage) waitOnDeviceGroups(groups []deviceGroup) error {
+	errs := make([]error, len(groups))
+	var wg sync.WaitGroup
+	for i, g := range groups {
+		wg.Add(1)
+		go func(i int, g deviceGroup) {
+			defer wg.Done()
+
+			// each group gets its own logger so its messages remain coherent
+			gs := s
+			gs.Logger = s.Logger.Buffer()
+			defer gs.Logger.Flush()
+
+			errs[i] = gs.waitOnDevices(g.devs, g.ctxt)
+		}(i, g)
+	}
+	wg.Wait()
+
+	msgs := []string{}
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) != 0 {
+		return fmt.Errorf("%s", strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// memberDeviceGroups returns the devices which the raid, volume group, luks
+// and filesystem steps will wait on, less those the steps create themselves.
+// Waiting on them all at once, as soon as the partitions exist, leaves the
+// later per-step waits with little but arrays and logical volumes to wait for.
+func memberDeviceGroups(storage config.Storage) []deviceGroup {
+	created := map[string]bool{}
+	for _, md := range storage.Arrays {
+		for _, dev := range raidAliases(md.Name) {
+			created[dev] = true
+		}
+	}
+	for _, vg := range storage.VolumeGroups {
+		for _, lv := range vg.Volumes {
+			created[filepath.Join("/dev", vg.Name, lv.Name)] = true
+			// device-mapper doubles any dashes within the names
+			created[filepath.Join("/dev/mapper", strings.Replace(vg.Name, "-", "--", -1)+"-"+strings.Replace(lv.Name, "-", "--", -1))] = true
+		}
+	}
+	for _, l := range storage.Luks {
+		created[l.MapperPath()] = true
+	}
+	existing := func(devs []string) []string {
+		kept := []string{}
+		for _, dev := range devs {
+			if !created[dev] {
+				kept = append(kept, dev)
+			}
+		}
+		return kept
+	}
+
+	members := []string{}
+	for _, md := range storage.Arrays {
+		members = append(members, presentDevices(md.Devices)...)
+	}
+	pvs := []string{}
+	for _, vg := range storage.VolumeGroups {
+		for _, dev := range vg.Devices {
+			pvs = append(pvs, string(dev))
+		}
+	}
+	crypts := []string{}
+	for _, l := range storage.Luks {
+		crypts = append(crypts, string(l.Device))
+	}
+	fss := []string{}
+	for _, fs := range storage.Filesystems {
+		fss = append(fss, util.DeviceLink(fs.Device))
+	}
+
+	groups := []deviceGroup{}
+	for _, g := range []deviceGroup{
+		{ctxt: "raid members", devs: existing(members)},
+		{ctxt: "volume group members", devs: existing(pvs)},
+		{ctxt: "luks devices", devs: existing(crypts)},
+		{ctxt: "filesystem devices", devs: existing(fss)},
+	} {
+		if len(g.devs) != 0 {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// checkPartitionTypes verifies the partition types named across disks, in
+// place of type GUIDs or MBR type codes, are all known.
+func checkPartitionTypes(disks []config.Disk) error {
+	for _, disk := range disks {
+		typeOf := sgdisk.TypeGUID
+		if disk.TableType == "mbr" {
+			typeOf = sfdisk.TypeCode
+		}
+		for _, part := range disk.Partitions {
+			if _, err := typeOf(string(part.TypeGUID)); err != nil {
+				return fmt.Errorf("disk %q: partition %d: %v", disk.Device, part.Number, err)
+			}
+		}
+		for _, sel := range disk.PreservePartitions {
+			if _, err := sgdisk.TypeGUID(string(sel.TypeGUID)); err != nil {
+				return fmt.Errorf("disk %q: partitions to preserve: %v", disk.Device, err)
+			}
+		}
+	}
+	return nil
+}
+
 // createPartitions creates the partitions described in config.Storage.Disks.
 func (s stage) createPartitions(config config.Config) error {
 	if len(config.Storage.Disks) == 0 {
@@ -102,6 +386,11 @@ func (s stage) createPartitions(config config.Config) error {
 	s.Logger.PushPrefix("createPartitions")
 	defer s.Logger.PopPrefix()
 
+	// refuse unknown partition types before any disk is touched
+	if err := checkPartitionTypes(config.Storage.Disks); err != nil {
+		return err
+	}
+
 	devs := []string{}
 	for _, disk := range config.Storage.Disks {
 		devs = append(devs, string(disk.Device))
@@ -112,28 +401,99 @@ func (s stage) createPartitions(config config.Config) error {
 	}
 
 	for _, dev := range config.Storage.Disks {
+		matched := false
 		err := s.Logger.LogOp(func() error {
+			if dev.TableType == "mbr" {
+				var err error
+				matched, err = s.partitionMBR(dev)
+				return err
+			}
+
 			op := sgdisk.Begin(s.Logger, string(dev.Device))
+			op.DryRun(s.DryRun)
+			if s.partitionAttempts != 0 {
+				op.BusyAttempts(s.partitionAttempts)
+			}
 			if dev.WipeTable {
-				s.Logger.Info("wiping partition table requested on %q", dev.Device)
+				if len(dev.PreservePartitions) != 0 {
+					s.Logger.Info("wiping partition table requested on %q, preserving %d selected partition(s)", dev.Device, len(dev.PreservePartitions))
+				} else {
+					s.Logger.Info("wiping partition table requested on %q", dev.Device)
+				}
 				op.WipeTable(true)
 			}
 
-			for _, part := range dev.Partitions {
-				op.CreatePartition(sgdisk.Partition{
-					Number:   part.Number,
-					Length:   uint64(part.Size),
-					Offset:   uint64(part.Start),
-					Label:    string(part.Label),
-					TypeGUID: string(part.TypeGUID),
+			for _, num := range dev.DeletePartitions {
+				op.DeletePartition(num)
+			}
+
+			for _, sel := range dev.PreservePartitions {
+				typeGUID, err := sgdisk.TypeGUID(string(sel.TypeGUID))
+				if err != nil {
+					return err
+				}
+				op.Preserve(sgdisk.Selector{
+					Label:    string(sel.Label),
+					TypeGUID: typeGUID,
 				})
 			}
 
+			parts, err := s.resolvePartitions(string(dev.Device), dev.Partitions, dev.TableType, gptExisting(op))
+			if err != nil {
+				return err
+			}
+			if err := s.checkCapacity(string(dev.Device), parts, dev.TableType); err != nil {
+				return err
+			}
+
+			for _, part := range parts {
+				typeGUID, err := sgdisk.TypeGUID(string(part.TypeGUID))
+				if err != nil {
+					return fmt.Errorf("partition %d: %v", part.Number, err)
+				}
+				p := sgdisk.Partition{
+					Number:     part.Number,
+					Length:     uint64(part.Size),
+					Offset:     uint64(part.Start),
+					Label:      string(part.Label),
+					TypeGUID:   typeGUID,
+					GUID:       string(part.GUID),
+					Attributes: []uint(part.Attributes),
+				}
+				if part.ShouldExist {
+					op.ExpectPartition(p)
+				} else {
+					op.CreatePartition(p)
+				}
+			}
+
+			// the image provided partitions are checked before anything is changed
+			if err := op.Verify(); err != nil {
+				return err
+			}
+
+			if !dev.WipeTable {
+				if match, err := op.Matches(); err != nil {
+					s.Logger.Warning("unable to compare existing partitions on %q: %v", dev.Device, err)
+				} else if match {
+					s.Logger.Info("existing partitions on %q match, nothing to do", dev.Device)
+					matched = true
+					return nil
+				}
+			}
+
 			if err := op.Commit(); err != nil {
 				return fmt.Errorf("commit failure: %v", err)
 			}
+
+			s.rereadTable(string(dev.Device))
 			return nil
 		}, "partitioning %q", dev.Device)
+		if matched {
+			s.Report.Skip("partition disk", string(dev.Device))
+		} else {
+			s.Report.Add("partition disk", string(dev.Device), err)
+		}
 		if err != nil {
 			return err
 		}
@@ -152,23 +512,40 @@ func (s stage) createRaids(config config.Config) error {
 
 	devs := []string{}
 	for _, array := range config.Storage.Arrays {
-		for _, dev := range array.Devices {
-			devs = append(devs, string(dev))
-		}
+		devs = append(devs, presentDevices(array.Devices)...)
 	}
 
 	if err := s.waitOnDevices(devs, "raids"); err != nil {
 		return err
 	}
 
+	// mdadm may accept a member twice, only to misbehave later
+	if err := checkRaidMembers(config.Storage.Arrays); err != nil {
+		return err
+	}
+
 	for _, md := range config.Storage.Arrays {
-		// FIXME(vc): this is utterly flummoxed by a preexisting md.Name, the magic of device-resident md metadata really interferes with us.
-		// It's as if what ignition really needs is to turn off automagic md probing/running before getting started.
+		if assembled, err := s.assembleRaid(md); err != nil {
+			s.Report.Add("assemble raid", md.Name, err)
+			return err
+		} else if assembled {
+			s.Report.Add("assemble raid", md.Name, nil)
+			continue
+		}
+
+		// the array is about to be (re)created, so any prior md metadata on its
+		// members only gets in the way of --create
+		if err := s.clearRaidMembers(md.Devices); err != nil {
+			s.Report.Add("create raid", md.Name, err)
+			return err
+		}
+
 		args := []string{
 			"--create", md.Name,
 			"--force",
 			"--run",
 			"--level", md.Level,
+			// missing members still count towards the array's size
 			"--raid-devices", fmt.Sprintf("%d", len(md.Devices)-md.Spares),
 		}
 
@@ -176,21 +553,352 @@ func (s stage) createRaids(config config.Config) error {
 			args = append(args, "--spare-devices", fmt.Sprintf("%d", md.Spares))
 		}
 
+		if md.Metadata != "" {
+			args = append(args, "--metadata", string(md.Metadata))
+		}
+
+		if md.Bitmap != "" {
+			args = append(args, "--bitmap", string(md.Bitmap))
+		}
+
 		for _, dev := range md.Devices {
 			args = append(args, string(dev))
 		}
 
-		if err := s.Logger.LogCmd(
-			exec.Command("/sbin/mdadm", args...),
+		err := s.RunCmdTimeout(
+			"/sbin/mdadm", args,
 			"creating %q", md.Name,
-		); err != nil {
+		)
+		s.Report.Add("create raid", md.Name, err)
+		if err != nil {
 			return fmt.Errorf("mdadm failed: %v", err)
 		}
+
+		if md.WaitForSync {
+			if err := s.waitForRaidSync(md.Name); err != nil {
+				s.Logger.Warning("proceeding without a completed sync: %v", err)
+			}
+		}
 	}
 
 	return nil
 }
 
+// clearRaidMembers stops any assembled arrays holding devs and zeroes the md
+// superblocks found on them.
+func (s stage) clearRaidMembers(members []config.RaidDevice) error {
+	devs := presentDevices(members)
+	stopped := map[string]bool{}
+	for _, dev := range devs {
+		for _, md := range raidHolders(dev) {
+			if stopped[md] {
+				continue
+			}
+			if err := s.RunCmd(
+				exec.Command("/sbin/mdadm", "--stop", md),
+				"stopping %q holding %q", md, dev,
+			); err != nil {
+				return fmt.Errorf("failed to stop %q: %v", md, err)
+			}
+			stopped[md] = true
+		}
+	}
+
+	for _, dev := range devs {
+		if !hasRaidSuperblock(dev) {
+			// no md superblock to get rid of
+			continue
+		}
+		if err := s.RunCmd(
+			exec.Command("/sbin/mdadm", "--zero-superblock", dev),
+			"zeroing md superblock on %q", dev,
+		); err != nil {
+			return fmt.Errorf("failed to zero superblock on %q: %v", dev, err)
+		}
+	}
+	return nil
+}
+
+// assembleRaid assembles md from the existing superblocks on its members,
+// reporting false when md is to be created instead: either because it isn't
+// in assemble mode or because its members have no superblocks yet. Members
+// held by anything other than md itself fail the assembly.
+func (s stage) assembleRaid(md config.Raid) (bool, error) {
+	if md.Mode != config.RaidModeAssemble {
+		return false, nil
+	}
+
+	devs := presentDevices(md.Devices)
+	for _, dev := range devs {
+		if !hasRaidSuperblock(dev) {
+			s.Logger.Info("%q has no md superblock, creating %q", dev, md.Name)
+			return false, nil
+		}
+	}
+
+	// the kernel node of md, if it's already assembled
+	node, err := filepath.EvalSymlinks(raidDevice(md.Name))
+	if err != nil {
+		node = ""
+	}
+	assembled := false
+	for _, dev := range devs {
+		held, err := heldByArray(dev, blockHolders(dev), node)
+		if err != nil {
+			return false, err
+		}
+		assembled = assembled || held
+	}
+	if assembled {
+		s.Logger.Info("%q is already assembled as %q", md.Name, node)
+		return true, nil
+	}
+
+	args := append([]string{"--assemble", md.Name, "--run"}, devs...)
+	if err := s.RunCmdTimeout(
+		"/sbin/mdadm", args,
+		"assembling %q", md.Name,
+	); err != nil {
+		return false, fmt.Errorf("mdadm failed: %v", err)
+	}
+	return true, nil
+}
+
+// hasRaidSuperblock reports whether dev carries an md superblock.
+func hasRaidSuperblock(dev string) bool {
+	return exec.Command("/sbin/mdadm", "--examine", dev).Run() == nil
+}
+
+// checkRaidMembers returns an error naming the first device which is a member
+// of arrays twice, whether of one array or of two. Members are compared by the
+// device nodes they resolve to, so that aliases such as by-id links are caught.
+func checkRaidMembers(arrays []config.Raid) error {
+	owners := map[string]string{}
+	for _, md := range arrays {
+		for _, member := range presentDevices(md.Devices) {
+			dev := member
+			if resolved, err := filepath.EvalSymlinks(member); err == nil {
+				dev = resolved
+			}
+			if owner, ok := owners[dev]; ok {
+				if owner == md.Name {
+					return fmt.Errorf("raid %q: member %q listed more than once", md.Name, member)
+				}
+				return fmt.Errorf("raid %q: member %q is already a member of raid %q", md.Name, member, owner)
+			}
+			owners[dev] = md.Name
+		}
+	}
+	return nil
+}
+
+// presentDevices returns the paths of the array members which aren't missing.
+func presentDevices(members []config.RaidDevice) []string {
+	devs := []string{}
+	for _, dev := range members {
+		if dev != config.RaidDeviceMissing {
+			devs = append(devs, string(dev))
+		}
+	}
+	return devs
+}
+
+// waitForRaidSync polls the md sync_action of the array named name until it
+// is idle, giving up after raidSyncTimeout.
+func (s stage) waitForRaidSync(name string) error {
+	return s.RunOp(func() error {
+		path, err := filepath.EvalSymlinks(raidDevice(name))
+		if err != nil {
+			return err
+		}
+		action := filepath.Join("/sys/class/block", filepath.Base(path), "md", "sync_action")
+
+		deadline := time.Now().Add(raidSyncTimeout)
+		for {
+			state, err := ioutil.ReadFile(action)
+			if err != nil {
+				return err
+			}
+			if strings.TrimSpace(string(state)) == "idle" {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("timed out after %v, still %s", raidSyncTimeout, strings.TrimSpace(string(state)))
+			}
+			time.Sleep(raidSyncPollInterval)
+		}
+	}, "waiting for %q to sync", name)
+}
+
+// raidDevice returns the device of the array named name: mdadm places names
+// which aren't paths under /dev/md.
+func raidDevice(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join("/dev/md", name)
+}
+
+// mdNodeRegexp matches the names of the kernel's md device nodes.
+var mdNodeRegexp = regexp.MustCompile("^md[0-9]+$")
+
+// raidAliases returns the paths the array named name may be referred to by:
+// its device and, for names of the kernel's mdN form, both the kernel node and
+// the /dev/md link udev makes for it.
+func raidAliases(name string) []string {
+	dev := raidDevice(name)
+	base := filepath.Base(dev)
+	if !mdNodeRegexp.MatchString(base) {
+		return []string{dev}
+	}
+	return []string{dev, filepath.Join("/dev", base), filepath.Join("/dev/md", base)}
+}
+
+// raidHolders returns the md devices currently holding dev, according to sysfs.
+func raidHolders(dev string) []string {
+	mds := []string{}
+	for _, h := range blockHolders(dev) {
+		if strings.HasPrefix(filepath.Base(h), "md") {
+			mds = append(mds, h)
+		}
+	}
+	return mds
+}
+
+// blockHolders returns the devices currently holding dev, such as md arrays,
+// LVM volumes or dm-crypt mappings, according to sysfs.
+func blockHolders(dev string) []string {
+	path, err := filepath.EvalSymlinks(dev)
+	if err != nil {
+		return nil
+	}
+	holders, err := ioutil.ReadDir(filepath.Join("/sys/class/block", filepath.Base(path), "holders"))
+	if err != nil {
+		return nil
+	}
+
+	devs := []string{}
+	for _, h := range holders {
+		devs = append(devs, filepath.Join("/dev", h.Name()))
+	}
+	return devs
+}
+
+// heldByArray reports whether the member dev, held by holders, is part of the
+// assembled array whose kernel node is node, which is empty if the array isn't
+// assembled. Being held by anything else means dev is in use elsewhere, which
+// is an error.
+func heldByArray(dev string, holders []string, node string) (bool, error) {
+	for _, h := range holders {
+		if h != node {
+			return false, fmt.Errorf("%q is in use by %q", dev, h)
+		}
+	}
+	return len(holders) != 0, nil
+}
+
+// createVolumeGroups creates the LVM volume groups and logical volumes
+// described in config.Storage.VolumeGroups.
+func (s stage) createVolumeGroups(config config.Config) error {
+	if len(config.Storage.VolumeGroups) == 0 {
+		return nil
+	}
+	s.Logger.PushPrefix("createVolumeGroups")
+	defer s.Logger.PopPrefix()
+
+	devs := []string{}
+	for _, vg := range config.Storage.VolumeGroups {
+		for _, dev := range vg.Devices {
+			devs = append(devs, string(dev))
+		}
+	}
+
+	if err := s.waitOnDevices(devs, "volume groups"); err != nil {
+		return err
+	}
+
+	for _, vg := range config.Storage.VolumeGroups {
+		pvs := []string{}
+		for _, dev := range vg.Devices {
+			pvs = append(pvs, string(dev))
+		}
+
+		if err := s.RunCmdTimeout(
+			"/sbin/pvcreate", append([]string{"--force", "--yes"}, pvs...),
+			"creating physical volumes %v", pvs,
+		); err != nil {
+			return fmt.Errorf("pvcreate failed: %v", err)
+		}
+
+		err := s.RunCmdTimeout(
+			"/sbin/vgcreate", append([]string{vg.Name}, pvs...),
+			"creating volume group %q", vg.Name,
+		)
+		s.Report.Add("create volume group", vg.Name, err)
+		if err != nil {
+			return fmt.Errorf("vgcreate failed: %v", err)
+		}
+
+		for _, lv := range vg.Volumes {
+			args := []string{"--yes", "--name", lv.Name}
+			if lv.Size == 0 {
+				args = append(args, "--extents", "100%FREE")
+			} else {
+				// sizes are in 512-byte sectors, which lvm calls "s"
+				args = append(args, "--size", fmt.Sprintf("%ds", lv.Size))
+			}
+			args = append(args, vg.Name)
+
+			err := s.RunCmdTimeout(
+				"/sbin/lvcreate", args,
+				"creating logical volume \"%s/%s\"", vg.Name, lv.Name,
+			)
+			s.Report.Add("create logical volume", vg.Name+"/"+lv.Name, err)
+			if err != nil {
+				return fmt.Errorf("lvcreate failed: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolveFilesystemDevices returns fss with the devices referenced by label or
+// uuid replaced by the device nodes they resolve to, once their links have
+// appeared, so the later steps needn't care how the device was referenced.
+func (s stage) resolveFilesystemDevices(fss []config.Filesystem) ([]config.Filesystem, error) {
+	links := []string{}
+	for _, fs := range fss {
+		if _, _, ok := fs.Device.Tag(); ok {
+			links = append(links, util.DeviceLink(fs.Device))
+		}
+	}
+	if len(links) == 0 {
+		return fss, nil
+	}
+	s.Logger.PushPrefix("resolveFilesystemDevices")
+	defer s.Logger.PopPrefix()
+
+	if err := s.waitOnDevices(links, "filesystem labels"); err != nil {
+		return nil, err
+	}
+
+	resolved := make([]config.Filesystem, len(fss))
+	copy(resolved, fss)
+	for i, fs := range resolved {
+		if _, _, ok := fs.Device.Tag(); !ok {
+			continue
+		}
+		dev, err := s.ResolveDevice(fs.Device)
+		if err != nil {
+			return nil, err
+		}
+		s.Logger.Info("%q resolved to %q", fs.Device, dev)
+		resolved[i].Device = dev
+	}
+	return resolved, nil
+}
+
 // createFilesystems creates the filesystems described in config.Storage.Filesystems.
 func (s stage) createFilesystems(config config.Config) error {
 	if len(config.Storage.Filesystems) == 0 {
@@ -208,42 +916,400 @@ func (s stage) createFilesystems(config config.Config) error {
 		return err
 	}
 
-	for _, fs := range config.Storage.Filesystems {
-		if fs.Initialize {
-			mkfs := ""
-			args := []string(fs.Options)
-			switch fs.Format {
-			case "btrfs":
-				mkfs = "/sbin/mkfs.btrfs"
-				args = append(args, "--force")
-			case "ext4":
-				mkfs = "/sbin/mkfs.ext4"
-				args = append(args, "-F")
-			default:
-				return fmt.Errorf("unsupported filesystem format: %q", fs.Format)
+	return s.createFilesystemsByDevice(config.Storage.Filesystems)
+}
+
+// createFilesystemsByDevice creates the filesystems in fss. Filesystems on
+// distinct devices are independent of one another and are created
+// concurrently, those sharing a device are created in order. The first error
+// encountered stops any devices not yet started and is returned.
+func (s stage) createFilesystemsByDevice(fss []config.Filesystem) error {
+	byDev := map[config.DevicePath][]config.Filesystem{}
+	order := []config.DevicePath{}
+	for _, fs := range fss {
+		if _, ok := byDev[fs.Device]; !ok {
+			order = append(order, fs.Device)
+		}
+		byDev[fs.Device] = append(byDev[fs.Device], fs)
+	}
+
+	sem := make(chan struct{}, runtime.NumCPU())
+	cancel := make(chan struct{})
+	errs := make(chan error, len(order))
+	once := sync.Once{}
+	wg := sync.WaitGroup{}
+
+	for _, dev := range order {
+		wg.Add(1)
+		go func(fss []config.Filesystem) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-cancel:
+				return
 			}
 
-			args = append(args, string(fs.Device))
-			if err := s.Logger.LogCmd(
-				exec.Command(mkfs, args...),
-				"creating %q filesystem on %q",
-				fs.Format, string(fs.Device),
-			); err != nil {
-				return fmt.Errorf("failed to run %q: %v %v", mkfs, err, args)
+			// each device gets its own logger so its messages remain coherent
+			ds := s
+			ds.Logger = s.Logger.Buffer()
+			defer ds.Logger.Flush()
+
+			for _, fs := range fss {
+				select {
+				case <-cancel:
+					return
+				default:
+				}
+
+				if err := ds.createFilesystem(fs); err != nil {
+					errs <- err
+					once.Do(func() { close(cancel) })
+					return
+				}
 			}
+		}(byDev[dev])
+	}
+
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+// createFilesystem initializes fs if requested, grows it if requested and
+// creates its files.
+func (s stage) createFilesystem(fs config.Filesystem) error {
+	// ext4 is grown unmounted, and only if it wasn't just made to fit
+	growExt4 := fs.Resize && fs.Format == "ext4"
+	if growExt4 && fs.Initialize {
+		exists, err := s.filesystemExists(fs)
+		if err != nil {
+			return err
+		}
+		growExt4 = exists
+	}
+
+	if fs.Initialize {
+		if err := s.initializeFilesystem(fs); err != nil {
+			return err
+		}
+	}
+
+	if growExt4 {
+		if err := s.growExt4(fs); err != nil {
+			return err
+		}
+	}
+
+	// swap areas can't be mounted, config validation rejects files on them
+	if fs.Format == "swap" {
+		return nil
+	}
+
+	// filesystems which are kept mounted have their files written once mounted
+	if fs.KeepMounted {
+		return nil
+	}
+
+	if err := s.createFiles(fs); err != nil {
+		return fmt.Errorf("failed to create files %q: %v", fs.Device, err)
+	}
+
+	return nil
+}
+
+// initializeFilesystem runs the appropriate mkfs for fs.Format on fs.Device.
+// If fs.CreateIfMissing is set and the device already contains a filesystem
+// of the requested format, mkfs is skipped. Unless fs.Force is set, devices
+// which are in use are refused. If fs.WipeFilesystem is set, any stale
+// signatures are wiped from the device before running mkfs.
+func (s stage) initializeFilesystem(fs config.Filesystem) error {
+	if fs.CreateIfMissing {
+		exists, err := s.filesystemExists(fs)
+		if err != nil {
+			return err
+		}
+		if exists {
+			s.Logger.Info("skipping mkfs, %q filesystem already present on %q", fs.Format, fs.Device)
+			s.Report.Skip("format filesystem", string(fs.Device))
+			return nil
 		}
+	}
+
+	if !fs.Force {
+		reason, err := s.deviceInUse(fs.Device)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			return fmt.Errorf("refusing to format %q: device is %s (set force to override)", fs.Device, reason)
+		}
+	}
 
-		if err := s.createFiles(fs); err != nil {
-			return fmt.Errorf("failed to create files %q: %v", fs.Device, err)
+	if fs.WipeFilesystem {
+		if err := s.wipeSignatures(fs.Device); err != nil {
+			return err
 		}
 	}
 
+	mkfs := ""
+	args := []string(fs.Options)
+	switch fs.Format {
+	case "btrfs":
+		mkfs = "/sbin/mkfs.btrfs"
+		args = append(args, "--force")
+	case "ext4":
+		mkfs = "/sbin/mkfs.ext4"
+		args = append(args, "-F")
+		if fs.Ext4 != nil && fs.Ext4.DisableLazyInit {
+			args = mergeExtendedOptions(args, "lazy_itable_init=0,lazy_journal_init=0")
+		}
+	case "swap":
+		mkfs = "/sbin/mkswap"
+		args = append(args, "-f")
+	default:
+		return fmt.Errorf("unsupported filesystem format: %q", fs.Format)
+	}
+	mkfs, err := s.findBinary(mkfs)
+	if err != nil {
+		return err
+	}
+
+	// all of the supported mkfs variants take their label via -L and uuid via -U
+	if fs.Label != "" {
+		args = append(args, "-L", fs.Label)
+	}
+	if fs.UUID != "" {
+		args = append(args, "-U", fs.UUID)
+	}
+
+	args = append(args, string(fs.Device))
+	err = s.RunCmdTimeout(
+		mkfs, args,
+		"creating %q filesystem on %q",
+		fs.Format, string(fs.Device),
+	)
+	s.Report.Add("format filesystem", string(fs.Device), err)
+	if err != nil {
+		return fmt.Errorf("failed to run %q: %v %v", mkfs, err, args)
+	}
+
+	return s.tuneFilesystem(fs)
+}
+
+// mergeExtendedOptions adds opts to the last -E argument in args, as mke2fs
+// honours only the last one, or appends a new -E when there is none. args
+// itself is left untouched.
+func mergeExtendedOptions(args []string, opts string) []string {
+	merged := append([]string{}, args...)
+	for i := len(merged) - 1; i >= 0; i-- {
+		switch {
+		case merged[i] == "-E" && i+1 < len(merged):
+			merged[i+1] += "," + opts
+			return merged
+		case strings.HasPrefix(merged[i], "-E") && merged[i] != "-E":
+			merged[i] += "," + opts
+			return merged
+		}
+	}
+	return append(merged, "-E", opts)
+}
+
+// wipeSignatures wipes every filesystem, raid and partition table signature
+// from dev, leaving the rest of the device be. The signatures are listed first
+// so that the log tells what was wiped.
+func (s stage) wipeSignatures(dev config.DevicePath) error {
+	wipefs, err := s.findBinary("/sbin/wipefs")
+	if err != nil {
+		return err
+	}
+	// in a dry run the device may not even exist yet, there's nothing to list
+	if !s.DryRun {
+		sigs, err := s.listSignatures(dev)
+		if err != nil {
+			return err
+		}
+		if len(sigs) == 0 {
+			s.Logger.Info("no signatures to wipe on %q", dev)
+			s.Report.Skip("wipe signatures", string(dev))
+			return nil
+		}
+		s.Logger.Info("wiping %d signature(s) on %q: %s", len(sigs), dev, strings.Join(sigs, ", "))
+	}
+
+	err = s.RunCmdTimeout(
+		wipefs, []string{"-a", string(dev)},
+		"wiping signatures on %q", dev,
+	)
+	s.Report.Add("wipe signatures", string(dev), err)
+	if err != nil {
+		return fmt.Errorf("wipefs failed: %v", err)
+	}
 	return nil
 }
 
-// createFiles creates any files listed for the filesystem in fs.Files.
+// listSignatures returns the signatures wipefs finds on dev. A dry run finds
+// none, since the device may not exist yet.
+func (s stage) listSignatures(dev config.DevicePath) ([]string, error) {
+	if s.DryRun {
+		return nil, nil
+	}
+	wipefs, err := s.findBinary("/sbin/wipefs")
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command(wipefs, "--parsable", string(dev))
+	cmd.Stdout = &out
+	if err := s.Logger.LogCmd(cmd, "listing signatures on %q", dev); err != nil {
+		return nil, fmt.Errorf("failed to list signatures on %q: %v", dev, err)
+	}
+	return parseSignatures(out.String()), nil
+}
+
+// parseSignatures returns the signatures listed by wipefs --parsable, whose
+// lines are "offset,uuid,label,type", as "type at offset".
+func parseSignatures(out string) []string {
+	sigs := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 4 {
+			continue
+		}
+		sigs = append(sigs, fmt.Sprintf("%s at %s", fields[len(fields)-1], fields[0]))
+	}
+	return sigs
+}
+
+// tuneFilesystem runs tune2fs on the freshly created fs to apply fs.Ext4.
+func (s stage) tuneFilesystem(fs config.Filesystem) error {
+	if fs.Ext4 == nil {
+		return nil
+	}
+
+	args := []string{}
+	if fs.Ext4.DisablePeriodicFsck {
+		args = append(args, "-c", "0", "-i", "0")
+	}
+	if fs.Ext4.ReservedBlocksPercent != nil {
+		args = append(args, "-m", fmt.Sprintf("%d", *fs.Ext4.ReservedBlocksPercent))
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	tune2fs, err := s.findBinary("/sbin/tune2fs")
+	if err != nil {
+		return err
+	}
+	args = append(args, string(fs.Device))
+	if err := s.RunCmdTimeout(
+		tune2fs, args,
+		"tuning ext4 filesystem on %q", fs.Device,
+	); err != nil {
+		return fmt.Errorf("failed to run %q: %v %v", tune2fs, err, args)
+	}
+	return nil
+}
+
+// findBinary returns where the binary usually found at path lives on this
+// host: wherever $PATH finds it, since not every distro keeps its tools in
+// /sbin, or failing that path itself.
+func (s stage) findBinary(path string) (string, error) {
+	if found, err := exec.LookPath(filepath.Base(path)); err == nil {
+		return found, nil
+	}
+	if _, err := os.Stat(path); err == nil || s.DryRun {
+		return path, nil
+	}
+	return "", fmt.Errorf("%s not found in $PATH or at %q", filepath.Base(path), path)
+}
+
+// deviceInUse checks if dev or a partition on it is mounted, or if dev is an
+// active RAID member or an LVM physical volume. A description of how the
+// device is in use is returned, or an empty string if it appears to be free.
+func (s stage) deviceInUse(dev config.DevicePath) (string, error) {
+	reason := ""
+	if err := s.RunOp(func() error {
+		// lsblk lists the device followed by any partitions, arrays and
+		// volumes built atop it
+		blocks, err := exec.Command("/bin/lsblk", "-n", "-r", "-o", "TYPE,MOUNTPOINT", string(dev)).Output()
+		if err != nil {
+			return err
+		}
+		// blkid fails when it finds no signature, leaving the type empty
+		signature, _ := exec.Command("/sbin/blkid", "-p", "-s", "TYPE", "-o", "value", string(dev)).Output()
+		reason = inUseReason(string(blocks), string(signature))
+		return nil
+	}, "checking if %q is in use", dev); err != nil {
+		return "", fmt.Errorf("failed to check if %q is in use: %v", dev, err)
+	}
+
+	if reason == "" {
+		s.Logger.Info("%q is not in use", dev)
+	} else {
+		s.Logger.Warning("%q is %s", dev, reason)
+	}
+	return reason, nil
+}
+
+// inUseReason returns how a device is in use, going by the lsblk listing of
+// its type and mount point followed by those of the devices built atop it,
+// and by the type of the signature blkid finds on it. The result is empty if
+// the device appears to be free.
+func inUseReason(blocks, signature string) string {
+	for i, line := range strings.Split(strings.TrimSpace(blocks), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case i != 0 && len(fields) > 0 && strings.HasPrefix(fields[0], "raid"):
+			return "a member of an active RAID array"
+		case i != 0 && len(fields) > 0 && fields[0] == "lvm":
+			return "backing an active LVM volume"
+		case len(fields) > 1:
+			// the device itself or one of its partitions
+			return fmt.Sprintf("mounted at %q", fields[1])
+		}
+	}
+	if strings.TrimSpace(signature) == "LVM2_member" {
+		return "an LVM physical volume"
+	}
+	return ""
+}
+
+// filesystemExists probes fs.Device using blkid and reports whether it
+// already contains a filesystem matching fs.Format.
+func (s stage) filesystemExists(fs config.Filesystem) (bool, error) {
+	found := ""
+	if err := s.RunOp(func() error {
+		out, err := exec.Command("/sbin/blkid", "-p", "-s", "TYPE", "-o", "value", string(fs.Device)).Output()
+		if err != nil {
+			// blkid exits with 2 when no signature could be found
+			if exitErr, ok := err.(*exec.ExitError); ok && exitErr.Sys().(syscall.WaitStatus).ExitStatus() == 2 {
+				return nil
+			}
+			return err
+		}
+		found = strings.TrimSpace(string(out))
+		return nil
+	}, "probing %q for an existing filesystem", fs.Device); err != nil {
+		return false, fmt.Errorf("failed to probe %q: %v", fs.Device, err)
+	}
+
+	s.Logger.Debug("found %q filesystem on %q", found, fs.Device)
+	return found == string(fs.Format), nil
+}
+
+// createFiles creates any directories, files and links listed for the
+// filesystem in fs.Directories, fs.Files and fs.Links. Filesystems which can
+// only be grown while mounted are grown then too.
 func (s stage) createFiles(fs config.Filesystem) error {
-	if len(fs.Files) == 0 {
+	if len(fs.Directories) == 0 && len(fs.Files) == 0 && len(fs.Links) == 0 && len(fs.Remove) == 0 && !growsMounted(fs) {
 		return nil
 	}
 	s.Logger.PushPrefix("createFiles")
@@ -256,31 +1322,215 @@ func (s stage) createFiles(fs config.Filesystem) error {
 	defer os.Remove(mnt)
 
 	dev := string(fs.Device)
-	format := string(fs.Format)
-
-	if err := s.Logger.LogOp(
-		func() error { return syscall.Mount(dev, mnt, format, 0, "") },
-		"mounting %q at %q", dev, mnt,
-	); err != nil {
+	if err := s.mountTimeout(fs, mnt); err != nil {
 		return fmt.Errorf("failed to mount device %q at %q: %v", dev, mnt, err)
 	}
-	defer s.Logger.LogOp(
+	defer s.RunOp(
 		func() error { return syscall.Unmount(mnt, 0) },
 		"unmounting %q at %q", dev, mnt,
 	)
 
-	u := util.Util{
-		Logger:  s.Logger,
-		DestDir: mnt,
+	if err := s.growMounted(fs, mnt); err != nil {
+		return err
 	}
-	for _, f := range fs.Files {
-		if err := s.Logger.LogOp(
-			func() error { return u.WriteFile(&f) },
-			"writing file %q", string(f.Path),
-		); err != nil {
-			return fmt.Errorf("failed to create file %q: %v", f.Path, err)
+	return s.writeFiles(fs, mnt)
+}
+
+// growExt4 grows the unmounted ext4 fs to fill its device. resize2fs insists
+// on a freshly checked filesystem before growing it offline.
+func (s stage) growExt4(fs config.Filesystem) error {
+	e2fsck, err := s.findBinary("/sbin/e2fsck")
+	if err != nil {
+		return err
+	}
+	resize2fs, err := s.findBinary("/sbin/resize2fs")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := util.TimeoutContext(s.OpTimeout)
+	defer cancel()
+	check := exec.CommandContext(ctx, e2fsck, "-f", "-p", string(fs.Device))
+	if err := s.RunCmd(check, "checking %q filesystem on %q", fs.Format, fs.Device); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("failed to check %q before resizing: timed out after %v", fs.Device, s.OpTimeout)
+		}
+		// e2fsck exits with 1 when it corrected errors, leaving the filesystem sound
+		if check.ProcessState == nil || check.ProcessState.Sys().(syscall.WaitStatus).ExitStatus() != 1 {
+			return fmt.Errorf("failed to check %q before resizing: %v", fs.Device, err)
 		}
+		s.Logger.Info("corrected errors in %q before resizing", fs.Device)
 	}
+	err = s.RunCmdTimeout(
+		resize2fs, []string{string(fs.Device)},
+		"growing %q filesystem on %q", fs.Format, fs.Device,
+	)
+	s.Report.Add("grow filesystem", string(fs.Device), err)
+	if err != nil {
+		return fmt.Errorf("failed to resize %q: %v", fs.Device, err)
+	}
+	return nil
+}
 
+// growsMounted reports whether fs is to be grown, which for its format has
+// to be done while it's mounted.
+func growsMounted(fs config.Filesystem) bool {
+	return fs.Resize && fs.Format == "btrfs"
+}
+
+// growMounted grows fs, mounted at mnt, to fill its device if it's to be
+// grown while mounted. Growing a filesystem which already fills its device,
+// such as one just made, changes nothing.
+func (s stage) growMounted(fs config.Filesystem, mnt string) error {
+	if !growsMounted(fs) {
+		return nil
+	}
+	btrfs, err := s.findBinary("/sbin/btrfs")
+	if err != nil {
+		return err
+	}
+	err = s.RunCmdTimeout(
+		btrfs, []string{"filesystem", "resize", "max", mnt},
+		"growing %q filesystem on %q", fs.Format, fs.Device,
+	)
+	s.Report.Add("grow filesystem", string(fs.Device), err)
+	if err != nil {
+		return fmt.Errorf("failed to resize %q: %v", fs.Device, err)
+	}
 	return nil
 }
+
+// writeFiles writes the contents of fs beneath mnt, where fs is mounted.
+func (s stage) writeFiles(fs config.Filesystem, mnt string) error {
+	u := s.Util
+	u.DestDir = mnt
+	return u.WriteContents(fs)
+}
+
+// mountFilesystems mounts the filesystems in config.Storage.Filesystems which
+// request KeepMounted at their MountPoint beneath the destination root, and
+// writes their files there. Parents are mounted before their children so
+// overlapping paths land on the correct filesystem. The mounted paths are
+// returned in the order they were mounted, even on failure, so they may be
+// unmounted by unmountFilesystems.
+func (s stage) mountFilesystems(config config.Config) ([]string, error) {
+	kept := []int{}
+	for i, fs := range config.Storage.Filesystems {
+		if fs.KeepMounted {
+			kept = append(kept, i)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, nil
+	}
+	s.Logger.PushPrefix("mountFilesystems")
+	defer s.Logger.PopPrefix()
+
+	fss := config.Storage.Filesystems
+	sort.SliceStable(kept, func(a, b int) bool {
+		return fss[kept[a]].MountPoint < fss[kept[b]].MountPoint
+	})
+
+	mounts := []string{}
+	for _, i := range kept {
+		fs := fss[i]
+		dev := string(fs.Device)
+		mnt := s.JoinPath(fs.MountPoint)
+
+		if !s.DryRun {
+			if err := os.MkdirAll(mnt, os.FileMode(util.DefaultDirectoryPermissions)); err != nil {
+				return mounts, fmt.Errorf("failed to create mount point %q: %v", mnt, err)
+			}
+		}
+		if err := s.mountTimeout(fs, mnt); err != nil {
+			return mounts, fmt.Errorf("failed to mount device %q at %q: %v", dev, mnt, err)
+		}
+		mounts = append(mounts, mnt)
+
+		if err := s.growMounted(fs, mnt); err != nil {
+			return mounts, err
+		}
+		if err := s.writeFiles(fs, mnt); err != nil {
+			return mounts, fmt.Errorf("failed to create files %q: %v", fs.Device, err)
+		}
+	}
+
+	return mounts, nil
+}
+
+// unmountFilesystems unmounts the paths in mounts in reverse order.
+func (s stage) unmountFilesystems(mounts []string) {
+	for i := len(mounts) - 1; i >= 0; i-- {
+		mnt := mounts[i]
+		s.RunOp(
+			func() error { return syscall.Unmount(mnt, 0) },
+			"unmounting %q", mnt,
+		)
+	}
+}
+
+// mountTimeout mounts fs at mnt via RunOpTimeout. A mount(2) can't be
+// interrupted, so one which only completes after timing out is unmounted
+// again rather than left behind, unknown to the rest of the stage.
+func (s stage) mountTimeout(fs config.Filesystem, mnt string) error {
+	dev := string(fs.Device)
+	var mu sync.Mutex
+	finished, abandoned := false, false
+	// the stage carries on logging while an abandoned mount finishes, so
+	// the unmount logs through a copy of its own
+	late := s.Logger.Buffer()
+	unmount := func() {
+		late.Warning("mounting %q at %q finished after timing out, unmounting it", dev, mnt)
+		if err := syscall.Unmount(mnt, 0); err != nil {
+			late.Err("failed to unmount %q: %v", mnt, err)
+		}
+		late.Flush()
+	}
+
+	err := s.RunOpTimeout(func() error {
+		err := syscall.Mount(dev, mnt, string(fs.Format), mountFlags(fs.MountFlags), fs.MountOptions)
+		mu.Lock()
+		defer mu.Unlock()
+		finished = err == nil
+		if finished && abandoned {
+			unmount()
+		}
+		return err
+	}, "mounting %q at %q", dev, mnt)
+	if err != nil {
+		mu.Lock()
+		defer mu.Unlock()
+		abandoned = true
+		if finished {
+			// the mount won the race against the timeout
+			unmount()
+		}
+	}
+	return err
+}
+
+// mountFlags translates the named flags into their syscall.Mount equivalents.
+func mountFlags(names config.MountFlags) uintptr {
+	flags := uintptr(0)
+	for _, name := range names {
+		switch name {
+		case "ro":
+			flags |= syscall.MS_RDONLY
+		case "nosuid":
+			flags |= syscall.MS_NOSUID
+		case "nodev":
+			flags |= syscall.MS_NODEV
+		case "noexec":
+			flags |= syscall.MS_NOEXEC
+		case "noatime":
+			flags |= syscall.MS_NOATIME
+		case "nodiratime":
+			flags |= syscall.MS_NODIRATIME
+		case "relatime":
+			flags |= syscall.MS_RELATIME
+		case "sync":
+			flags |= syscall.MS_SYNCHRONOUS
+		}
+	}
+	return flags
+}
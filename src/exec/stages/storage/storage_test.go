@@ -0,0 +1,307 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/exec/util"
+	"github.com/coreos/ignition/src/log"
+)
+
+func TestParseSignatures(t *testing.T) {
+	type in struct {
+		out string
+	}
+	type out struct {
+		sigs []string
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{out: ""},
+			out: out{sigs: []string{}},
+		},
+		{
+			in:  in{out: "# offset,uuid,label,type\n0x438,5c1b4e2a-0b8a-4f3e-9d58-0f2a8e6f1d2c,ROOT,ext4\n"},
+			out: out{sigs: []string{"ext4 at 0x438"}},
+		},
+		{
+			in:  in{out: "0x200,,,gpt\n0x1fffffe00,,,gpt\n0x1fe,,,PMBR\n"},
+			out: out{sigs: []string{"gpt at 0x200", "gpt at 0x1fffffe00", "PMBR at 0x1fe"}},
+		},
+		{
+			in:  in{out: "0x1000,a:b,label,with,commas,linux_raid_member\n"},
+			out: out{sigs: []string{"linux_raid_member at 0x1000"}},
+		},
+		{
+			in:  in{out: "garbage\n"},
+			out: out{sigs: []string{}},
+		},
+	}
+
+	for i, test := range tests {
+		if sigs := parseSignatures(test.in.out); !reflect.DeepEqual(test.out.sigs, sigs) {
+			t.Errorf("#%d: bad signatures: want %q, got %q", i, test.out.sigs, sigs)
+		}
+	}
+}
+
+func TestMemberDeviceGroups(t *testing.T) {
+	type in struct {
+		storage config.Storage
+	}
+	type out struct {
+		groups []deviceGroup
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in: in{storage: config.Storage{
+				Arrays:      []config.Raid{{Name: "data", Devices: []config.RaidDevice{"/dev/sdb", "/dev/sdc"}}},
+				Filesystems: []config.Filesystem{{Device: "/dev/md/data"}, {Device: "/dev/sdd"}},
+			}},
+			out: out{groups: []deviceGroup{
+				{ctxt: "raid members", devs: []string{"/dev/sdb", "/dev/sdc"}},
+				{ctxt: "filesystem devices", devs: []string{"/dev/sdd"}},
+			}},
+		},
+		{
+			in: in{storage: config.Storage{
+				Arrays:       []config.Raid{{Name: "/dev/md0", Devices: []config.RaidDevice{"/dev/sdb", "/dev/sdc"}}},
+				VolumeGroups: []config.VolumeGroup{{Name: "vg", Devices: []config.DevicePath{"/dev/md0"}}},
+				Filesystems:  []config.Filesystem{{Device: "/dev/md/md0"}},
+			}},
+			out: out{groups: []deviceGroup{
+				{ctxt: "raid members", devs: []string{"/dev/sdb", "/dev/sdc"}},
+			}},
+		},
+		{
+			in: in{storage: config.Storage{
+				Arrays:      []config.Raid{{Name: "md1", Devices: []config.RaidDevice{"/dev/sdb"}}},
+				Filesystems: []config.Filesystem{{Device: "/dev/md1"}},
+			}},
+			out: out{groups: []deviceGroup{
+				{ctxt: "raid members", devs: []string{"/dev/sdb"}},
+			}},
+		},
+	}
+
+	for i, test := range tests {
+		if groups := memberDeviceGroups(test.in.storage); !reflect.DeepEqual(test.out.groups, groups) {
+			t.Errorf("#%d: bad groups: want %+v, got %+v", i, test.out.groups, groups)
+		}
+	}
+}
+
+func TestMergeExtendedOptions(t *testing.T) {
+	type in struct {
+		args []string
+		opts string
+	}
+	type out struct {
+		args []string
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{args: []string{"-F"}, opts: "lazy_itable_init=0"},
+			out: out{args: []string{"-F", "-E", "lazy_itable_init=0"}},
+		},
+		{
+			in:  in{args: []string{"-E", "stride=16", "-F"}, opts: "lazy_itable_init=0"},
+			out: out{args: []string{"-E", "stride=16,lazy_itable_init=0", "-F"}},
+		},
+		{
+			in:  in{args: []string{"-Estride=16", "-F"}, opts: "lazy_itable_init=0"},
+			out: out{args: []string{"-Estride=16,lazy_itable_init=0", "-F"}},
+		},
+		{
+			in:  in{args: []string{"-E", "stride=16", "-E", "stripe_width=32"}, opts: "lazy_itable_init=0"},
+			out: out{args: []string{"-E", "stride=16", "-E", "stripe_width=32,lazy_itable_init=0"}},
+		},
+	}
+
+	for i, test := range tests {
+		orig := append([]string{}, test.in.args...)
+		args := mergeExtendedOptions(test.in.args, test.in.opts)
+		if !reflect.DeepEqual(test.out.args, args) {
+			t.Errorf("#%d: bad args: want %v, got %v", i, test.out.args, args)
+		}
+		if !reflect.DeepEqual(orig, test.in.args) {
+			t.Errorf("#%d: args modified: want %v, got %v", i, orig, test.in.args)
+		}
+	}
+}
+
+func TestHeldByArray(t *testing.T) {
+	type in struct {
+		holders []string
+		node    string
+	}
+	type out struct {
+		held bool
+		err  error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{holders: []string{}, node: ""},
+			out: out{held: false},
+		},
+		{
+			in:  in{holders: []string{}, node: "/dev/md127"},
+			out: out{held: false},
+		},
+		{
+			in:  in{holders: []string{"/dev/md127"}, node: "/dev/md127"},
+			out: out{held: true},
+		},
+		{
+			in:  in{holders: []string{"/dev/md126"}, node: "/dev/md127"},
+			out: out{err: fmt.Errorf("%q is in use by %q", "/dev/sdb1", "/dev/md126")},
+		},
+		{
+			in:  in{holders: []string{"/dev/md126"}, node: ""},
+			out: out{err: fmt.Errorf("%q is in use by %q", "/dev/sdb1", "/dev/md126")},
+		},
+		{
+			in:  in{holders: []string{"/dev/dm-0"}, node: ""},
+			out: out{err: fmt.Errorf("%q is in use by %q", "/dev/sdb1", "/dev/dm-0")},
+		},
+	}
+
+	for i, test := range tests {
+		held, err := heldByArray("/dev/sdb1", test.in.holders, test.in.node)
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+		if held != test.out.held {
+			t.Errorf("#%d: bad held: want %t, got %t", i, test.out.held, held)
+		}
+	}
+}
+
+func TestInUseReason(t *testing.T) {
+	type in struct {
+		blocks    string
+		signature string
+	}
+	type out struct {
+		reason string
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{blocks: "part\n", signature: ""},
+			out: out{reason: ""},
+		},
+		{
+			in:  in{blocks: "part\n", signature: "ext4\n"},
+			out: out{reason: ""},
+		},
+		{
+			in:  in{blocks: "part /var\n", signature: "ext4\n"},
+			out: out{reason: `mounted at "/var"`},
+		},
+		{
+			in:  in{blocks: "part\nraid1\nraid1\n", signature: "linux_raid_member\n"},
+			out: out{reason: "a member of an active RAID array"},
+		},
+		{
+			in:  in{blocks: "part\nlvm /srv\n", signature: "LVM2_member\n"},
+			out: out{reason: "backing an active LVM volume"},
+		},
+		{
+			in:  in{blocks: "part\n", signature: "LVM2_member\n"},
+			out: out{reason: "an LVM physical volume"},
+		},
+		{
+			in:  in{blocks: "disk\npart /boot\n", signature: ""},
+			out: out{reason: `mounted at "/boot"`},
+		},
+	}
+
+	for i, test := range tests {
+		reason := inUseReason(test.in.blocks, test.in.signature)
+		if reason != test.out.reason {
+			t.Errorf("#%d: bad reason: want %q, got %q", i, test.out.reason, reason)
+		}
+	}
+}
+
+func TestGrowExt4(t *testing.T) {
+	type in struct {
+		fsckStatus int
+	}
+	type out struct {
+		ok bool
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{in: in{fsckStatus: 0}, out: out{ok: true}},
+		{in: in{fsckStatus: 1}, out: out{ok: true}},
+		{in: in{fsckStatus: 2}, out: out{ok: false}},
+		{in: in{fsckStatus: 4}, out: out{ok: false}},
+	}
+
+	bin, err := ioutil.TempDir("", "ignition-bin-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(bin)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", bin)
+	if err := ioutil.WriteFile(filepath.Join(bin, "resize2fs"), []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatalf("failed to write resize2fs: %v", err)
+	}
+
+	for i, test := range tests {
+		script := fmt.Sprintf("#!/bin/sh\nexit %d\n", test.in.fsckStatus)
+		if err := ioutil.WriteFile(filepath.Join(bin, "e2fsck"), []byte(script), 0755); err != nil {
+			t.Fatalf("#%d: failed to write e2fsck: %v", i, err)
+		}
+		logger := log.NewTest()
+		s := stage{Util: util.Util{Logger: &logger}}
+		err := s.growExt4(config.Filesystem{Device: "/dev/null", Format: "ext4"})
+		if ok := err == nil; ok != test.out.ok {
+			t.Errorf("#%d: bad result: want %v, got %v (%v)", i, test.out.ok, ok, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coreos/ignition/config"
+)
+
+// commandUnitTemplate is the oneshot service running a boot command. It only
+// runs on the first boot, and stays active afterwards so it isn't rerun when
+// its target is started again.
+const commandUnitTemplate = `[Unit]
+Description=Ignition boot command %s
+ConditionFirstBoot=yes
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=%s
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// CommandUnits returns the enabled units running the boot commands cmds.
+func CommandUnits(cmds []config.BootCommand) []config.SystemdUnit {
+	units := []config.SystemdUnit{}
+	for _, cmd := range cmds {
+		args := []string{}
+		for _, arg := range cmd.Command {
+			args = append(args, execQuote(arg))
+		}
+		units = append(units, config.SystemdUnit{
+			Name:     cmd.Name,
+			Enable:   true,
+			Contents: fmt.Sprintf(commandUnitTemplate, cmd.Name, strings.Join(args, " ")),
+		})
+	}
+	return units
+}
+
+// execQuote quotes arg as a single argument of an ExecStart= line, without
+// systemd expanding any specifiers or variables within it.
+func execQuote(arg string) string {
+	return `"` + strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"%", "%%",
+		"$", "$$",
+	).Replace(arg) + `"`
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/ignition/config"
+)
+
+func TestExecQuote(t *testing.T) {
+	type in struct {
+		arg string
+	}
+	type out struct {
+		quoted string
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{arg: "/usr/bin/echo"},
+			out: out{quoted: `"/usr/bin/echo"`},
+		},
+		{
+			in:  in{arg: "hello world"},
+			out: out{quoted: `"hello world"`},
+		},
+		{
+			in:  in{arg: `say "hi"`},
+			out: out{quoted: `"say \"hi\""`},
+		},
+		{
+			in:  in{arg: `C:\temp`},
+			out: out{quoted: `"C:\\temp"`},
+		},
+		{
+			in:  in{arg: "100% of $HOME"},
+			out: out{quoted: `"100%% of $$HOME"`},
+		},
+		{
+			in:  in{arg: "two\nlines"},
+			out: out{quoted: `"two\nlines"`},
+		},
+	}
+
+	for i, test := range tests {
+		if quoted := execQuote(test.in.arg); test.out.quoted != quoted {
+			t.Errorf("#%d: bad quoting: want %s, got %s", i, test.out.quoted, quoted)
+		}
+	}
+}
+
+func TestCommandUnits(t *testing.T) {
+	units := CommandUnits([]config.BootCommand{
+		{Name: "hello.service", Command: []string{"/bin/echo", "hello world"}},
+	})
+	if len(units) != 1 {
+		t.Fatalf("bad unit count: want 1, got %d", len(units))
+	}
+
+	unit := units[0]
+	if want := (config.SystemdUnit{Name: "hello.service", Enable: true}); !reflect.DeepEqual(want, config.SystemdUnit{Name: unit.Name, Enable: unit.Enable}) {
+		t.Errorf("bad unit: want %+v, got %+v", want, unit)
+	}
+	for _, line := range []string{
+		"ConditionFirstBoot=yes",
+		"Type=oneshot",
+		`ExecStart="/bin/echo" "hello world"`,
+		"WantedBy=multi-user.target",
+	} {
+		if !strings.Contains(unit.Contents, line+"\n") {
+			t.Errorf("unit contents missing %q:\n%s", line, unit.Contents)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/coreos/ignition/config"
+)
+
+// ConditionMet reports whether every predicate of c holds on this host. A nil
+// condition always holds. Devices are looked up on the host rather than
+// beneath DestDir, as they are when partitioning.
+func (u Util) ConditionMet(c *config.Condition) (bool, error) {
+	if c == nil {
+		return true, nil
+	}
+	if c.DeviceExists != "" {
+		if ok, err := deviceExists(c.DeviceExists); err != nil || !ok {
+			return false, err
+		}
+	}
+	if c.LabelExists != "" {
+		if ok, err := deviceExists(config.DevicePath("LABEL=" + c.LabelExists)); err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// deviceExists reports whether dev, a path or a LABEL= or UUID= reference,
+// names an existing device.
+func deviceExists(dev config.DevicePath) (bool, error) {
+	if _, _, ok := dev.Tag(); ok {
+		devs, err := findDevices(dev)
+		if err != nil {
+			return false, fmt.Errorf("failed to look up %q: %v", dev, err)
+		}
+		return len(devs) != 0, nil
+	}
+	if _, err := os.Stat(string(dev)); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/ignition/config"
+)
+
+func TestConditionMet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ignition-condition")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	present := filepath.Join(dir, "present")
+	if err := ioutil.WriteFile(present, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	type in struct {
+		condition *config.Condition
+	}
+	type out struct {
+		met bool
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{condition: nil},
+			out: out{met: true},
+		},
+		{
+			in:  in{condition: &config.Condition{DeviceExists: config.DevicePath(present)}},
+			out: out{met: true},
+		},
+		{
+			in:  in{condition: &config.Condition{DeviceExists: config.DevicePath(filepath.Join(dir, "missing"))}},
+			out: out{met: false},
+		},
+	}
+
+	for i, test := range tests {
+		met, err := Util{}.ConditionMet(test.in.condition)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+		if met != test.out.met {
+			t.Errorf("#%d: bad result: want %t, got %t", i, test.out.met, met)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/ignition/config"
+)
+
+// DeviceLink returns the path under which udev makes dev available: dev
+// itself, or the /dev/disk/by-label or /dev/disk/by-uuid link of a device
+// referenced by label or uuid.
+func DeviceLink(dev config.DevicePath) string {
+	name, value, ok := dev.Tag()
+	if !ok {
+		return string(dev)
+	}
+	return "/dev/disk/by-" + strings.ToLower(name) + "/" + udevEscape(value)
+}
+
+// udevEscape escapes s as udev does when naming links after labels, leaving
+// bytes beyond ASCII to pass through as UTF-8.
+func udevEscape(s string) string {
+	escaped := ""
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= 0x80:
+			escaped += string(c)
+		case strings.IndexByte("#+-.:=@_", c) >= 0:
+			escaped += string(c)
+		default:
+			escaped += fmt.Sprintf(`\x%02x`, c)
+		}
+	}
+	return escaped
+}
+
+// ResolveDevice returns the device node dev refers to. Devices referenced by
+// label or uuid are looked up with blkid, which must find exactly one.
+func (u Util) ResolveDevice(dev config.DevicePath) (config.DevicePath, error) {
+	name, value, ok := dev.Tag()
+	if !ok {
+		return dev, nil
+	}
+	what := fmt.Sprintf("%s %q", strings.ToLower(name), value)
+
+	devs, err := findDevices(dev)
+	if err != nil {
+		return "", fmt.Errorf("failed to find device with %s: %v", what, err)
+	}
+	switch len(devs) {
+	case 0:
+		return "", fmt.Errorf("no device found with %s", what)
+	case 1:
+		return config.DevicePath(devs[0]), nil
+	default:
+		return "", fmt.Errorf("more than one device found with %s: %s", what, strings.Join(devs, ", "))
+	}
+}
+
+// findDevices returns the device nodes matching the LABEL= or UUID= tag of
+// dev, which are none if blkid finds no match.
+func findDevices(dev config.DevicePath) ([]string, error) {
+	// bypass the blkid cache, the devices may have been formatted moments ago
+	out, err := exec.Command("/sbin/blkid", "-c", "/dev/null", "-o", "device", "-t", string(dev)).Output()
+	if err != nil {
+		// blkid exits with 2 when no device matches
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.Sys().(syscall.WaitStatus).ExitStatus() == 2 {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+
+	"github.com/coreos/ignition/config"
+)
+
+func TestDeviceLink(t *testing.T) {
+	type in struct {
+		dev config.DevicePath
+	}
+	type out struct {
+		link string
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{dev: "/dev/sda1"},
+			out: out{link: "/dev/sda1"},
+		},
+		{
+			in:  in{dev: "LABEL=ROOT"},
+			out: out{link: "/dev/disk/by-label/ROOT"},
+		},
+		{
+			in:  in{dev: "LABEL=my data/2"},
+			out: out{link: `/dev/disk/by-label/my\x20data\x2f2`},
+		},
+		{
+			in:  in{dev: "UUID=0f9b2c1e-8e54-4b8c-9d2f-3e1c0a7b6d5e"},
+			out: out{link: "/dev/disk/by-uuid/0f9b2c1e-8e54-4b8c-9d2f-3e1c0a7b6d5e"},
+		},
+	}
+
+	for i, test := range tests {
+		if link := DeviceLink(test.in.dev); link != test.out.link {
+			t.Errorf("#%d: bad link: want %q, got %q", i, test.out.link, link)
+		}
+	}
+}
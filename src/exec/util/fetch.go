@@ -0,0 +1,151 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/coreos/ignition/config"
+	putil "github.com/coreos/ignition/src/providers/util"
+)
+
+// FetchFile fetches the contents of f from f.Source, replacing f.Contents.
+// If f.Verification specifies a hash, the fetched contents must match it.
+func (u Util) FetchFile(f *config.File) error {
+	if u.DryRun {
+		u.Logger.Info("[dryrun]   fetch %q", f.Source)
+		return nil
+	}
+
+	data, err := u.FetchVerified(f.Source, f.Verification.Hash)
+	if err != nil {
+		return err
+	}
+	f.Contents = string(data)
+	return nil
+}
+
+// FetchVerified fetches the contents at url via FetchURL. If h is set, the
+// contents must match it.
+func (u Util) FetchVerified(url string, h config.FileHash) ([]byte, error) {
+	data, err := u.FetchURL(url)
+	if err != nil {
+		return nil, err
+	}
+	if h != "" {
+		if err := verify(data, h); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// verify checks that the hash of data matches h.
+func verify(data []byte, h config.FileHash) error {
+	function, sum, err := h.Parse()
+	if err != nil {
+		return err
+	}
+
+	var hasher hash.Hash
+	switch function {
+	case "sha512":
+		hasher = sha512.New()
+	default:
+		return fmt.Errorf("unsupported hash function %q", function)
+	}
+
+	hasher.Write(data)
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != sum {
+		return fmt.Errorf("%s hash mismatch: expected %s, got %s", function, sum, actual)
+	}
+	return nil
+}
+
+// FetchURL fetches the contents at url. Connection errors and server errors
+// are retried with exponential backoff, up to u.FetchAttempts attempts, so
+// long as u.FetchTimeout has not elapsed. Any other HTTP status besides 200
+// is treated as a permanent error.
+func (u Util) FetchURL(url string) ([]byte, error) {
+	var deadline time.Time
+	if u.FetchTimeout > 0 {
+		deadline = time.Now().Add(u.FetchTimeout)
+	}
+	backoff := u.FetchBackoff
+
+	for attempt := 1; ; attempt++ {
+		var data []byte
+		retry := false
+		err := u.Logger.LogOp(func() error {
+			var err error
+			data, retry, err = fetchURL(fetchClient, url, deadline)
+			return err
+		}, "GET %q: attempt #%d", url, attempt)
+		if err == nil {
+			return data, nil
+		}
+		if !retry || attempt >= u.FetchAttempts {
+			return nil, err
+		}
+
+		delay := putil.ExpBackoff(&backoff, u.FetchMaxBackoff)
+		if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+			return nil, fmt.Errorf("timed out fetching %q: %v", url, err)
+		}
+		time.Sleep(delay)
+	}
+}
+
+// fetchClient bounds connecting to a server and waiting for its response to
+// begin, so that a server which never answers fails the attempt even with no
+// fetch deadline. The body itself may take as long as the deadline allows.
+var fetchClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+// fetchURL makes a single attempt with base at fetching the contents at url
+// before deadline, if one is set. Whether a failed attempt is worth retrying
+// is also returned.
+func fetchURL(base *http.Client, url string, deadline time.Time) ([]byte, bool, error) {
+	client := *base
+	if !deadline.IsZero() {
+		client.Timeout = deadline.Sub(time.Now())
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode >= 500, fmt.Errorf("failed fetching %q: HTTP status: %s", url, resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	return data, true, err
+}
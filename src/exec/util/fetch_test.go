@@ -0,0 +1,97 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/ignition/src/log"
+)
+
+func TestFetchURL(t *testing.T) {
+	failures := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hello":
+			fmt.Fprint(w, "hello")
+		case "/flaky":
+			if failures++; failures < 2 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, "recovered")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	type in struct {
+		path     string
+		attempts int
+	}
+	type out struct {
+		data string
+		ok   bool
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{in: in{path: "/hello", attempts: 1}, out: out{data: "hello", ok: true}},
+		{in: in{path: "/missing", attempts: 3}, out: out{ok: false}},
+		{in: in{path: "/flaky", attempts: 3}, out: out{data: "recovered", ok: true}},
+	}
+
+	for i, test := range tests {
+		logger := log.NewTest()
+		u := Util{FetchAttempts: test.in.attempts, FetchBackoff: time.Millisecond, FetchMaxBackoff: time.Millisecond, Logger: &logger}
+		data, err := u.FetchURL(server.URL + test.in.path)
+		if got := (out{data: string(data), ok: err == nil}); !reflect.DeepEqual(test.out, got) {
+			t.Errorf("#%d: bad fetch: want %+v, got %+v (%v)", i, test.out, got, err)
+		}
+	}
+}
+
+func TestFetchURLHanging(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	defer close(block)
+
+	// with no fetch deadline, the client's own timeouts end the attempt
+	client := &http.Client{Transport: &http.Transport{ResponseHeaderTimeout: 100 * time.Millisecond}}
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := fetchURL(client, server.URL, time.Time{})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("bad result: want an error, got none")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("request to a hanging server never timed out")
+	}
+}
@@ -15,9 +15,18 @@
 package util
 
 import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
+	"text/template"
+	"time"
 
 	"github.com/coreos/ignition/config"
 )
@@ -27,49 +36,513 @@ const (
 	DefaultFilePermissions      config.FileMode = 0644
 )
 
-// WriteFile creates and writes the file described by f using the provided context
+// WriteContents writes the directories, files and links listed in
+// fs.Directories, fs.Files and fs.Links beneath u.DestDir, where fs is
+// expected to be mounted. The paths in fs.Remove are removed before anything
+// is written, so they may be replaced. Directories are written first so files
+// may be placed within them, and links last so they may refer to the files.
+// Files with a source are fetched first.
+func (u Util) WriteContents(fs config.Filesystem) error {
+	// paths are reported along with the device, since DestDir is often a
+	// temporary mount
+	target := func(path string) string {
+		return fmt.Sprintf("%s:%s", fs.Device, path)
+	}
+
+	for _, r := range fs.Remove {
+		err := u.Logger.LogOp(
+			func() error { return u.RemovePath(&r) },
+			"removing %q", r.Path,
+		)
+		u.Report.Add("remove path", target(r.Path), err)
+		if err != nil {
+			return fmt.Errorf("failed to remove %q: %v", r.Path, err)
+		}
+	}
+
+	for _, d := range fs.Directories {
+		err := u.Logger.LogOp(
+			func() error { return u.WriteDirectory(&d) },
+			"writing directory %q", d.Path,
+		)
+		u.Report.Add("write directory", target(d.Path), err)
+		if err != nil {
+			return fmt.Errorf("failed to create directory %q: %v", d.Path, err)
+		}
+	}
+
+	for _, f := range fs.Files {
+		if ok, err := u.ConditionMet(f.Condition); err != nil {
+			u.Report.Add("write file", target(f.Path), err)
+			return fmt.Errorf("failed to check the condition of file %q: %v", f.Path, err)
+		} else if !ok {
+			u.Logger.Info("skipping file %q: its condition isn't met", f.Path)
+			u.Report.Skip("write file", target(f.Path))
+			continue
+		}
+
+		if f.Source != "" {
+			if err := u.Logger.LogOp(
+				func() error { return u.FetchFile(&f) },
+				"fetching %q for file %q", f.Source, f.Path,
+			); err != nil {
+				u.Report.Add("write file", target(f.Path), err)
+				return fmt.Errorf("failed to fetch file %q: %v", f.Path, err)
+			}
+		}
+
+		err := u.Logger.LogOp(
+			func() error { return u.WriteFile(&f) },
+			"writing file %q", string(f.Path),
+		)
+		u.Report.Add("write file", target(f.Path), err)
+		if err != nil {
+			return fmt.Errorf("failed to create file %q: %v", f.Path, err)
+		}
+	}
+
+	for _, l := range fs.Links {
+		err := u.Logger.LogOp(
+			func() error { return u.WriteLink(&l) },
+			"writing link %q -> %q", l.Path, l.Target,
+		)
+		u.Report.Add("write link", target(l.Path), err)
+		if err != nil {
+			return fmt.Errorf("failed to create link %q: %v", l.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// TargetFiles returns fss with each file naming another filesystem in its
+// Filesystem moved among the files of that filesystem, so that it's written
+// wherever that filesystem is mounted. The files keep their order.
+func TargetFiles(fss []config.Filesystem) []config.Filesystem {
+	named := map[string]int{}
+	for i, fs := range fss {
+		if fs.Name != "" {
+			named[fs.Name] = i
+		}
+	}
+
+	targeted := make([]config.Filesystem, len(fss))
+	copy(targeted, fss)
+	for i := range targeted {
+		targeted[i].Files = nil
+	}
+	for i, fs := range fss {
+		for _, f := range fs.Files {
+			j := i
+			if k, ok := named[f.Filesystem]; ok {
+				j = k
+			}
+			targeted[j].Files = append(targeted[j].Files, f)
+		}
+	}
+	return targeted
+}
+
+// WriteFile creates and writes the file described by f using the provided context.
+// If f.Append is set the contents are appended to any existing file instead.
+// Inline contents are decoded according to f.Encoding before being written. If
+// f.Size is set the file is instead truncated to that size, leaving it sparse.
+// If f.Template is set the decoded contents are rendered against u.Metadata.
+// Existing files are replaced according to f.Overwrite. If f.PermissionsOnly
+// is set, the existing file is left as is besides its permissions. If f.Mtime
+// is set, the file's access and modification times are set to it, including
+// when the existing file is kept.
 func (u Util) WriteFile(f *config.File) error {
 	var err error
 
 	path := u.JoinPath(f.Path)
 
+	if f.PermissionsOnly {
+		return u.setPermissions(path, f)
+	}
+
+	if u.DryRun {
+		u.Logger.Info("[dryrun]   write %q: %d bytes, mode %#o, uid %s, gid %s, append %t", path, len(f.Contents), f.Mode, idString(f.Uid), idString(f.Gid), f.Append)
+		return nil
+	}
+
+	contents, err := decodeContents(f)
+	if err != nil {
+		return err
+	}
+	if f.Template {
+		if contents, err = u.renderTemplate(f.Path, contents); err != nil {
+			return err
+		}
+	}
+
+	if skip, err := u.skipWrite(path, f.Overwrite, contents); err != nil {
+		return err
+	} else if skip {
+		return setMtime(path, f.Mtime)
+	}
+
+	uid, gid, err := u.resolveOwner(f)
+	if err != nil {
+		return err
+	}
+
 	if err := mkdirForFile(path); err != nil {
 		return err
 	}
 
-	// Create a temporary file in the same directory to ensure it's on the same filesystem
-	var tmp *os.File
-	if tmp, err = ioutil.TempFile(filepath.Dir(path), "tmp"); err != nil {
+	if f.Append {
+		if err := appendFile(path, contents, f.Mode, uid, gid); err != nil {
+			return err
+		}
+		if err := u.setContext(path, f.SELinuxContext); err != nil {
+			return err
+		}
+		return setMtime(path, f.Mtime)
+	}
+
+	// Create a temporary file in the same directory to ensure it's on the same
+	// filesystem, and rename it into place so readers never see a partial file
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "tmp")
+	if err != nil {
 		return err
 	}
-	tmp.Close()
+	renamed := false
 	defer func() {
-		if err != nil {
+		if !renamed {
 			os.Remove(tmp.Name())
 		}
 	}()
 
-	if err := ioutil.WriteFile(tmp.Name(), []byte(f.Contents), os.FileMode(f.Mode)); err != nil {
+	if err := u.writeTemp(tmp, f, contents, uid, gid); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
 		return err
 	}
+	renamed = true
 
-	// XXX(vc): Note that we assume to be operating on the file we just wrote, this is only guaranteed
-	// by using syscall.Fchown() and syscall.Fchmod()
+	return setMtime(path, f.Mtime)
+}
 
-	// Ensure the ownership and mode are as requested (since WriteFile can be affected by sticky bit)
-	if err := os.Chown(tmp.Name(), f.Uid, f.Gid); err != nil {
+// writeTemp writes contents to the freshly created tmp, applies the size,
+// ownership, mode and SELinux context of f to it, and closes it.
+func (u Util) writeTemp(tmp *os.File, f *config.File, contents []byte, uid, gid int) error {
+	err := func() error {
+		if _, err := tmp.Write(contents); err != nil {
+			return err
+		}
+		if f.Size != 0 {
+			if err := tmp.Truncate(f.Size); err != nil {
+				return err
+			}
+		}
+		// the open file is changed rather than its path, which is only
+		// guaranteed to name the file just written until it's closed.
+		// ownership goes first, since chown clears setuid and setgid bits
+		if err := tmp.Chown(uid, gid); err != nil {
+			return err
+		}
+		if err := tmp.Chmod(os.FileMode(f.Mode)); err != nil {
+			return err
+		}
+		// flush the contents, lest a crash after the rename truncate the file
+		return tmp.Sync()
+	}()
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
 		return err
 	}
 
-	if err := os.Chmod(tmp.Name(), os.FileMode(f.Mode)); err != nil {
+	// Label the file before it's moved into place, rename preserves the label
+	return u.setContext(tmp.Name(), f.SELinuxContext)
+}
+
+// idString formats the optional id for the logs.
+func idString(id *int) string {
+	if id == nil {
+		return "unset"
+	}
+	return strconv.Itoa(*id)
+}
+
+// setMtime sets the access and modification times of path to mtime, in
+// seconds since the epoch. A nil mtime leaves them be.
+func setMtime(path string, mtime *int64) error {
+	if mtime == nil {
+		return nil
+	}
+	t := time.Unix(*mtime, 0)
+	return os.Chtimes(path, t, t)
+}
+
+// setPermissions applies the mode, ownership, SELinux context and mtime of f
+// to the existing file at path without touching its contents. A zero mode, or
+// a uid or gid given neither by number nor by name, is left unchanged. A link
+// is changed itself rather than its target, which may lie outside the context,
+// so neither a mode nor an mtime, which would only reach the target, can be
+// applied to one.
+func (u Util) setPermissions(path string, f *config.File) error {
+	if u.DryRun {
+		u.Logger.Info("[dryrun]   set permissions on %q: mode %#o, uid %s, gid %s", path, f.Mode, idString(f.Uid), idString(f.Gid))
+		return nil
+	}
+
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("cannot set permissions on %q: no such file", f.Path)
+	} else if err != nil {
 		return err
 	}
+	link := info.Mode()&os.ModeSymlink != 0
+	if link && (f.Mode != 0 || f.Mtime != nil) {
+		return fmt.Errorf("cannot set the mode or mtime of %q: it is a link", f.Path)
+	}
 
-	if err := os.Rename(tmp.Name(), path); err != nil {
+	uid, gid, err := u.resolveOwner(f)
+	if err != nil {
 		return err
 	}
+	// -1 leaves the id unchanged
+	if f.Uid == nil && f.User == "" {
+		uid = -1
+	}
+	if f.Gid == nil && f.Group == "" {
+		gid = -1
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+	}
 
-	return nil
+	if f.Mode != 0 {
+		if err := os.Chmod(path, os.FileMode(f.Mode)); err != nil {
+			return err
+		}
+	}
+
+	if link {
+		return u.setLinkContext(path, f.SELinuxContext)
+	}
+	if err := u.setContext(path, f.SELinuxContext); err != nil {
+		return err
+	}
+	return setMtime(path, f.Mtime)
+}
+
+// WriteDirectory creates the directory described by d, along with any missing
+// parents, and applies the requested mode and ownership to it. An unset mode
+// defaults to DefaultDirectoryPermissions.
+func (u Util) WriteDirectory(d *config.Directory) error {
+	path := u.JoinPath(d.Path)
+	mode := d.Mode
+	if mode == 0 {
+		mode = DefaultDirectoryPermissions
+	}
+
+	if u.DryRun {
+		u.Logger.Info("[dryrun]   mkdir %q: mode %#o, uid %d, gid %d", path, mode, d.Uid, d.Gid)
+		return nil
+	}
+
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		return fmt.Errorf("%q exists and is not a directory", d.Path)
+	}
+
+	if err := os.MkdirAll(path, os.FileMode(DefaultDirectoryPermissions)); err != nil {
+		return err
+	}
+
+	if err := os.Chown(path, d.Uid, d.Gid); err != nil {
+		return err
+	}
+
+	return os.Chmod(path, os.FileMode(mode))
+}
+
+// WriteLink creates the symbolic or hard link described by l. Existing paths
+// are only replaced if l.Overwrite is set. Hard link targets are resolved
+// within the context, symbolic link targets are written verbatim.
+func (u Util) WriteLink(l *config.Link) error {
+	path := u.JoinPath(l.Path)
+
+	if u.DryRun {
+		u.Logger.Info("[dryrun]   link %q -> %q: hard %t, overwrite %t", path, l.Target, l.Hard, l.Overwrite)
+		return nil
+	}
+
+	if err := mkdirForFile(path); err != nil {
+		return err
+	}
+
+	if _, err := os.Lstat(path); err == nil {
+		if !l.Overwrite {
+			return fmt.Errorf("%q already exists", l.Path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	if l.Hard {
+		return os.Link(u.JoinPath(l.Target), path)
+	}
+	return os.Symlink(l.Target, path)
+}
+
+// RemovePath removes the path described by r. A missing path is an error
+// unless r.IgnoreMissing is set, and directories are only removed along with
+// their contents if r.Recursive is set.
+func (u Util) RemovePath(r *config.Removal) error {
+	path := u.JoinPath(r.Path)
+
+	if u.DryRun {
+		u.Logger.Info("[dryrun]   remove %q: recursive %t", path, r.Recursive)
+		return nil
+	}
+
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		if r.IgnoreMissing {
+			return nil
+		}
+		return fmt.Errorf("%q doesn't exist", r.Path)
+	} else if err != nil {
+		return err
+	}
+
+	if info.IsDir() && r.Recursive {
+		return os.RemoveAll(path)
+	}
+	return os.Remove(path)
+}
+
+// skipWrite reports whether writing contents to the existing file at path
+// should be skipped under the overwrite policy.
+func (u Util) skipWrite(path string, overwrite config.FileOverwrite, contents []byte) (bool, error) {
+	switch overwrite {
+	case "", config.OverwriteAlways:
+		return false, nil
+	case config.OverwriteNever:
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		u.Logger.Info("skipping %q: file exists and overwrite is %q", path, overwrite)
+		return true, nil
+	case config.OverwriteIfDifferent:
+		existing, err := os.Open(path)
+		if os.IsNotExist(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		defer existing.Close()
+
+		hasher := sha512.New()
+		if _, err := io.Copy(hasher, existing); err != nil {
+			return false, err
+		}
+		if sum := sha512.Sum512(contents); bytes.Equal(hasher.Sum(nil), sum[:]) {
+			u.Logger.Info("skipping %q: contents unchanged", path)
+			return true, nil
+		}
+		u.Logger.Info("replacing %q: contents differ", path)
+		return false, nil
+	default:
+		return false, fmt.Errorf("unsupported overwrite policy %q", overwrite)
+	}
+}
+
+// appendFile appends contents to the file at path, creating it if necessary.
+// The requested ownership and mode are only applied when the file is created.
+func appendFile(path string, contents []byte, mode config.FileMode, uid, gid int) error {
+	_, err := os.Lstat(path)
+	created := os.IsNotExist(err)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, os.FileMode(mode))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(contents); err != nil {
+		return err
+	}
+
+	if !created {
+		return nil
+	}
+
+	// Ensure the ownership and mode are as requested (since OpenFile can be affected by umask)
+	if err := file.Chown(uid, gid); err != nil {
+		return err
+	}
+	return file.Chmod(os.FileMode(mode))
+}
+
+// decodeContents returns the raw contents of f, decoded according to f.Encoding.
+// Contents fetched from f.Source are written as they were fetched, so only
+// inline contents are decoded.
+func decodeContents(f *config.File) ([]byte, error) {
+	if f.Source != "" {
+		return []byte(f.Contents), nil
+	}
+	switch f.Encoding {
+	case "":
+		return []byte(f.Contents), nil
+	case "base64":
+		contents, err := base64.StdEncoding.DecodeString(f.Contents)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode base64 contents of %q: %v", f.Path, err)
+		}
+		return contents, nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q for %q", f.Encoding, f.Path)
+	}
+}
+
+// renderTemplate renders contents as a text/template against u.Metadata.
+// Referencing metadata the provider didn't supply is an error rather than
+// rendering as empty.
+func (u Util) renderTemplate(path string, contents []byte) ([]byte, error) {
+	tmpl, err := template.New(path).Option("missingkey=error").Parse(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %q: %v", path, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, u.Metadata); err != nil {
+		return nil, fmt.Errorf("failed to render template %q: %v", path, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// setContext sets the SELinux security context of path to context.
+// No relabeling is performed if context is empty.
+func (u Util) setContext(path, context string) error {
+	if context == "" {
+		return nil
+	}
+	return u.Logger.LogCmd(
+		exec.Command("/usr/bin/chcon", context, path),
+		"setting SELinux context %q on %q", context, path,
+	)
+}
+
+// setLinkContext sets the SELinux context of the link at path itself, if a
+// context is given.
+func (u Util) setLinkContext(path, context string) error {
+	if context == "" {
+		return nil
+	}
+	return u.Logger.LogCmd(
+		exec.Command("/usr/bin/chcon", "-h", context, path),
+		"setting SELinux context %q on link %q", context, path,
+	)
 }
 
 // mkdirForFile helper creates the directory components of path
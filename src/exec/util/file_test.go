@@ -0,0 +1,513 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/log"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	type in struct {
+		metadata map[string]string
+		contents string
+	}
+	type out struct {
+		contents string
+		ok       bool
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{metadata: map[string]string{"hostname": "node1"}, contents: "name={{.hostname}}\n"},
+			out: out{contents: "name=node1\n", ok: true},
+		},
+		{
+			in:  in{metadata: map[string]string{"hostname": "node1"}, contents: "no templating here"},
+			out: out{contents: "no templating here", ok: true},
+		},
+		{
+			in:  in{metadata: map[string]string{"hostname": "node1"}, contents: "{{.region}}"},
+			out: out{ok: false},
+		},
+		{
+			in:  in{metadata: nil, contents: "{{.hostname}}"},
+			out: out{ok: false},
+		},
+		{
+			in:  in{metadata: nil, contents: "{{.hostname"},
+			out: out{ok: false},
+		},
+	}
+
+	for i, test := range tests {
+		contents, err := Util{Metadata: test.in.metadata}.renderTemplate("/etc/hostname", []byte(test.in.contents))
+		if got := (out{contents: string(contents), ok: err == nil}); !reflect.DeepEqual(test.out, got) {
+			t.Errorf("#%d: bad result: want %+v, got %+v (err %v)", i, test.out, got, err)
+		}
+	}
+}
+
+func TestDecodeContents(t *testing.T) {
+	type in struct {
+		file config.File
+	}
+	type out struct {
+		contents string
+		ok       bool
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{file: config.File{Contents: "hello"}},
+			out: out{contents: "hello", ok: true},
+		},
+		{
+			in:  in{file: config.File{Contents: "aGVsbG8=", Encoding: "base64"}},
+			out: out{contents: "hello", ok: true},
+		},
+		{
+			in:  in{file: config.File{Contents: "hello", Encoding: "base64"}},
+			out: out{ok: false},
+		},
+		{
+			// fetched contents are left as they are
+			in:  in{file: config.File{Contents: "aGVsbG8=", Encoding: "base64", Source: "http://example.com/hello"}},
+			out: out{contents: "aGVsbG8=", ok: true},
+		},
+	}
+
+	for i, test := range tests {
+		contents, err := decodeContents(&test.in.file)
+		if got := (out{contents: string(contents), ok: err == nil}); !reflect.DeepEqual(test.out, got) {
+			t.Errorf("#%d: bad result: want %+v, got %+v", i, test.out, got)
+		}
+	}
+}
+
+func TestTargetFiles(t *testing.T) {
+	type in struct {
+		fss []config.Filesystem
+	}
+	type out struct {
+		fss []config.Filesystem
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in: in{fss: []config.Filesystem{
+				{Name: "root", Files: []config.File{{Path: "/a"}, {Path: "/b", Filesystem: "var"}, {Path: "/c", Filesystem: "root"}}},
+				{Name: "var", Files: []config.File{{Path: "/d"}}},
+				{Files: []config.File{{Path: "/e", Filesystem: "root"}}},
+			}},
+			out: out{fss: []config.Filesystem{
+				{Name: "root", Files: []config.File{{Path: "/a"}, {Path: "/c", Filesystem: "root"}, {Path: "/e", Filesystem: "root"}}},
+				{Name: "var", Files: []config.File{{Path: "/b", Filesystem: "var"}, {Path: "/d"}}},
+				{},
+			}},
+		},
+	}
+
+	for i, test := range tests {
+		fss := TargetFiles(test.in.fss)
+		if !reflect.DeepEqual(test.out.fss, fss) {
+			t.Errorf("#%d: bad filesystems: want %+v, got %+v", i, test.out.fss, fss)
+		}
+	}
+}
+
+func TestWriteFileMtime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ignition-util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	type in struct {
+		overwrite config.FileOverwrite
+	}
+	type out struct {
+		mtime int64
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{overwrite: config.OverwriteAlways},
+			out: out{mtime: 1234567890},
+		},
+		{
+			// the second write is skipped, the mtime must still apply
+			in:  in{overwrite: config.OverwriteIfDifferent},
+			out: out{mtime: 1234567890},
+		},
+	}
+
+	uid, gid := os.Getuid(), os.Getgid()
+	logger := log.NewTest()
+	u := Util{DestDir: dir, Logger: &logger}
+	for i, test := range tests {
+		path := fmt.Sprintf("/file%d", i)
+		f := config.File{Path: path, Contents: "hello", Mode: 0644, Uid: &uid, Gid: &gid, Overwrite: test.in.overwrite}
+		if err := u.WriteFile(&f); err != nil {
+			t.Fatalf("#%d: failed to write file: %v", i, err)
+		}
+		f.Mtime = &test.out.mtime
+		if err := u.WriteFile(&f); err != nil {
+			t.Fatalf("#%d: failed to rewrite file: %v", i, err)
+		}
+
+		info, err := os.Stat(u.JoinPath(path))
+		if err != nil {
+			t.Fatalf("#%d: failed to stat file: %v", i, err)
+		}
+		if got := info.ModTime().Unix(); got != test.out.mtime {
+			t.Errorf("#%d: bad mtime: want %d, got %d", i, test.out.mtime, got)
+		}
+	}
+}
+
+func TestWriteFileCleansUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ignition-util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	uid, gid := os.Getuid(), os.Getgid()
+	logger := log.NewTest()
+	u := Util{DestDir: dir, Logger: &logger}
+	f := config.File{Path: "/etc/file", Contents: "hello", Mode: 0644, Uid: &uid, Gid: &gid}
+	if err := u.WriteFile(&f); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	// a non-empty directory can't be renamed over, failing the write
+	f.Path = "/etc/dir"
+	if err := os.MkdirAll(u.JoinPath(f.Path, "child"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.WriteFile(&f); err == nil {
+		t.Fatalf("wrote file over a directory")
+	}
+
+	infos, err := ioutil.ReadDir(u.JoinPath("/etc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	if expected := []string{"dir", "file"}; !reflect.DeepEqual(expected, names) {
+		t.Errorf("temporary file left behind: want %v, got %v", expected, names)
+	}
+}
+
+func TestSetPermissions(t *testing.T) {
+	type in struct {
+		link bool
+		mode config.FileMode
+	}
+	type out struct {
+		mode os.FileMode
+		ok   bool
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{link: false, mode: 0640},
+			out: out{mode: 0640, ok: true},
+		},
+		{
+			in:  in{link: false, mode: 0},
+			out: out{mode: 0600, ok: true},
+		},
+		{
+			in:  in{link: true, mode: 0},
+			out: out{mode: 0600, ok: true},
+		},
+		{
+			in:  in{link: true, mode: 0644},
+			out: out{mode: 0600, ok: false},
+		},
+	}
+
+	for i, test := range tests {
+		dir, err := ioutil.TempDir("", "ignition-util")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		dest := filepath.Join(dir, "dest")
+		// a link may point outside the context, which must be left alone
+		target := filepath.Join(dir, "outside")
+		if err := ioutil.WriteFile(target, []byte("hello"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(dest, "etc"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dest, "etc", "file")
+		if test.in.link {
+			err = os.Symlink(target, path)
+		} else {
+			err = os.Rename(target, path)
+			target = path
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		uid := os.Getuid()
+		logger := log.NewTest()
+		u := Util{DestDir: dest, Logger: &logger}
+		f := config.File{Path: "/etc/file", Mode: test.in.mode, Uid: &uid, PermissionsOnly: true}
+		err = u.WriteFile(&f)
+		if ok := err == nil; ok != test.out.ok {
+			t.Errorf("#%d: bad result: want %t, got %v", i, test.out.ok, err)
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != test.out.mode {
+			t.Errorf("#%d: bad mode: want %#o, got %#o", i, test.out.mode, mode)
+		}
+	}
+}
+
+func TestWriteDirectory(t *testing.T) {
+	type in struct {
+		mode config.FileMode
+	}
+	type out struct {
+		mode os.FileMode
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{mode: 0},
+			out: out{mode: os.FileMode(DefaultDirectoryPermissions)},
+		},
+		{
+			in:  in{mode: 0700},
+			out: out{mode: 0700},
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "ignition-util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := log.NewTest()
+	u := Util{DestDir: dir, Logger: &logger}
+	for i, test := range tests {
+		d := config.Directory{Path: fmt.Sprintf("/dir%d", i), Mode: test.in.mode, Uid: os.Getuid(), Gid: os.Getgid()}
+		if err := u.WriteDirectory(&d); err != nil {
+			t.Fatalf("#%d: failed to write directory: %v", i, err)
+		}
+		info, err := os.Stat(u.JoinPath(d.Path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != test.out.mode {
+			t.Errorf("#%d: bad mode: want %#o, got %#o", i, test.out.mode, mode)
+		}
+	}
+}
+
+func TestWriteLink(t *testing.T) {
+	type in struct {
+		link     config.Link
+		existing bool
+	}
+	type out struct {
+		ok     bool
+		target string // the symbolic link's target, or the hard link's contents
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{link: config.Link{Path: "/etc/abs", Target: "/etc/target"}},
+			out: out{ok: true, target: "/etc/target"},
+		},
+		{
+			in:  in{link: config.Link{Path: "/etc/rel", Target: "../usr/target"}},
+			out: out{ok: true, target: "../usr/target"},
+		},
+		{
+			in:  in{link: config.Link{Path: "/etc/sub/nested", Target: "/etc/target"}},
+			out: out{ok: true, target: "/etc/target"},
+		},
+		{
+			in:  in{link: config.Link{Path: "/etc/hard", Target: "/etc/target", Hard: true}},
+			out: out{ok: true, target: "target"},
+		},
+		{
+			in:  in{link: config.Link{Path: "/etc/kept", Target: "/etc/target"}, existing: true},
+			out: out{ok: false, target: "existing"},
+		},
+		{
+			in:  in{link: config.Link{Path: "/etc/replaced", Target: "/etc/target", Overwrite: true}, existing: true},
+			out: out{ok: true, target: "/etc/target"},
+		},
+		{
+			in:  in{link: config.Link{Path: "/etc/hard-replaced", Target: "/etc/target", Hard: true, Overwrite: true}, existing: true},
+			out: out{ok: true, target: "target"},
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "ignition-util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "etc", "target"), []byte("target"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.NewTest()
+	u := Util{DestDir: dir, Logger: &logger}
+	for i, test := range tests {
+		path := u.JoinPath(test.in.link.Path)
+		if test.in.existing {
+			if err := ioutil.WriteFile(path, []byte("existing"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := u.WriteLink(&test.in.link)
+		if ok := err == nil; ok != test.out.ok {
+			t.Errorf("#%d: bad result: want %t, got %v", i, test.out.ok, err)
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		target := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err = os.Readlink(path)
+		} else {
+			var contents []byte
+			contents, err = ioutil.ReadFile(path)
+			target = string(contents)
+		}
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if target != test.out.target {
+			t.Errorf("#%d: bad target: want %q, got %q", i, test.out.target, target)
+		}
+	}
+}
+
+func TestRemovePath(t *testing.T) {
+	type in struct {
+		removal config.Removal
+	}
+	type out struct {
+		ok     bool
+		exists bool
+	}
+
+	dir, err := ioutil.TempDir("", "ignition-util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, path := range []string{"/etc/a.conf", "/etc/b.conf", "/etc/c.d/c.conf", "/etc/d.d/d.conf"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, path), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{removal: config.Removal{Path: "/etc/a.conf"}},
+			out: out{ok: true, exists: false},
+		},
+		{
+			in:  in{removal: config.Removal{Path: "/etc/missing.conf"}},
+			out: out{ok: false, exists: false},
+		},
+		{
+			in:  in{removal: config.Removal{Path: "/etc/missing.conf", IgnoreMissing: true}},
+			out: out{ok: true, exists: false},
+		},
+		{
+			in:  in{removal: config.Removal{Path: "/etc/c.d"}},
+			out: out{ok: false, exists: true},
+		},
+		{
+			in:  in{removal: config.Removal{Path: "/etc/d.d", Recursive: true}},
+			out: out{ok: true, exists: false},
+		},
+	}
+
+	logger := log.NewTest()
+	u := Util{DestDir: dir, Logger: &logger}
+	for i, test := range tests {
+		err := u.RemovePath(&test.in.removal)
+		_, statErr := os.Lstat(u.JoinPath(test.in.removal.Path))
+		if got := (out{ok: err == nil, exists: statErr == nil}); !reflect.DeepEqual(test.out, got) {
+			t.Errorf("#%d: bad result: want %+v, got %+v (err %v)", i, test.out, got, err)
+		}
+	}
+
+	if _, err := os.Lstat(filepath.Join(dir, "/etc/b.conf")); err != nil {
+		t.Errorf("unrelated file was removed: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"github.com/coreos/ignition/config"
+)
+
+const modulesLoadPath = "/etc/modules-load.d/ignition.conf"
+
+// WriteModules writes the modules-load.d file having systemd load modules
+// at boot, one module per line. Nothing is written when there are none.
+func (u Util) WriteModules(modules []config.KernelModule) error {
+	if len(modules) == 0 {
+		return nil
+	}
+
+	contents := ""
+	for _, module := range modules {
+		contents += string(module) + "\n"
+	}
+	err := u.Logger.LogOp(
+		func() error {
+			return u.WriteFile(&config.File{
+				Path:     modulesLoadPath,
+				Contents: contents,
+				Mode:     DefaultFilePermissions,
+			})
+		},
+		"writing %q", modulesLoadPath,
+	)
+	u.Report.Add("write file", modulesLoadPath, err)
+	return err
+}
@@ -22,6 +22,10 @@ func SystemdUnitsPath() string {
 	return filepath.Join("etc", "systemd", "system")
 }
 
+func SystemdRuntimeUnitsPath() string {
+	return filepath.Join("run", "systemd", "system")
+}
+
 func NetworkdUnitsPath() string {
 	return filepath.Join("etc", "systemd", "network")
 }
@@ -29,3 +33,11 @@ func NetworkdUnitsPath() string {
 func SystemdDropinsPath(unitName string) string {
 	return filepath.Join("etc", "systemd", "system", unitName+".d")
 }
+
+func SystemdRuntimeDropinsPath(unitName string) string {
+	return filepath.Join("run", "systemd", "system", unitName+".d")
+}
+
+func NetworkdDropinsPath(unitName string) string {
+	return filepath.Join("etc", "systemd", "network", unitName+".d")
+}
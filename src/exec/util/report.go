@@ -0,0 +1,84 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"sync"
+)
+
+// The outcomes recorded in a Report.
+const (
+	StatusDone    = "done"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+)
+
+// ReportEntry records the outcome of one operation on one target, such as
+// formatting a device or writing a file.
+type ReportEntry struct {
+	Stage     string `json:"stage"`
+	Operation string `json:"operation"`
+	Target    string `json:"target"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Report accumulates what a stage did, for automation to check provisioning
+// went as intended without scraping the logs. It's safe for concurrent use,
+// and a nil Report records nothing.
+type Report struct {
+	stage   string
+	mu      sync.Mutex
+	entries []ReportEntry
+}
+
+// NewReport returns an empty Report of the named stage.
+func NewReport(stage string) *Report {
+	return &Report{stage: stage}
+}
+
+// Add records op on target as done, or as failed if err is set.
+func (r *Report) Add(op, target string, err error) {
+	if err != nil {
+		r.add(ReportEntry{Operation: op, Target: target, Status: StatusFailed, Error: err.Error()})
+	} else {
+		r.add(ReportEntry{Operation: op, Target: target, Status: StatusDone})
+	}
+}
+
+// Skip records op on target as skipped, having been unnecessary.
+func (r *Report) Skip(op, target string) {
+	r.add(ReportEntry{Operation: op, Target: target, Status: StatusSkipped})
+}
+
+func (r *Report) add(e ReportEntry) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.Stage = r.stage
+	r.entries = append(r.entries, e)
+}
+
+// Entries returns the entries recorded so far, in the order they were added.
+func (r *Report) Entries() []ReportEntry {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ReportEntry{}, r.entries...)
+}
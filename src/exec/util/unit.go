@@ -16,12 +16,23 @@ package util
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/coreos/ignition/config"
 )
 
+// unitSearchPaths are the directories searched, in order, for installed units.
+var unitSearchPaths = []string{
+	"/etc/systemd/system",
+	"/run/systemd/system",
+	"/usr/lib/systemd/system",
+	"/lib/systemd/system",
+}
+
 const (
 	presetPath               string      = "/etc/systemd/system-preset/20-ignition.preset"
 	DefaultPresetPermissions os.FileMode = 0644
@@ -29,43 +40,222 @@ const (
 
 func FileFromSystemdUnit(unit config.SystemdUnit) *config.File {
 	return &config.File{
-		Path:     filepath.Join(SystemdUnitsPath(), string(unit.Name)),
+		Path:     filepath.Join(unitsPath(unit), string(unit.Name)),
 		Contents: unit.Contents,
 		Mode:     DefaultFilePermissions,
-		Uid:      0,
-		Gid:      0,
 	}
 }
 
+// unitsPath returns the directory the unit belongs in: /run for runtime units,
+// which vanish on reboot, and /etc otherwise.
+func unitsPath(unit config.SystemdUnit) string {
+	if unit.Runtime {
+		return SystemdRuntimeUnitsPath()
+	}
+	return SystemdUnitsPath()
+}
+
+// forUnit returns the Util the unit's files are written through. Runtime
+// units go under RuntimeDir: in the initramfs the real root's /run is hidden
+// by a fresh tmpfs after switch-root, while the initramfs's own /run is
+// carried over.
+func (u Util) forUnit(unit config.SystemdUnit) Util {
+	if unit.Runtime && u.RuntimeDir != "" {
+		u.DestDir = u.RuntimeDir
+	}
+	return u
+}
+
+// dropinsPath returns the directory the unit's dropins belong in.
+func dropinsPath(unit config.SystemdUnit) string {
+	if unit.Runtime {
+		return SystemdRuntimeDropinsPath(string(unit.Name))
+	}
+	return SystemdDropinsPath(string(unit.Name))
+}
+
+// FileFromNetworkdUnit returns the file for the unit. networkd reads .netdev
+// and .network units, and udev reads .link units, all from NetworkdUnitsPath.
 func FileFromNetworkdUnit(unit config.NetworkdUnit) *config.File {
 	return &config.File{
 		Path:     filepath.Join(NetworkdUnitsPath(), string(unit.Name)),
 		Contents: unit.Contents,
 		Mode:     DefaultFilePermissions,
-		Uid:      0,
-		Gid:      0,
 	}
 }
 
 func FileFromUnitDropin(unit config.SystemdUnit, dropin config.SystemdUnitDropIn) *config.File {
 	return &config.File{
-		Path:     filepath.Join(SystemdDropinsPath(string(unit.Name)), string(dropin.Name)),
+		Path:     filepath.Join(dropinsPath(unit), string(dropin.Name)),
 		Contents: dropin.Contents,
 		Mode:     DefaultFilePermissions,
-		Uid:      0,
-		Gid:      0,
 	}
 }
 
+func FileFromNetworkdDropin(unit config.NetworkdUnit, dropin config.NetworkdUnitDropIn) *config.File {
+	return &config.File{
+		Path:     filepath.Join(NetworkdDropinsPath(string(unit.Name)), string(dropin.Name)),
+		Contents: dropin.Contents,
+		Mode:     DefaultFilePermissions,
+	}
+}
+
+// MaskUnit masks the unit by linking it to /dev/null, replacing whatever is
+// at its path already. Instances such as getty@tty1.service are masked
+// individually, leaving the template usable.
 func (u Util) MaskUnit(unit config.SystemdUnit) error {
-	path := u.JoinPath(SystemdUnitsPath(), string(unit.Name))
+	path := u.forUnit(unit).JoinPath(unitsPath(unit), string(unit.Name))
 	if err := mkdirForFile(path); err != nil {
 		return err
 	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return os.Symlink("/dev/null", path)
 }
 
+// EnableUnit enables the unit through the preset file, or when LinkUnits is
+// set, by linking it into the targets its [Install] section lists. Presets
+// can't name individual instances of a template, so instances such as
+// getty@tty1.service are always linked into the targets of their template.
+// When SystemctlUnits is set and systemctl is available, systemd enables the
+// unit itself instead, honouring Alias= and the rest of [Install].
 func (u Util) EnableUnit(unit config.SystemdUnit) error {
+	if u.SystemctlUnits {
+		if systemctl, err := systemctlPath(); err == nil {
+			return u.systemctlEnable(systemctl, unit)
+		}
+		u.Logger.Warning("systemctl not found, enabling %q without it", unit.Name)
+	}
+	if template, _, ok := unitInstance(string(unit.Name)); ok {
+		return u.linkUnit(unit, template)
+	}
+	if u.LinkUnits {
+		return u.linkUnit(unit, string(unit.Name))
+	}
+	return u.appendPreset(fmt.Sprintf("enable %s\n", unit.Name))
+}
+
+// systemctlPath returns the systemctl binary, preferring the one in $PATH.
+func systemctlPath() (string, error) {
+	if path, err := exec.LookPath("systemctl"); err == nil {
+		return path, nil
+	}
+	return exec.LookPath("/usr/bin/systemctl")
+}
+
+// systemctlEnable enables the unit under DestDir by running systemctl.
+func (u Util) systemctlEnable(systemctl string, unit config.SystemdUnit) error {
+	args := []string{"--root=" + u.forUnit(unit).DestDir, "enable"}
+	if unit.Runtime {
+		args = append(args, "--runtime")
+	}
+	args = append(args, string(unit.Name))
+	return u.Logger.LogCmd(exec.Command(systemctl, args...), "enabling %q with systemctl", unit.Name)
+}
+
+// linkUnit links the unit into the .wants/ and .requires/ directories named
+// by its own contents or, lacking any, by the installed unit source.
+func (u Util) linkUnit(unit config.SystemdUnit, source string) error {
+	target := filepath.Join("/", unitsPath(unit), string(unit.Name))
+	contents := unit.Contents
+	if contents == "" {
+		path, data, err := u.readUnit(source)
+		if err != nil {
+			return err
+		}
+		target, contents = path, string(data)
+	}
+
+	dirs := installDirs(contents)
+	if len(dirs) == 0 {
+		return fmt.Errorf("%q has no WantedBy or RequiredBy in its [Install] section", source)
+	}
+	for _, dir := range dirs {
+		path := u.forUnit(unit).JoinPath(unitsPath(unit), dir, string(unit.Name))
+		if err := mkdirForFile(path); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Symlink(target, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readUnit finds the named unit in the unit search path under DestDir,
+// returning its path relative to DestDir along with its contents.
+func (u Util) readUnit(name string) (string, []byte, error) {
+	for _, dir := range unitSearchPaths {
+		path := filepath.Join(dir, name)
+		data, err := ioutil.ReadFile(u.JoinPath(path))
+		if err == nil {
+			return path, data, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", nil, err
+		}
+	}
+	return "", nil, fmt.Errorf("unit %q not found in %v", name, unitSearchPaths)
+}
+
+// unitInstance splits an instantiated unit name such as "getty@tty1.service"
+// into its template "getty@.service" and instance "tty1". ok is false for
+// names which aren't instances, including bare templates.
+func unitInstance(name string) (template, instance string, ok bool) {
+	at := strings.Index(name, "@")
+	ext := strings.LastIndex(name, ".")
+	if at < 0 || ext < at+2 {
+		return "", "", false
+	}
+	return name[:at+1] + name[ext:], name[at+1 : ext], true
+}
+
+// installDirs returns the .wants/ and .requires/ directory names implied by
+// the WantedBy= and RequiredBy= settings in the [Install] section of contents.
+func installDirs(contents string) []string {
+	dirs := []string{}
+	section := ""
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line
+			continue
+		}
+		if section != "[Install]" {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		suffix := ""
+		switch strings.TrimSpace(kv[0]) {
+		case "WantedBy":
+			suffix = ".wants"
+		case "RequiredBy":
+			suffix = ".requires"
+		default:
+			continue
+		}
+		for _, target := range strings.Fields(kv[1]) {
+			dirs = append(dirs, target+suffix)
+		}
+	}
+	return dirs
+}
+
+func (u Util) DisableUnit(unit config.SystemdUnit) error {
+	return u.appendPreset(fmt.Sprintf("disable %s\n", unit.Name))
+}
+
+// appendPreset appends the supplied preset rule to the preset file, unless
+// the file already holds it.
+func (u Util) appendPreset(rule string) error {
 	path := u.JoinPath(presetPath)
 	if err := mkdirForFile(path); err != nil {
 		return err
@@ -76,6 +266,200 @@ func (u Util) EnableUnit(unit config.SystemdUnit) error {
 	}
 	defer file.Close()
 
-	_, err = file.WriteString(fmt.Sprintf("enable %s\n", unit.Name))
+	existing, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(existing), "\n") {
+		if strings.TrimSpace(line) == strings.TrimSpace(rule) {
+			return nil
+		}
+	}
+
+	_, err = file.WriteString(rule)
 	return err
 }
+
+// CreateUnits writes the units listed in systemd and networkd, along with
+// their dropins, and enables, disables or masks the systemd units as asked.
+// Each of the boot commands in systemd gets an enabled unit of its own.
+func (u Util) CreateUnits(systemd config.Systemd, networkd config.Networkd) error {
+	units := append(append([]config.SystemdUnit{}, systemd.Units...), CommandUnits(systemd.Commands)...)
+	if err := checkMasks(units); err != nil {
+		return err
+	}
+	for _, unit := range units {
+		if ok, err := u.ConditionMet(unit.Condition); err != nil {
+			u.Report.Add("write unit", string(unit.Name), err)
+			return fmt.Errorf("failed to check the condition of unit %q: %v", unit.Name, err)
+		} else if !ok {
+			u.Logger.Info("skipping unit %q: its condition isn't met", unit.Name)
+			u.Report.Skip("write unit", string(unit.Name))
+			continue
+		}
+
+		err := u.writeSystemdUnit(unit)
+		if unit.Contents != "" || len(unit.DropIns) != 0 {
+			u.Report.Add("write unit", string(unit.Name), err)
+		}
+		if err != nil {
+			return err
+		}
+		if unit.Enable {
+			err := u.RunOp(
+				func() error { return u.EnableUnit(unit) },
+				"enabling unit %q", unit.Name,
+			)
+			u.Report.Add("enable unit", string(unit.Name), err)
+			if err != nil {
+				return err
+			}
+		}
+		if unit.Disable {
+			err := u.RunOp(
+				func() error { return u.DisableUnit(unit) },
+				"disabling unit %q", unit.Name,
+			)
+			u.Report.Add("disable unit", string(unit.Name), err)
+			if err != nil {
+				return err
+			}
+		}
+		if unit.Mask {
+			err := u.RunOp(
+				func() error { return u.MaskUnit(unit) },
+				"masking unit %q", unit.Name,
+			)
+			u.Report.Add("mask unit", string(unit.Name), err)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, unit := range networkd.Units {
+		err := u.writeNetworkdUnit(unit)
+		if unit.Contents != "" || len(unit.DropIns) != 0 {
+			u.Report.Add("write unit", string(unit.Name), err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReloadSystemd has a running systemd pick up the units just written. When
+// writing into an image, or systemd isn't running, there's nothing to reload.
+func (u Util) ReloadSystemd() error {
+	if u.DestDir != "/" {
+		u.Logger.Info("not reloading systemd: units were written under %q", u.DestDir)
+		return nil
+	}
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		u.Logger.Info("not reloading systemd: systemd isn't running")
+		return nil
+	}
+	return u.RunCmd(exec.Command("/usr/bin/systemctl", "daemon-reload"), "reloading systemd")
+}
+
+// checkMasks returns an error if any masked unit is also enabled or given
+// dropins, either in the same entry or in another entry of the same name, or
+// if an instance of a masked template is enabled. Masking an instance leaves
+// its template and other instances alone.
+func checkMasks(units []config.SystemdUnit) error {
+	masked := map[config.SystemdUnitName]bool{}
+	for _, unit := range units {
+		if unit.Mask {
+			masked[unit.Name] = true
+		}
+	}
+
+	for _, unit := range units {
+		if template, _, ok := unitInstance(string(unit.Name)); ok && unit.Enable && masked[config.SystemdUnitName(template)] {
+			return fmt.Errorf("unit %q cannot be enabled, its template %q is masked", unit.Name, template)
+		}
+		if !masked[unit.Name] {
+			continue
+		}
+		if unit.Enable {
+			return fmt.Errorf("unit %q cannot be both masked and enabled", unit.Name)
+		}
+		for _, dropin := range unit.DropIns {
+			if dropin.Contents != "" {
+				return fmt.Errorf("unit %q is masked, but has dropin %q", unit.Name, dropin.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// writeSystemdUnit creates the specified unit and any dropins for that unit.
+// If the contents of the unit or are empty, the unit is not created. The same
+// applies to the unit's dropins.
+func (u Util) writeSystemdUnit(unit config.SystemdUnit) error {
+	w := u.forUnit(unit)
+	return u.Logger.LogOp(func() error {
+		for _, dropin := range unit.DropIns {
+			if dropin.Contents == "" {
+				continue
+			}
+
+			f := FileFromUnitDropin(unit, dropin)
+			if err := u.Logger.LogOp(
+				func() error { return w.WriteFile(f) },
+				"writing dropin %q at %q", dropin.Name, f.Path,
+			); err != nil {
+				return err
+			}
+		}
+
+		if unit.Contents == "" {
+			return nil
+		}
+
+		f := FileFromSystemdUnit(unit)
+		if err := u.Logger.LogOp(
+			func() error { return w.WriteFile(f) },
+			"writing unit %q at %q", unit.Name, f.Path,
+		); err != nil {
+			return err
+		}
+
+		return nil
+	}, "writing unit %q", unit.Name)
+}
+
+// writeNetworkdUnit creates the specified unit and any dropins for that unit.
+// If the contents of the unit or are empty, the unit is not created. The same
+// applies to the unit's dropins.
+func (u Util) writeNetworkdUnit(unit config.NetworkdUnit) error {
+	return u.Logger.LogOp(func() error {
+		for _, dropin := range unit.DropIns {
+			if dropin.Contents == "" {
+				continue
+			}
+
+			f := FileFromNetworkdDropin(unit, dropin)
+			if err := u.Logger.LogOp(
+				func() error { return u.WriteFile(f) },
+				"writing dropin %q at %q", dropin.Name, f.Path,
+			); err != nil {
+				return err
+			}
+		}
+
+		if unit.Contents == "" {
+			return nil
+		}
+
+		f := FileFromNetworkdUnit(unit)
+		if err := u.Logger.LogOp(
+			func() error { return u.WriteFile(f) },
+			"writing unit %q at %q", unit.Name, f.Path,
+		); err != nil {
+			return err
+		}
+
+		return nil
+	}, "writing unit %q", unit.Name)
+}
@@ -0,0 +1,251 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/log"
+)
+
+func TestUnitInstance(t *testing.T) {
+	type in struct {
+		name string
+	}
+	type out struct {
+		template string
+		instance string
+		ok       bool
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{name: "getty@tty1.service"},
+			out: out{template: "getty@.service", instance: "tty1", ok: true},
+		},
+		{
+			in:  in{name: "serial-getty@ttyS0.service"},
+			out: out{template: "serial-getty@.service", instance: "ttyS0", ok: true},
+		},
+		{
+			in:  in{name: "systemd-fsck@dev-disk-by\\x2dlabel-ROOT.service"},
+			out: out{template: "systemd-fsck@.service", instance: "dev-disk-by\\x2dlabel-ROOT", ok: true},
+		},
+		{
+			in:  in{name: "getty@.service"},
+			out: out{},
+		},
+		{
+			in:  in{name: "docker.service"},
+			out: out{},
+		},
+	}
+
+	for i, test := range tests {
+		template, instance, ok := unitInstance(test.in.name)
+		if got := (out{template: template, instance: instance, ok: ok}); !reflect.DeepEqual(test.out, got) {
+			t.Errorf("#%d: bad result: want %+v, got %+v", i, test.out, got)
+		}
+	}
+}
+
+func TestCheckMasks(t *testing.T) {
+	type in struct {
+		units []config.SystemdUnit
+	}
+	type out struct {
+		err error
+	}
+
+	dropin := config.SystemdUnitDropIn{Name: "10-override.conf", Contents: "[Service]\n"}
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{units: []config.SystemdUnit{{Name: "docker.service", Mask: true}, {Name: "etcd.service", Enable: true}}},
+			out: out{},
+		},
+		{
+			in:  in{units: []config.SystemdUnit{{Name: "docker.service", Mask: true, Enable: true}}},
+			out: out{err: errors.New(`unit "docker.service" cannot be both masked and enabled`)},
+		},
+		{
+			in:  in{units: []config.SystemdUnit{{Name: "docker.service", Mask: true}, {Name: "docker.service", Enable: true}}},
+			out: out{err: errors.New(`unit "docker.service" cannot be both masked and enabled`)},
+		},
+		{
+			in:  in{units: []config.SystemdUnit{{Name: "docker.service", Mask: true}, {Name: "docker.service", DropIns: []config.SystemdUnitDropIn{dropin}}}},
+			out: out{err: errors.New(`unit "docker.service" is masked, but has dropin "10-override.conf"`)},
+		},
+		{
+			in:  in{units: []config.SystemdUnit{{Name: "docker.service", Mask: true, DropIns: []config.SystemdUnitDropIn{{Name: "10-empty.conf"}}}}},
+			out: out{},
+		},
+		{
+			in:  in{units: []config.SystemdUnit{{Name: "getty@tty1.service", Mask: true}, {Name: "getty@tty2.service", Enable: true}, {Name: "getty@.service", Enable: true}}},
+			out: out{},
+		},
+		{
+			in:  in{units: []config.SystemdUnit{{Name: "getty@tty1.service", Mask: true}, {Name: "getty@tty1.service", Enable: true}}},
+			out: out{err: errors.New(`unit "getty@tty1.service" cannot be both masked and enabled`)},
+		},
+		{
+			in:  in{units: []config.SystemdUnit{{Name: "getty@.service", Mask: true}, {Name: "getty@tty1.service", Enable: true}}},
+			out: out{err: errors.New(`unit "getty@tty1.service" cannot be enabled, its template "getty@.service" is masked`)},
+		},
+	}
+
+	for i, test := range tests {
+		err := checkMasks(test.in.units)
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
+
+func TestInstallDirs(t *testing.T) {
+	type in struct {
+		contents string
+	}
+	type out struct {
+		dirs []string
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{contents: "[Unit]\nDescription=Getty on %I\n\n[Service]\nExecStart=/sbin/agetty %I\n\n[Install]\nWantedBy=getty.target\n"},
+			out: out{dirs: []string{"getty.target.wants"}},
+		},
+		{
+			in:  in{contents: "[Install]\nWantedBy=multi-user.target graphical.target\nRequiredBy=foo.target\n"},
+			out: out{dirs: []string{"multi-user.target.wants", "graphical.target.wants", "foo.target.requires"}},
+		},
+		{
+			in:  in{contents: "[Unit]\nWantedBy=multi-user.target\n"},
+			out: out{dirs: []string{}},
+		},
+	}
+
+	for i, test := range tests {
+		dirs := installDirs(test.in.contents)
+		if !reflect.DeepEqual(test.out.dirs, dirs) {
+			t.Errorf("#%d: bad dirs: want %v, got %v", i, test.out.dirs, dirs)
+		}
+	}
+}
+
+func TestFileFromNetworkdUnit(t *testing.T) {
+	type in struct {
+		unit config.NetworkdUnit
+	}
+	type out struct {
+		path string
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{unit: config.NetworkdUnit{Name: "br0.netdev", Contents: "[NetDev]\nName=br0\nKind=bridge\n"}},
+			out: out{path: "etc/systemd/network/br0.netdev"},
+		},
+		{
+			in:  in{unit: config.NetworkdUnit{Name: "br0.network", Contents: "[Match]\nName=br0\n"}},
+			out: out{path: "etc/systemd/network/br0.network"},
+		},
+		{
+			in:  in{unit: config.NetworkdUnit{Name: "10-eth.link", Contents: "[Match]\nOriginalName=eth*\n"}},
+			out: out{path: "etc/systemd/network/10-eth.link"},
+		},
+	}
+
+	for i, test := range tests {
+		f := FileFromNetworkdUnit(test.in.unit)
+		if f.Path != test.out.path {
+			t.Errorf("#%d: bad path: want %q, got %q", i, test.out.path, f.Path)
+		}
+		if f.Contents != test.in.unit.Contents {
+			t.Errorf("#%d: bad contents: want %q, got %q", i, test.in.unit.Contents, f.Contents)
+		}
+	}
+}
+
+func TestFileFromNetworkdDropin(t *testing.T) {
+	unit := config.NetworkdUnit{Name: "br0.network"}
+	dropin := config.NetworkdUnitDropIn{Name: "10-dhcp.conf", Contents: "[Network]\nDHCP=yes\n"}
+
+	f := FileFromNetworkdDropin(unit, dropin)
+	if want := "etc/systemd/network/br0.network.d/10-dhcp.conf"; f.Path != want {
+		t.Errorf("bad path: want %q, got %q", want, f.Path)
+	}
+}
+
+func TestCreateUnitsRuntime(t *testing.T) {
+	dest, err := ioutil.TempDir("", "ignition-dest-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dest)
+	runtime, err := ioutil.TempDir("", "ignition-runtime-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(runtime)
+
+	logger := log.NewTest()
+	u := Util{DestDir: dest, RuntimeDir: runtime, Logger: &logger}
+	systemd := config.Systemd{Units: []config.SystemdUnit{
+		{Name: "oneshot.service", Runtime: true, Contents: "[Service]\nExecStart=/bin/true\n"},
+		{Name: "masked.service", Runtime: true, Mask: true},
+		{Name: "persistent.service", Contents: "[Service]\nExecStart=/bin/true\n"},
+	}}
+	if err := u.CreateUnits(systemd, config.Networkd{}); err != nil {
+		t.Fatalf("failed to create units: %v", err)
+	}
+
+	tests := []struct {
+		root   string
+		path   string
+		exists bool
+	}{
+		{runtime, "run/systemd/system/oneshot.service", true},
+		{runtime, "run/systemd/system/masked.service", true},
+		{dest, "run/systemd/system/oneshot.service", false},
+		{dest, "run/systemd/system/masked.service", false},
+		{dest, "etc/systemd/system/persistent.service", true},
+		{runtime, "etc/systemd/system/persistent.service", false},
+	}
+
+	for i, test := range tests {
+		_, err := os.Lstat(filepath.Join(test.root, test.path))
+		if exists := err == nil; exists != test.exists {
+			t.Errorf("#%d: bad existence of %q: want %v, got %v", i, test.path, test.exists, exists)
+		}
+	}
+}
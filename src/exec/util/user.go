@@ -0,0 +1,329 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/ignition/config"
+)
+
+const (
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+	shadowPath = "/etc/shadow"
+)
+
+// resolveOwner returns the uid and gid f should be owned by, root unless f
+// says otherwise. User and group names are resolved against the databases in
+// the context rather than the host's. Validation keeps a file from giving
+// both an ID and a name.
+func (u Util) resolveOwner(f *config.File) (uid int, gid int, err error) {
+	if f.Uid != nil {
+		uid = *f.Uid
+	}
+	if f.Gid != nil {
+		gid = *f.Gid
+	}
+
+	if f.User != "" {
+		if uid, err = lookupID(u.JoinPath(passwdPath), f.User); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if f.Group != "" {
+		if gid, err = lookupID(u.JoinPath(groupPath), f.Group); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// EnsureGroup creates the group in the context. An existing group is left
+// alone, unless it has a different gid than requested.
+func (u Util) EnsureGroup(group config.Group) error {
+	path := u.JoinPath(groupPath)
+	exists, err := hasEntry(path, group.Name)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		gid, err := lookupID(path, group.Name)
+		if err != nil {
+			return err
+		}
+		if group.Gid != nil && uint(gid) != *group.Gid {
+			return fmt.Errorf("group %q already exists with gid %d, not %d", group.Name, gid, *group.Gid)
+		}
+		u.Logger.Info("group %q already exists", group.Name)
+		return nil
+	}
+
+	args := []string{"--root", u.DestDir}
+	if group.Gid != nil {
+		args = append(args, "--gid", strconv.FormatUint(uint64(*group.Gid), 10))
+	}
+	if group.System {
+		args = append(args, "--system")
+	}
+	args = append(args, group.Name)
+	return u.RunCmd(exec.Command("/usr/sbin/groupadd", args...), "creating group %q", group.Name)
+}
+
+// EnsureUser creates the user in the context, or updates it to match when
+// it already exists.
+func (u Util) EnsureUser(user config.User) error {
+	exists, err := hasEntry(u.JoinPath(passwdPath), user.Name)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"--root", u.DestDir}
+	if user.Uid != nil {
+		args = append(args, "--uid", strconv.FormatUint(uint64(*user.Uid), 10))
+	}
+	if user.PrimaryGroup != "" {
+		args = append(args, "--gid", user.PrimaryGroup)
+	}
+	if user.HomeDir != "" {
+		args = append(args, "--home", user.HomeDir)
+	}
+	if user.Shell != "" {
+		args = append(args, "--shell", user.Shell)
+	}
+
+	if exists {
+		if user.System {
+			u.Logger.Warning("user %q already exists, so it can't be made a system user", user.Name)
+		}
+		args = append(args, user.Name)
+		if err := u.RunCmd(exec.Command("/usr/sbin/usermod", args...), "updating user %q", user.Name); err != nil {
+			return err
+		}
+	} else {
+		if user.System {
+			args = append(args, "--system")
+		} else {
+			args = append(args, "--create-home")
+		}
+		args = append(args, user.Name)
+		if err := u.RunCmd(exec.Command("/usr/sbin/useradd", args...), "creating user %q", user.Name); err != nil {
+			return err
+		}
+	}
+
+	// without a hash the account stays locked, as useradd leaves it
+	if user.PasswordHash == "" {
+		return nil
+	}
+	return u.RunOp(
+		func() error { return setPasswordHash(u.JoinPath(shadowPath), user.Name, user.PasswordHash) },
+		"setting password hash for %q", user.Name,
+	)
+}
+
+// setPasswordHash replaces the password field of name's entry in the
+// shadow(5) database at path. The hash is written to the file directly
+// rather than passed to usermod, which would put it in the logged arguments.
+// The replacement keeps the original's mode, owner and group, and reaches
+// the disk before it is renamed into place.
+func setPasswordHash(path, name, hash string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || fields[0] != name {
+			continue
+		}
+		fields[1] = hash
+		lines[i] = strings.Join(fields, ":")
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("%q not found in %q", name, path)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".shadow")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(lines, "\n")); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode()); err != nil {
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err := os.Chown(tmp.Name(), int(stat.Uid), int(stat.Gid)); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// AuthorizeKeys adds the user's SSH keys to ~/.ssh/authorized_keys in the
+// context, leaving keys already present there alone.
+func (u Util) AuthorizeKeys(user config.User) error {
+	// the user may only exist once a dry run is over, so it's skipped whole
+	return u.RunOp(
+		func() error { return u.authorizeKeys(user) },
+		"authorizing %d ssh keys for %q", len(user.SSHAuthorizedKeys), user.Name,
+	)
+}
+
+// authorizeKeys does the work of AuthorizeKeys.
+func (u Util) authorizeKeys(user config.User) error {
+	uid, gid, home, err := lookupUser(u.JoinPath(passwdPath), user.Name)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(home, ".ssh")
+	if err := u.WriteDirectory(&config.Directory{Path: dir, Mode: 0700, Uid: uid, Gid: gid}); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, "authorized_keys")
+	existing, err := ioutil.ReadFile(u.JoinPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return u.WriteFile(&config.File{
+		Path:     path,
+		Contents: mergeKeys(string(existing), user.SSHAuthorizedKeys),
+		Mode:     0600,
+		Uid:      &uid,
+		Gid:      &gid,
+	})
+}
+
+// mergeKeys returns the authorized_keys file holding the keys of existing
+// followed by any of keys not among them, without blank lines or duplicates.
+func mergeKeys(existing string, keys []string) string {
+	merged := []string{}
+	present := map[string]bool{}
+	for _, key := range append(strings.Split(existing, "\n"), keys...) {
+		if key = strings.TrimSpace(key); key != "" && !present[key] {
+			merged = append(merged, key)
+			present[key] = true
+		}
+	}
+	return strings.Join(merged, "\n") + "\n"
+}
+
+// lookupUser returns the uid, gid and home directory of the named entry in
+// the passwd(5) database at path.
+func lookupUser(path, name string) (uid int, gid int, home string, err error) {
+	db, err := os.Open(path)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	defer db.Close()
+
+	scanner := bufio.NewScanner(db)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 6 || fields[0] != name {
+			continue
+		}
+		if uid, err = strconv.Atoi(fields[2]); err != nil {
+			return 0, 0, "", err
+		}
+		if gid, err = strconv.Atoi(fields[3]); err != nil {
+			return 0, 0, "", err
+		}
+		return uid, gid, fields[5], nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, "", err
+	}
+
+	return 0, 0, "", fmt.Errorf("%q not found in %q", name, path)
+}
+
+// hasEntry reports whether the passwd(5) or group(5) formatted database at
+// path has an entry for name.
+func hasEntry(path, name string) (bool, error) {
+	db, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	scanner := bufio.NewScanner(db)
+	for scanner.Scan() {
+		if strings.SplitN(scanner.Text(), ":", 2)[0] == name {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// lookupID returns the numeric ID of the named entry in the passwd(5) or
+// group(5) formatted database at path. Both formats store the ID in their
+// third field.
+func lookupID(path, name string) (int, error) {
+	db, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	scanner := bufio.NewScanner(db)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 || fields[0] != name {
+			continue
+		}
+		return strconv.Atoi(fields[2])
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("%q not found in %q", name, path)
+}
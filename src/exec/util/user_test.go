@@ -0,0 +1,123 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSetPasswordHash(t *testing.T) {
+	type in struct {
+		shadow string
+		name   string
+		hash   string
+	}
+	type out struct {
+		shadow string
+		ok     bool
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{shadow: "root:*:16000:0:::::\ncore:!:16000:0:::::\n", name: "core", hash: "$6$salt$hash"},
+			out: out{shadow: "root:*:16000:0:::::\ncore:$6$salt$hash:16000:0:::::\n", ok: true},
+		},
+		{
+			in:  in{shadow: "root:*:16000:0:::::\ncore:$6$old$hash:16000:0:::::\n", name: "core", hash: "$6$new$hash"},
+			out: out{shadow: "root:*:16000:0:::::\ncore:$6$new$hash:16000:0:::::\n", ok: true},
+		},
+		{
+			in:  in{shadow: "root:*:16000:0:::::\n", name: "core", hash: "$6$salt$hash"},
+			out: out{shadow: "root:*:16000:0:::::\n", ok: false},
+		},
+	}
+
+	for i, test := range tests {
+		dir, err := ioutil.TempDir("", "ignition-shadow-")
+		if err != nil {
+			t.Fatalf("failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "shadow")
+		if err := ioutil.WriteFile(path, []byte(test.in.shadow), 0640); err != nil {
+			t.Fatalf("failed to write shadow: %v", err)
+		}
+
+		err = setPasswordHash(path, test.in.name, test.in.hash)
+		if ok := err == nil; ok != test.out.ok {
+			t.Errorf("#%d: bad result: want %v, got %v (%v)", i, test.out.ok, ok, err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("#%d: failed to read shadow: %v", i, err)
+		}
+		if string(data) != test.out.shadow {
+			t.Errorf("#%d: bad shadow: want %q, got %q", i, test.out.shadow, string(data))
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("#%d: failed to stat shadow: %v", i, err)
+		}
+		if info.Mode() != 0640 {
+			t.Errorf("#%d: bad mode: want %v, got %v", i, os.FileMode(0640), info.Mode())
+		}
+	}
+}
+
+func TestMergeKeys(t *testing.T) {
+	type in struct {
+		existing string
+		keys     []string
+	}
+	type out struct {
+		merged string
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{existing: "", keys: []string{"ssh-rsa A"}},
+			out: out{merged: "ssh-rsa A\n"},
+		},
+		{
+			in:  in{existing: "ssh-rsa A\n", keys: []string{"ssh-rsa B"}},
+			out: out{merged: "ssh-rsa A\nssh-rsa B\n"},
+		},
+		{
+			in:  in{existing: "ssh-rsa A\nssh-rsa B\n", keys: []string{"ssh-rsa B", "ssh-rsa A"}},
+			out: out{merged: "ssh-rsa A\nssh-rsa B\n"},
+		},
+		{
+			in:  in{existing: "ssh-rsa A\n\n  ssh-rsa A  \nssh-rsa B", keys: []string{" ssh-rsa C ", "ssh-rsa C", ""}},
+			out: out{merged: "ssh-rsa A\nssh-rsa B\nssh-rsa C\n"},
+		},
+	}
+
+	for i, test := range tests {
+		merged := mergeKeys(test.in.existing, test.in.keys)
+		if !reflect.DeepEqual(test.out.merged, merged) {
+			t.Errorf("#%d: bad keys: want %q, got %q", i, test.out.merged, merged)
+		}
+	}
+}
@@ -15,14 +15,35 @@
 package util
 
 import (
+	"context"
+	"fmt"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/coreos/ignition/src/log"
 )
 
 // Util encapsulates logging and destdir indirection for the util methods.
 type Util struct {
-	DestDir string // directory prefix to use in applying fs paths.
+	DestDir      string        // directory prefix to use in applying fs paths.
+	RuntimeDir   string        // directory prefix for runtime units, DestDir if unset.
+	DryRun       bool          // log actions rather than performing them.
+	FetchTimeout time.Duration // total deadline for fetching remote contents.
+
+	FetchAttempts   int           // maximum attempts at fetching remote contents.
+	FetchBackoff    time.Duration // initial delay between fetch attempts.
+	FetchMaxBackoff time.Duration // maximum delay between fetch attempts.
+
+	DeviceTimeout time.Duration // how long to wait for devices to appear. 0 waits forever.
+	OpTimeout     time.Duration // how long a long-running command or mount may take. 0 waits forever.
+
+	LinkUnits      bool // enable units by linking them rather than by preset.
+	SystemctlUnits bool // enable units with systemctl --root, when it's available.
+
+	Metadata map[string]string // provider metadata file templates are rendered against.
+	Report   *Report           // where the actions taken are recorded, may be nil.
 	*log.Logger
 }
 
@@ -30,3 +51,62 @@ type Util struct {
 func (u Util) JoinPath(path ...string) string {
 	return filepath.Join(u.DestDir, filepath.Join(path...))
 }
+
+// RunCmd runs cmd as a logged operation via LogCmd.
+// In dry-run mode the command is only logged.
+func (u Util) RunCmd(cmd *exec.Cmd, format string, a ...interface{}) error {
+	if u.DryRun {
+		u.Logger.Info("[dryrun]   %s: %s", fmt.Sprintf(format, a...), strings.Join(cmd.Args, " "))
+		return nil
+	}
+	return u.Logger.LogCmd(cmd, format, a...)
+}
+
+// RunOp calls op as a logged operation via LogOp.
+// In dry-run mode the operation is only logged.
+func (u Util) RunOp(op func() error, format string, a ...interface{}) error {
+	if u.DryRun {
+		u.Logger.Info("[dryrun]   %s", fmt.Sprintf(format, a...))
+		return nil
+	}
+	return u.Logger.LogOp(op, format, a...)
+}
+
+// RunCmdTimeout runs the named command via RunCmd, killing it if it's still
+// running once u.OpTimeout has elapsed.
+func (u Util) RunCmdTimeout(name string, args []string, format string, a ...interface{}) error {
+	ctx, cancel := TimeoutContext(u.OpTimeout)
+	defer cancel()
+	err := u.RunCmd(exec.CommandContext(ctx, name, args...), format, a...)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", u.OpTimeout)
+	}
+	return err
+}
+
+// RunOpTimeout calls op via RunOp, giving up on it once u.OpTimeout has
+// elapsed. Not everything can be interrupted (a mount(2), for one), so op is
+// abandoned rather than stopped.
+func (u Util) RunOpTimeout(op func() error, format string, a ...interface{}) error {
+	ctx, cancel := TimeoutContext(u.OpTimeout)
+	defer cancel()
+	return u.RunOp(func() error {
+		done := make(chan error, 1)
+		go func() { done <- op() }()
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %v", u.OpTimeout)
+		}
+	}, format, a...)
+}
+
+// TimeoutContext returns a context which expires after timeout, or which
+// never expires if timeout is 0.
+func TimeoutContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
@@ -0,0 +1,95 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/ignition/src/log"
+)
+
+func TestRunOpTimeout(t *testing.T) {
+	type in struct {
+		timeout time.Duration
+		op      func() error
+	}
+	type out struct {
+		err error
+	}
+
+	failure := errors.New("failure")
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{timeout: 0, op: func() error { return nil }},
+			out: out{},
+		},
+		{
+			in:  in{timeout: time.Second, op: func() error { return failure }},
+			out: out{err: failure},
+		},
+		{
+			in:  in{timeout: 10 * time.Millisecond, op: func() error { time.Sleep(time.Second); return nil }},
+			out: out{err: errors.New("timed out after 10ms")},
+		},
+	}
+
+	logger := log.NewTest()
+	for i, test := range tests {
+		u := Util{OpTimeout: test.in.timeout, Logger: &logger}
+		err := u.RunOpTimeout(test.in.op, "test")
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
+
+func TestRunCmdTimeout(t *testing.T) {
+	type in struct {
+		timeout time.Duration
+		args    []string
+	}
+	type out struct {
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{timeout: time.Second, args: []string{"0"}},
+			out: out{},
+		},
+		{
+			in:  in{timeout: 10 * time.Millisecond, args: []string{"1"}},
+			out: out{err: errors.New("timed out after 10ms")},
+		},
+	}
+
+	logger := log.NewTest()
+	for i, test := range tests {
+		u := Util{OpTimeout: test.in.timeout, Logger: &logger}
+		err := u.RunCmdTimeout("sleep", test.in.args, "test")
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"github.com/coreos/ignition/config"
+)
+
+// SourceCheck is the outcome of verifying the remote source of one file.
+type SourceCheck struct {
+	Device config.DevicePath
+	Path   string
+	Source string
+	Err    error // nil if the contents matched their hash.
+}
+
+// VerifySources fetches the config along with those it references, then
+// fetches the remote source of every file which has a verification hash and
+// checks its contents against it, without writing anything, not even the
+// config cache. Each file is reported as it's checked. It returns true if
+// every source was fetched and matched.
+func (e Engine) VerifySources() bool {
+	cfg, _, _, err := e.readConfig()
+	if err != nil {
+		e.Logger.Crit("failed to acquire config: %v", err)
+		return false
+	}
+
+	e.Logger.PushPrefix("verifySources")
+	defer e.Logger.PopPrefix()
+
+	checks := e.verifySources(cfg)
+	failed := 0
+	for _, c := range checks {
+		if c.Err != nil {
+			failed++
+			e.Logger.Err("%q on %q: failed to verify %q: %v", c.Path, c.Device, c.Source, c.Err)
+		} else {
+			e.Logger.Info("%q on %q: verified %q", c.Path, c.Device, c.Source)
+		}
+	}
+	e.Logger.Info("%d of %d sources verified, %d failed", len(checks)-failed, len(checks), failed)
+	return failed == 0
+}
+
+// verifySources checks the remote sources of the files in cfg which have a
+// verification hash, in the order they're listed.
+func (e Engine) verifySources(cfg config.Config) []SourceCheck {
+	u := e.fetcher()
+	checks := []SourceCheck{}
+	for _, fs := range cfg.Storage.Filesystems {
+		for _, f := range fs.Files {
+			if f.Source == "" || f.Verification.Hash == "" {
+				continue
+			}
+			_, err := u.FetchVerified(f.Source, f.Verification.Hash)
+			checks = append(checks, SourceCheck{
+				Device: fs.Device,
+				Path:   f.Path,
+				Source: f.Source,
+				Err:    err,
+			})
+		}
+	}
+	return checks
+}
@@ -0,0 +1,131 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/log"
+)
+
+func TestVerifySources(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hello":
+			fmt.Fprint(w, "hello")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	sum := sha512.Sum512([]byte("hello"))
+	good := config.FileHash("sha512-" + hex.EncodeToString(sum[:]))
+	sum = sha512.Sum512([]byte("goodbye"))
+	bad := config.FileHash("sha512-" + hex.EncodeToString(sum[:]))
+
+	type in struct {
+		files []config.File
+	}
+	type out struct {
+		paths []string
+		ok    []bool
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{files: []config.File{{Path: "/a", Contents: "hello"}, {Path: "/b", Source: server.URL + "/hello"}}},
+			out: out{paths: []string{}, ok: []bool{}},
+		},
+		{
+			in: in{files: []config.File{
+				{Path: "/a", Source: server.URL + "/hello", Verification: config.Verification{Hash: good}},
+				{Path: "/b", Source: server.URL + "/hello", Verification: config.Verification{Hash: bad}},
+				{Path: "/c", Source: server.URL + "/missing", Verification: config.Verification{Hash: good}},
+			}},
+			out: out{paths: []string{"/a", "/b", "/c"}, ok: []bool{true, false, false}},
+		},
+	}
+
+	e := Engine{Logger: log.NewTest(), FileFetchRetry: RetryOptions{Attempts: 1}}
+	for i, test := range tests {
+		cfg := config.Config{Storage: config.Storage{Filesystems: []config.Filesystem{{Device: "/dev/sda", Files: test.in.files}}}}
+		checks := e.verifySources(cfg)
+		got := out{paths: []string{}, ok: []bool{}}
+		for _, c := range checks {
+			got.paths = append(got.paths, c.Path)
+			got.ok = append(got.ok, c.Err == nil)
+		}
+		if !reflect.DeepEqual(test.out, got) {
+			t.Errorf("#%d: bad checks: want %+v, got %+v", i, test.out, got)
+		}
+	}
+}
+
+func TestVerifySourcesReferences(t *testing.T) {
+	sum := sha512.Sum512([]byte("hello"))
+	hash := "sha512-" + hex.EncodeToString(sum[:])
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hello":
+			fmt.Fprint(w, "goodbye")
+		case "/child":
+			fmt.Fprintf(w, `{"ignitionVersion": 1, "storage": {"filesystems": [{"device": "/dev/sda", "files": [{"path": "/a", "source": "%s/hello", "verification": {"hash": "%s"}}]}]}}`, server.URL, hash)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "ignition-verify-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := Engine{
+		Logger:         log.NewTest(),
+		ConfigCache:    filepath.Join(dir, "config.json"),
+		FetchTimeout:   time.Second,
+		FileFetchRetry: RetryOptions{Attempts: 1},
+	}.Init()
+	e.AddProvider(mockProvider{name: "mock", online: true, config: config.Config{
+		Version:  1,
+		Ignition: config.Ignition{Config: config.IgnitionConfig{Append: []config.ConfigReference{{Source: server.URL + "/child"}}}},
+	}})
+
+	// the referenced config's only file doesn't match its hash
+	if e.VerifySources() {
+		t.Errorf("bad result: want false, got true")
+	}
+	if _, err := os.Stat(e.ConfigCache); !os.IsNotExist(err) {
+		t.Errorf("bad config cache: want none, got %v", err)
+	}
+}
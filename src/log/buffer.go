@@ -0,0 +1,63 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"sync"
+)
+
+// flushLock serializes flushes so buffered messages are delivered as contiguous blocks.
+var flushLock sync.Mutex
+
+// buffer implements LoggerOps by queueing messages for later delivery to ops.
+type buffer struct {
+	ops  LoggerOps
+	msgs []func() error
+}
+
+func (b *buffer) queue(logFunc func(string) error, msg string) error {
+	b.msgs = append(b.msgs, func() error { return logFunc(msg) })
+	return nil
+}
+
+func (b *buffer) Emerg(msg string) error   { return b.queue(b.ops.Emerg, msg) }
+func (b *buffer) Alert(msg string) error   { return b.queue(b.ops.Alert, msg) }
+func (b *buffer) Crit(msg string) error    { return b.queue(b.ops.Crit, msg) }
+func (b *buffer) Err(msg string) error     { return b.queue(b.ops.Err, msg) }
+func (b *buffer) Warning(msg string) error { return b.queue(b.ops.Warning, msg) }
+func (b *buffer) Notice(msg string) error  { return b.queue(b.ops.Notice, msg) }
+func (b *buffer) Info(msg string) error    { return b.queue(b.ops.Info, msg) }
+func (b *buffer) Debug(msg string) error   { return b.queue(b.ops.Debug, msg) }
+func (b *buffer) Close() error             { return nil }
+
+// Fields queues msg with its fields if the underlying ops accept them, or as
+// a plain message at level otherwise.
+func (b *buffer) Fields(level Level, msg string, fields map[string]string) error {
+	if fo, ok := b.ops.(fieldsOps); ok {
+		b.msgs = append(b.msgs, func() error { return fo.Fields(level, msg, fields) })
+		return nil
+	}
+	return b.queue(levelFunc(b.ops, level), msg)
+}
+
+// flush delivers the queued messages to the underlying ops.
+func (b *buffer) flush() {
+	flushLock.Lock()
+	defer flushLock.Unlock()
+	for _, m := range b.msgs {
+		m()
+	}
+	b.msgs = nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// journalSocket is where journald accepts messages in its native protocol.
+const journalSocket = "/run/systemd/journal/socket"
+
+// errNoJournal is returned by newJournal when journald isn't running at all,
+// in which case falling back to syslog is expected rather than a problem.
+var errNoJournal = errors.New("journal socket not found")
+
+// fieldsOps is implemented by LoggerOps which accept structured fields, keyed
+// by journal field name, alongside each message.
+type fieldsOps interface {
+	Fields(level Level, msg string, fields map[string]string) error
+}
+
+// journal implements LoggerOps by sending messages to journald using its
+// native protocol, so each carries its PRIORITY and any structured fields.
+type journal struct {
+	conn *net.UnixConn
+}
+
+// newJournal connects to the journal socket at path.
+func newJournal(path string) (*journal, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, errNoJournal
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journal{conn: conn}, nil
+}
+
+func (j *journal) Emerg(msg string) error   { return j.Fields(LevelEmerg, msg, nil) }
+func (j *journal) Alert(msg string) error   { return j.Fields(LevelAlert, msg, nil) }
+func (j *journal) Crit(msg string) error    { return j.Fields(LevelCrit, msg, nil) }
+func (j *journal) Err(msg string) error     { return j.Fields(LevelErr, msg, nil) }
+func (j *journal) Warning(msg string) error { return j.Fields(LevelWarning, msg, nil) }
+func (j *journal) Notice(msg string) error  { return j.Fields(LevelNotice, msg, nil) }
+func (j *journal) Info(msg string) error    { return j.Fields(LevelInfo, msg, nil) }
+func (j *journal) Debug(msg string) error   { return j.Fields(LevelDebug, msg, nil) }
+func (j *journal) Close() error             { return j.conn.Close() }
+
+// Fields sends msg at level along with fields as a single journal entry.
+func (j *journal) Fields(level Level, msg string, fields map[string]string) error {
+	b := &bytes.Buffer{}
+	writeJournalField(b, "PRIORITY", fmt.Sprintf("%d", level))
+	writeJournalField(b, "SYSLOG_IDENTIFIER", "ignition")
+	writeJournalField(b, "MESSAGE", msg)
+	for k, v := range fields {
+		writeJournalField(b, k, v)
+	}
+	_, err := j.conn.Write(b.Bytes())
+	return err
+}
+
+// writeJournalField appends a field to b in the native protocol's encoding.
+// Values containing newlines are length-prefixed rather than newline-terminated.
+func writeJournalField(b *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", key, value)
+		return
+	}
+	b.WriteString(key)
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
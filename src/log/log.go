@@ -16,10 +16,14 @@ package log
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log/syslog"
 	"os/exec"
 	"strings"
+	"sync/atomic"
+	"syscall"
 )
 
 type LoggerOps interface {
@@ -34,69 +38,199 @@ type LoggerOps interface {
 	Close() error
 }
 
-// Logger implements a variadic flavor of log/syslog.Writer
+// Format selects how the Logger renders each message.
+type Format string
+
+const (
+	FormatText Format = "text" // the prefix stack followed by the message.
+	FormatJSON Format = "json" // a JSON object per message, see entry.
+)
+
+// Level is a message priority, ordered from most to least severe as in syslog.
+type Level int
+
+const (
+	LevelEmerg Level = iota
+	LevelAlert
+	LevelCrit
+	LevelErr
+	LevelWarning
+	LevelNotice
+	LevelInfo
+	LevelDebug
+)
+
+var levelNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+func (lv Level) String() string {
+	if lv < 0 || int(lv) >= len(levelNames) {
+		return fmt.Sprintf("level(%d)", int(lv))
+	}
+	return levelNames[lv]
+}
+
+// ParseLevel returns the Level with the supplied name.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if n == name {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unrecognized log level: %q, expected one of %v", name, levelNames)
+}
+
+// Logger implements a variadic flavor of log/syslog.Writer. The zero value
+// logs messages of LevelInfo and above to stderr.
 type Logger struct {
-	ops           LoggerOps
-	prefixStack   []string
-	opSequenceNum int
+	ops         LoggerOps
+	format      Format
+	level       Level
+	levelSet    bool
+	prefixStack []string
+	opStack     []string
+	opSequence  *uint64 // shared with Buffer copies, so concurrent ops are numbered apart
+}
+
+// entry is a single message as rendered in FormatJSON.
+type entry struct {
+	Level      string   `json:"level"`
+	Message    string   `json:"message"`
+	Prefix     []string `json:"prefix,omitempty"`
+	Command    []string `json:"command,omitempty"`
+	ExitStatus *int     `json:"exitStatus,omitempty"`
+	Stdout     string   `json:"stdout,omitempty"`
+	Stderr     string   `json:"stderr,omitempty"`
 }
 
 // New creates a new logger.
-// syslog is tried first, if syslog fails Stdout is used.
+// The journal is tried first, then syslog, and if both fail Stderr is used.
 func New() Logger {
-	logger := Logger{}
+	logger := Logger{level: LevelDebug, levelSet: true, opSequence: new(uint64)}
+	jlogger, jerr := newJournal(journalSocket)
+	if jerr == nil {
+		logger.ops = jlogger
+		return logger
+	}
 	if slogger, err := syslog.New(syslog.LOG_DEBUG, "ignition"); err == nil {
 		logger.ops = slogger
+		if jerr != errNoJournal {
+			logger.Warning("unable to open journal: %v", jerr)
+		}
 	} else {
-		logger.ops = Stdout{}
+		logger.ops = Stderr{}
+		if jerr != errNoJournal {
+			logger.Err("unable to open journal: %v", jerr)
+		}
 		logger.Err("unable to open syslog: %v", err)
 	}
 	return logger
 }
 
+// SetFormat selects how subsequent messages are rendered.
+func (l *Logger) SetFormat(format Format) error {
+	switch format {
+	case FormatText, FormatJSON:
+		l.format = format
+		return nil
+	}
+	return fmt.Errorf("unrecognized log format: %q", format)
+}
+
+// SetLevel drops subsequent messages less severe than level.
+func (l *Logger) SetLevel(level Level) {
+	l.level = level
+	l.levelSet = true
+}
+
+// threshold returns the least severe level which is logged, LevelInfo unless
+// set otherwise.
+func (l Logger) threshold() Level {
+	if !l.levelSet {
+		return LevelInfo
+	}
+	return l.level
+}
+
+// output returns where messages are delivered, stderr unless set otherwise.
+func (l Logger) output() LoggerOps {
+	if l.ops == nil {
+		return Stderr{}
+	}
+	return l.ops
+}
+
 // Close closes the logger.
 func (l Logger) Close() {
-	l.ops.Close()
+	l.output().Close()
+}
+
+// Buffer returns a copy of the logger which queues its messages until Flush is called.
+// This allows concurrent operations to each log a coherent block of messages.
+func (l *Logger) Buffer() *Logger {
+	return &Logger{
+		ops:         &buffer{ops: l.output()},
+		format:      l.format,
+		level:       l.level,
+		levelSet:    l.levelSet,
+		prefixStack: append([]string{}, l.prefixStack...),
+		opStack:     append([]string{}, l.opStack...),
+		opSequence:  l.sequence(),
+	}
+}
+
+// sequence returns the counter numbering the Logger's operations.
+func (l *Logger) sequence() *uint64 {
+	if l.opSequence == nil {
+		l.opSequence = new(uint64)
+	}
+	return l.opSequence
+}
+
+// Flush delivers any messages queued by a logger returned from Buffer.
+func (l *Logger) Flush() {
+	if b, ok := l.ops.(*buffer); ok {
+		b.flush()
+	}
 }
 
 // Emerg logs a message at emergency priority.
 func (l Logger) Emerg(format string, a ...interface{}) error {
-	return l.log(l.ops.Emerg, format, a...)
+	return l.log(LevelEmerg, format, a...)
 }
 
 // Alert logs a message at alert priority.
 func (l Logger) Alert(format string, a ...interface{}) error {
-	return l.log(l.ops.Alert, format, a...)
+	return l.log(LevelAlert, format, a...)
 }
 
 // Crit logs a message at critical priority.
 func (l Logger) Crit(format string, a ...interface{}) error {
-	return l.log(l.ops.Crit, format, a...)
+	return l.log(LevelCrit, format, a...)
 }
 
 // Err logs a message at error priority.
 func (l Logger) Err(format string, a ...interface{}) error {
-	return l.log(l.ops.Err, format, a...)
+	return l.log(LevelErr, format, a...)
 }
 
 // Warning logs a message at warning priority.
 func (l Logger) Warning(format string, a ...interface{}) error {
-	return l.log(l.ops.Warning, format, a...)
+	return l.log(LevelWarning, format, a...)
 }
 
 // Notice logs a message at notice priority.
 func (l Logger) Notice(format string, a ...interface{}) error {
-	return l.log(l.ops.Notice, format, a...)
+	return l.log(LevelNotice, format, a...)
 }
 
 // Info logs a message at info priority.
 func (l Logger) Info(format string, a ...interface{}) error {
-	return l.log(l.ops.Info, format, a...)
+	return l.log(LevelInfo, format, a...)
 }
 
 // Debug logs a message at debug priority.
 func (l Logger) Debug(format string, a ...interface{}) error {
-	return l.log(l.ops.Debug, format, a...)
+	return l.log(LevelDebug, format, a...)
 }
 
 // PushPrefix pushes the supplied message onto the Logger's prefix stack.
@@ -117,28 +251,116 @@ func (l *Logger) PopPrefix() {
 
 // LogCmd runs and logs the supplied cmd as an operation with distinct start/finish/fail log messages uniformly combined with the supplied format string.
 // The exact command path and arguments being executed are also logged for debugging assistance.
+// Output is still written to any Stdout or Stderr already set on cmd, so callers may parse it.
 func (l *Logger) LogCmd(cmd *exec.Cmd, format string, a ...interface{}) error {
 	f := func() error {
 		if len(cmd.Args) <= 1 {
-			l.Debug("executing: %v", cmd.Path)
+			l.emit(LevelDebug, entry{Message: fmt.Sprintf("executing: %v", cmd.Path), Command: cmd.Args})
 		} else {
-			l.Debug("executing: %v %v", cmd.Path, cmd.Args[1:])
+			l.emit(LevelDebug, entry{Message: fmt.Sprintf("executing: %v %v", cmd.Path, cmd.Args[1:]), Command: cmd.Args})
 		}
-		stderr := &bytes.Buffer{}
-		cmd.Stderr = stderr
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("%v: Stderr: %q", err, stderr.Bytes())
+		stdout := &tailBuffer{max: maxCmdOutput}
+		stderr := &tailBuffer{max: maxCmdOutput}
+		cmd.Stdout = tee(cmd.Stdout, stdout)
+		cmd.Stderr = tee(cmd.Stderr, stderr)
+		err := cmd.Run()
+
+		status := exitStatus(err)
+		l.emit(LevelDebug, entry{
+			Message:    fmt.Sprintf("exited with status %d", status),
+			Command:    cmd.Args,
+			ExitStatus: &status,
+			Stdout:     stdout.String(),
+			Stderr:     stderr.String(),
+		})
+		if err != nil {
+			return fmt.Errorf("%v: Stdout: %q Stderr: %q", err, stdout.String(), stderr.String())
+		}
+		if l.format != FormatJSON {
+			// the JSON entry above already carries the output
+			for _, line := range stdout.lines() {
+				l.Debug("stdout: %s", line)
+			}
+			for _, line := range stderr.lines() {
+				l.Debug("stderr: %s", line)
+			}
 		}
 		return nil
 	}
 	return l.LogOp(f, format, a...)
 }
 
+// maxCmdOutput bounds how much of each output stream LogCmd keeps per command.
+const maxCmdOutput = 64 * 1024
+
+// tailBuffer is an io.Writer keeping only the last max bytes written to it,
+// which is where tools tend to explain their failures.
+type tailBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) > t.max {
+		p = p[len(p)-t.max:]
+		t.truncated = true
+	}
+	if over := t.buf.Len() + len(p) - t.max; over > 0 {
+		t.buf.Next(over)
+		t.truncated = true
+	}
+	t.buf.Write(p)
+	return n, nil
+}
+
+func (t *tailBuffer) String() string {
+	if t.truncated {
+		return "[truncated]..." + t.buf.String()
+	}
+	return t.buf.String()
+}
+
+// lines returns the non-empty lines of the buffered output.
+func (t *tailBuffer) lines() []string {
+	lines := []string{}
+	for _, line := range strings.Split(t.String(), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// tee returns a writer copying to both w, if set, and buf.
+func tee(w io.Writer, buf *tailBuffer) io.Writer {
+	if w == nil {
+		return buf
+	}
+	return io.MultiWriter(w, buf)
+}
+
+// exitStatus returns the exit status of a command which returned err from Run,
+// or -1 if it didn't exit normally.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
 // LogOp calls and logs the supplied function as an operation with distinct start/finish/fail log messages uniformly combined with the supplied format string.
 func (l *Logger) LogOp(op func() error, format string, a ...interface{}) error {
-	l.opSequenceNum++
-	l.PushPrefix("op(%x)", l.opSequenceNum)
+	l.PushPrefix("op(%x)", atomic.AddUint64(l.sequence(), 1))
 	defer l.PopPrefix()
+	l.opStack = append(l.opStack, fmt.Sprintf(format, a...))
+	defer func() { l.opStack = l.opStack[:len(l.opStack)-1] }()
 
 	l.logStart(format, a...)
 	if err := op(); err != nil {
@@ -151,7 +373,7 @@ func (l *Logger) LogOp(op func() error, format string, a ...interface{}) error {
 
 // logStart logs the start of a multi-step/substantial/time-consuming operation.
 func (l Logger) logStart(format string, a ...interface{}) {
-	l.Info(fmt.Sprintf("[started]  %s", format), a...)
+	l.Debug(fmt.Sprintf("[started]  %s", format), a...)
 }
 
 // logFail logs the failure of a multi-step/substantial/time-consuming operation.
@@ -161,12 +383,74 @@ func (l Logger) logFail(format string, a ...interface{}) {
 
 // logFinish logs the completion of a multi-step/substantial/time-consuming operation.
 func (l Logger) logFinish(format string, a ...interface{}) {
-	l.Info(fmt.Sprintf("[finished] %s", format), a...)
+	l.Debug(fmt.Sprintf("[finished] %s", format), a...)
+}
+
+// log logs a formatted message at level.
+func (l Logger) log(level Level, format string, a ...interface{}) error {
+	return l.emit(level, entry{Message: fmt.Sprintf(format, a...)})
+}
+
+// emit renders e at level according to the Logger's format and delivers it
+// to the output's function for level, unless level is below the Logger's
+// threshold.
+func (l Logger) emit(level Level, e entry) error {
+	if level > l.threshold() {
+		return nil
+	}
+	e.Level = level.String()
+
+	msg := ""
+	if l.format != FormatJSON {
+		msg = l.sprintf("%s", e.Message)
+	} else {
+		e.Prefix = l.prefixStack
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		msg = string(b)
+	}
+
+	ops := l.output()
+	if fo, ok := ops.(fieldsOps); ok {
+		return fo.Fields(level, msg, l.fields())
+	}
+	return levelFunc(ops, level)(msg)
 }
 
-// log logs a formatted message using the supplied logFunc.
-func (l Logger) log(logFunc func(string) error, format string, a ...interface{}) error {
-	return logFunc(l.sprintf(format, a...))
+// levelFunc returns the function of ops delivering messages of level.
+func levelFunc(ops LoggerOps, level Level) func(string) error {
+	switch level {
+	case LevelEmerg:
+		return ops.Emerg
+	case LevelAlert:
+		return ops.Alert
+	case LevelCrit:
+		return ops.Crit
+	case LevelErr:
+		return ops.Err
+	case LevelWarning:
+		return ops.Warning
+	case LevelNotice:
+		return ops.Notice
+	case LevelInfo:
+		return ops.Info
+	default:
+		return ops.Debug
+	}
+}
+
+// fields returns the structured fields describing the Logger's current context.
+func (l Logger) fields() map[string]string {
+	fields := map[string]string{}
+	if len(l.prefixStack) != 0 {
+		fields["IGNITION_PREFIX"] = strings.Join(l.prefixStack, ": ")
+	}
+	if len(l.opStack) != 0 {
+		fields["IGNITION_OPERATION"] = l.opStack[len(l.opStack)-1]
+	}
+	return fields
 }
 
 // sprintf returns the current prefix stack, if any, concatenated with the supplied format string and args in expanded form.
@@ -0,0 +1,285 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// recorder implements LoggerOps by recording the messages it's given.
+type recorder struct {
+	Stdout
+	msgs []string
+}
+
+func (r *recorder) Crit(msg string) error  { r.msgs = append(r.msgs, msg); return nil }
+func (r *recorder) Info(msg string) error  { r.msgs = append(r.msgs, msg); return nil }
+func (r *recorder) Debug(msg string) error { r.msgs = append(r.msgs, msg); return nil }
+
+// entries decodes the messages recorded in FormatJSON.
+func (r *recorder) entries(t *testing.T) []entry {
+	entries := []entry{}
+	for _, msg := range r.msgs {
+		var e entry
+		if err := json.Unmarshal([]byte(msg), &e); err != nil {
+			t.Fatalf("bad JSON %q: %v", msg, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestLoggerThreshold(t *testing.T) {
+	type in struct {
+		level *Level
+	}
+	type out struct {
+		msgs []string
+	}
+
+	debug := LevelDebug
+	notice := LevelNotice
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{},
+			out: out{msgs: []string{"info"}},
+		},
+		{
+			in:  in{level: &debug},
+			out: out{msgs: []string{"info", "debug"}},
+		},
+		{
+			in:  in{level: &notice},
+			out: out{msgs: nil},
+		},
+	}
+
+	for i, test := range tests {
+		r := &recorder{}
+		l := Logger{ops: r}
+		if test.in.level != nil {
+			l.SetLevel(*test.in.level)
+		}
+		l.Info("info")
+		l.Debug("debug")
+		if !reflect.DeepEqual(test.out.msgs, r.msgs) {
+			t.Errorf("#%d: bad messages: want %q, got %q", i, test.out.msgs, r.msgs)
+		}
+	}
+}
+
+func TestLogOpSequence(t *testing.T) {
+	l := Logger{ops: Stdout{}}
+	buffers := []*Logger{l.Buffer(), l.Buffer(), l.Buffer(), l.Buffer()}
+
+	var wg sync.WaitGroup
+	prefixes := make([]string, len(buffers))
+	for i, b := range buffers {
+		wg.Add(1)
+		go func(i int, b *Logger) {
+			defer wg.Done()
+			b.LogOp(func() error {
+				prefixes[i] = b.prefixStack[len(b.prefixStack)-1]
+				return nil
+			}, "op %d", i)
+		}(i, b)
+	}
+	wg.Wait()
+
+	seen := map[string]bool{}
+	for _, p := range prefixes {
+		if seen[p] {
+			t.Errorf("operation prefix %q used twice: %q", p, prefixes)
+		}
+		seen[p] = true
+	}
+}
+
+func TestLogCmdOutput(t *testing.T) {
+	l := Logger{ops: Stdout{}}
+	var out bytes.Buffer
+	cmd := exec.Command("echo", "hello")
+	cmd.Stdout = &out
+	if err := l.LogCmd(cmd, "echoing"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.String() != "hello\n" {
+		t.Errorf("bad output: want %q, got %q", "hello\n", out.String())
+	}
+}
+
+func TestNewJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ignition-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "socket")
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	type in struct {
+		path string
+	}
+	type out struct {
+		ok      bool
+		missing bool
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{path: socket},
+			out: out{ok: true},
+		},
+		{
+			in:  in{path: filepath.Join(dir, "missing")},
+			out: out{missing: true},
+		},
+		{
+			in:  in{path: file},
+			out: out{},
+		},
+	}
+
+	for i, test := range tests {
+		j, err := newJournal(test.in.path)
+		if ok := err == nil; ok != test.out.ok {
+			t.Errorf("#%d: bad result: want %t, got %v", i, test.out.ok, err)
+		}
+		if missing := err == errNoJournal; missing != test.out.missing {
+			t.Errorf("#%d: bad missing: want %t, got %v", i, test.out.missing, err)
+		}
+		if j != nil {
+			j.Close()
+		}
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	type in struct {
+		log func(l *Logger)
+	}
+	type out struct {
+		entries []entry
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in: in{log: func(l *Logger) { l.Info("hello %s", "world") }},
+			out: out{entries: []entry{
+				{Level: "info", Message: "hello world"},
+			}},
+		},
+		{
+			in: in{log: func(l *Logger) {
+				l.PushPrefix("files")
+				l.LogOp(func() error { return nil }, "writing %q", "/etc/motd")
+			}},
+			out: out{entries: []entry{
+				{Level: "debug", Message: `[started]  writing "/etc/motd"`, Prefix: []string{"files", "op(1)"}},
+				{Level: "debug", Message: `[finished] writing "/etc/motd"`, Prefix: []string{"files", "op(1)"}},
+			}},
+		},
+		{
+			in: in{log: func(l *Logger) {
+				l.LogOp(func() error { return errors.New("no space") }, "writing %q", "/etc/motd")
+			}},
+			out: out{entries: []entry{
+				{Level: "debug", Message: `[started]  writing "/etc/motd"`, Prefix: []string{"op(1)"}},
+				{Level: "crit", Message: `[failed]   writing "/etc/motd": no space`, Prefix: []string{"op(1)"}},
+			}},
+		},
+	}
+
+	for i, test := range tests {
+		r := &recorder{}
+		l := Logger{ops: r}
+		l.SetLevel(LevelDebug)
+		if err := l.SetFormat(FormatJSON); err != nil {
+			t.Fatal(err)
+		}
+		test.in.log(&l)
+		if entries := r.entries(t); !reflect.DeepEqual(test.out.entries, entries) {
+			t.Errorf("#%d: bad entries: want %+v, got %+v", i, test.out.entries, entries)
+		}
+	}
+}
+
+func TestFormatJSONLogCmd(t *testing.T) {
+	r := &recorder{}
+	l := Logger{ops: r}
+	l.SetLevel(LevelDebug)
+	if err := l.SetFormat(FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"sh", "-c", "echo out; echo err >&2; exit 3"}
+	if err := l.LogCmd(exec.Command(args[0], args[1:]...), "failing"); err == nil {
+		t.Fatalf("failing command succeeded")
+	}
+
+	entries := r.entries(t)
+	var exited *entry
+	for i := range entries {
+		if entries[i].ExitStatus != nil {
+			exited = &entries[i]
+		}
+	}
+	if exited == nil {
+		t.Fatalf("no entry with an exit status in %+v", entries)
+	}
+	status := 3
+	want := entry{
+		Level:      "debug",
+		Message:    "exited with status 3",
+		Prefix:     []string{"op(1)"},
+		Command:    args,
+		ExitStatus: &status,
+		Stdout:     "out\n",
+		Stderr:     "err\n",
+	}
+	if !reflect.DeepEqual(want, *exited) {
+		t.Errorf("bad exit entry: want %+v, got %+v", want, *exited)
+	}
+	if last := entries[len(entries)-1]; last.Level != "crit" {
+		t.Errorf("bad level of the failure: want %q, got %q", "crit", last.Level)
+	}
+}
@@ -16,6 +16,7 @@ package log
 
 import (
 	"fmt"
+	"os"
 )
 
 type Stdout struct{}
@@ -29,3 +30,21 @@ func (Stdout) Notice(msg string) error  { fmt.Println("NOTICE   :", msg); return
 func (Stdout) Info(msg string) error    { fmt.Println("INFO     :", msg); return nil }
 func (Stdout) Debug(msg string) error   { fmt.Println("DEBUG    :", msg); return nil }
 func (Stdout) Close() error             { return nil }
+
+type Stderr struct{}
+
+func (Stderr) Emerg(msg string) error   { fmt.Fprintln(os.Stderr, "EMERGENCY:", msg); return nil }
+func (Stderr) Alert(msg string) error   { fmt.Fprintln(os.Stderr, "ALERT    :", msg); return nil }
+func (Stderr) Crit(msg string) error    { fmt.Fprintln(os.Stderr, "CRITICAL :", msg); return nil }
+func (Stderr) Err(msg string) error     { fmt.Fprintln(os.Stderr, "ERROR    :", msg); return nil }
+func (Stderr) Warning(msg string) error { fmt.Fprintln(os.Stderr, "WARNING  :", msg); return nil }
+func (Stderr) Notice(msg string) error  { fmt.Fprintln(os.Stderr, "NOTICE   :", msg); return nil }
+func (Stderr) Info(msg string) error    { fmt.Fprintln(os.Stderr, "INFO     :", msg); return nil }
+func (Stderr) Debug(msg string) error   { fmt.Fprintln(os.Stderr, "DEBUG    :", msg); return nil }
+func (Stderr) Close() error             { return nil }
+
+// NewTest creates a logger for tests, which logs everything to stderr rather
+// than to the journal of the machine running them.
+func NewTest() Logger {
+	return Logger{ops: Stderr{}, level: LevelDebug, levelSet: true, opSequence: new(uint64)}
+}
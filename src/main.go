@@ -18,17 +18,22 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/coreos/ignition/src/exec"
 	"github.com/coreos/ignition/src/exec/stages"
+	_ "github.com/coreos/ignition/src/exec/stages/files"
 	_ "github.com/coreos/ignition/src/exec/stages/prepivot"
 	_ "github.com/coreos/ignition/src/exec/stages/storage"
 	"github.com/coreos/ignition/src/log"
 	"github.com/coreos/ignition/src/oem"
 	"github.com/coreos/ignition/src/providers"
 	_ "github.com/coreos/ignition/src/providers/cmdline"
+	_ "github.com/coreos/ignition/src/providers/ec2"
 	_ "github.com/coreos/ignition/src/providers/file"
+	_ "github.com/coreos/ignition/src/providers/gce"
+	_ "github.com/coreos/ignition/src/providers/openstack"
 
 	"github.com/coreos/ignition/third_party/github.com/coreos/go-semver/semver"
 )
@@ -41,21 +46,63 @@ func main() {
 	flags := struct {
 		clearCache   bool
 		configCache  string
+		configFile   string
+		daemonReload bool
+		deactivateVG bool
+		devTimeout   time.Duration
+		dryRun       bool
 		fetchTimeout time.Duration
+		fileTimeout  time.Duration
+		fileRetry    exec.RetryOptions
+		force        bool
+		linkUnits    bool
+		logFormat    string
+		logLevel     string
 		oem          oem.Name
+		opTimeout    time.Duration
+		partAttempts int
 		providers    providers.List
+		provTimeout  time.Duration
+		resultFile   string
 		root         string
+		sentinelDir  string
 		stage        stages.Name
+		stopArrays   bool
+		strict       bool
+		systemctl    bool
+		verify       bool
 		version      bool
 	}{}
 
 	flag.BoolVar(&flags.clearCache, "clear-cache", false, "clear any cached config")
 	flag.StringVar(&flags.configCache, "config-cache", "/tmp/ignition.json", "where to cache the config")
+	flag.StringVar(&flags.configFile, "config-file", providers.DefaultConfigFile, "where the file provider reads the config")
+	flag.BoolVar(&flags.daemonReload, "daemon-reload", false, "reload systemd after writing units, when it is running")
+	flag.BoolVar(&flags.deactivateVG, "deactivate-volume-groups", false, "deactivate all lvm volume groups on the host before partitioning")
+	flag.DurationVar(&flags.devTimeout, "device-timeout", exec.DefaultDeviceTimeout, "how long to wait for storage devices to appear. 0 waits forever")
+	flag.BoolVar(&flags.dryRun, "dry-run", false, "log the actions which would be performed without performing them")
 	flag.DurationVar(&flags.fetchTimeout, "fetchtimeout", exec.DefaultFetchTimeout, "")
+	flag.DurationVar(&flags.fileTimeout, "file-fetch-timeout", exec.DefaultFileFetchTimeout, "total timeout for fetching remote file contents")
+	flag.IntVar(&flags.fileRetry.Attempts, "file-fetch-attempts", exec.DefaultFileFetchAttempts, "maximum attempts at fetching remote file contents")
+	flag.DurationVar(&flags.fileRetry.Backoff, "file-fetch-backoff", exec.DefaultFileFetchBackoff, "initial delay between remote file fetch attempts")
+	flag.DurationVar(&flags.fileRetry.MaxBackoff, "file-fetch-max-backoff", exec.DefaultFileFetchMaxBackoff, "maximum delay between remote file fetch attempts")
+	flag.BoolVar(&flags.force, "force", false, "run the stage even if it already ran with the same config")
+	flag.BoolVar(&flags.linkUnits, "link-units", false, "enable units by linking them into the targets named by their [Install] section rather than through presets")
+	flag.StringVar(&flags.logFormat, "log-format", string(log.FormatText), fmt.Sprintf("format of log messages. %v", []log.Format{log.FormatText, log.FormatJSON}))
+	flag.StringVar(&flags.logLevel, "log-level", log.LevelInfo.String(), "least severe level of log messages to emit")
 	flag.Var(&flags.oem, "oem", fmt.Sprintf("current oem. %v", oem.Names()))
+	flag.DurationVar(&flags.opTimeout, "op-timeout", exec.DefaultOpTimeout, "how long a storage operation such as mkfs or mount may take. 0 waits forever")
+	flag.IntVar(&flags.partAttempts, "partition-attempts", exec.DefaultPartitionAttempts, "attempts at each partitioning command which fails because the disk is busy")
 	flag.Var(&flags.providers, "provider", fmt.Sprintf("provider of config. can be specified multiple times. %v", providers.Names()))
-	flag.StringVar(&flags.root, "root", "/", "root of the filesystem")
+	flag.DurationVar(&flags.provTimeout, "provider-timeout", 0, "try the providers one at a time, in the order given, waiting this long for each. 0 waits for all of them at once")
+	flag.StringVar(&flags.resultFile, "result-file", exec.DefaultResultPath, "where a JSON summary of the actions taken is written, beneath the root. empty disables the summary")
+	flag.StringVar(&flags.root, "root", "/", "root of the filesystem to provision, such as an image being built in a chroot. must be an existing directory")
+	flag.StringVar(&flags.sentinelDir, "sentinel-dir", exec.DefaultSentinelDir, "where successful storage runs are recorded, beneath the root. empty disables recording")
 	flag.Var(&flags.stage, "stage", fmt.Sprintf("execution stage. %v", stages.Names()))
+	flag.BoolVar(&flags.stopArrays, "stop-arrays", false, "stop all raid arrays on the host before partitioning, releasing reused disks")
+	flag.BoolVar(&flags.strict, "strict", false, "reject configs containing unknown keys")
+	flag.BoolVar(&flags.systemctl, "systemctl-units", false, "enable units with systemctl --root, which understands Alias= and the rest of [Install], when it's available")
+	flag.BoolVar(&flags.verify, "verify-sources", false, "fetch the config's remote file sources and check them against their hashes, then exit without provisioning")
 	flag.BoolVar(&flags.version, "version", false, "print the version and exit")
 
 	flag.Parse()
@@ -71,14 +118,41 @@ func main() {
 		return
 	}
 
-	if flags.stage == "" {
+	if flags.stage == "" && !flags.verify {
 		fmt.Fprint(os.Stderr, "'--stage' must be provided\n")
 		os.Exit(2)
 	}
 
+	// the stages write beneath the root, and some hand it to tools which
+	// don't take relative paths
+	root, err := filepath.Abs(flags.root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid '--root': %v\n", err)
+		os.Exit(2)
+	}
+	if info, err := os.Stat(root); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid '--root': %v\n", err)
+		os.Exit(2)
+	} else if !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "invalid '--root': %q is not a directory\n", root)
+		os.Exit(2)
+	}
+	flags.root = root
+
 	logger := log.New()
 	defer logger.Close()
 
+	if err := logger.SetFormat(log.Format(flags.logFormat)); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	level, err := log.ParseLevel(flags.logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	logger.SetLevel(level)
+
 	if flags.clearCache {
 		if err := os.Remove(flags.configCache); err != nil {
 			logger.Err("unable to clear cache: %v", err)
@@ -86,13 +160,38 @@ func main() {
 	}
 
 	engine := exec.Engine{
-		Root:         flags.root,
-		FetchTimeout: flags.fetchTimeout,
-		Logger:       logger,
-		ConfigCache:  flags.configCache,
+		Root:              flags.root,
+		DaemonReload:      flags.daemonReload,
+		DeactivateGroups:  flags.deactivateVG,
+		DeviceTimeout:     flags.devTimeout,
+		DryRun:            flags.dryRun,
+		FetchTimeout:      flags.fetchTimeout,
+		FileFetchTimeout:  flags.fileTimeout,
+		FileFetchRetry:    flags.fileRetry,
+		Force:             flags.force,
+		LinkUnits:         flags.linkUnits,
+		OpTimeout:         flags.opTimeout,
+		PartitionAttempts: flags.partAttempts,
+		ProviderTimeout:   flags.provTimeout,
+		ResultPath:        flags.resultFile,
+		SentinelDir:       flags.sentinelDir,
+		StopArrays:        flags.stopArrays,
+		SystemctlUnits:    flags.systemctl,
+		Logger:            logger,
+		ConfigCache:       flags.configCache,
 	}.Init()
 	for _, name := range flags.providers {
-		engine.AddProvider(providers.Get(name).Create(logger))
+		engine.AddProvider(providers.Get(name).Create(logger, providers.Options{
+			ConfigFile:   flags.configFile,
+			StrictConfig: flags.strict,
+		}))
+	}
+
+	if flags.verify {
+		if !engine.VerifySources() {
+			os.Exit(1)
+		}
+		return
 	}
 
 	if !engine.Run(flags.stage.String()) {
@@ -35,6 +35,24 @@ func (c Config) Flags() map[string]string {
 var configs = registry.Create("oem configs")
 
 func init() {
+	configs.Register(Config{
+		name: "ec2",
+		flags: map[string]string{
+			"provider": "ec2",
+		},
+	})
+	configs.Register(Config{
+		name: "gce",
+		flags: map[string]string{
+			"provider": "gce",
+		},
+	})
+	configs.Register(Config{
+		name: "openstack",
+		flags: map[string]string{
+			"provider": "openstack",
+		},
+	})
 	configs.Register(Config{
 		name: "pxe",
 		flags: map[string]string{
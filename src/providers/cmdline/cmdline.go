@@ -13,13 +13,16 @@
 // limitations under the License.
 
 // The cmdline provider fetches a remote configuration from the URL specified
-// in the kernel boot option "coreos.config.url".
+// in the kernel boot option "ignition.config.url", or the older
+// "coreos.config.url".
 
 package cmdline
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -34,7 +37,8 @@ const (
 	initialBackoff = 100 * time.Millisecond
 	maxBackoff     = 30 * time.Second
 	cmdlinePath    = "/proc/cmdline"
-	cmdlineUrlFlag = "coreos.config.url"
+	cmdlineUrlFlag = "ignition.config.url"
+	oldUrlFlag     = "coreos.config.url"
 )
 
 func init() {
@@ -47,12 +51,13 @@ func (creator) Name() string {
 	return name
 }
 
-func (creator) Create(logger log.Logger) providers.Provider {
+func (creator) Create(logger log.Logger, opts providers.Options) providers.Provider {
 	return &provider{
 		logger:  logger,
 		backoff: initialBackoff,
 		path:    cmdlinePath,
-		client:  &http.Client{},
+		client:  util.NewHttpClient(),
+		strict:  opts.StrictConfig,
 	}
 }
 
@@ -64,6 +69,7 @@ type provider struct {
 	client      *http.Client
 	configUrl   string
 	rawConfig   []byte
+	strict      bool
 }
 
 func (provider) Name() string {
@@ -71,7 +77,7 @@ func (provider) Name() string {
 }
 
 func (p provider) FetchConfig() (config.Config, error) {
-	return config.Parse(p.rawConfig)
+	return providers.ParseConfig(p.rawConfig, p.strict)
 }
 
 func (p *provider) IsOnline() bool {
@@ -84,7 +90,11 @@ func (p *provider) IsOnline() bool {
 			return false
 		}
 
-		p.configUrl = parseCmdline(args)
+		if p.configUrl, err = parseCmdline(args); err != nil {
+			p.logger.Err("%v", err)
+			p.shouldRetry = false
+			return false
+		}
 		p.logger.Debug("parsed url from cmdline: %q", p.configUrl)
 		if p.configUrl == "" {
 			p.shouldRetry = false
@@ -123,19 +133,30 @@ func (p *provider) BackoffDuration() time.Duration {
 	return util.ExpBackoff(&p.backoff, maxBackoff)
 }
 
-func parseCmdline(cmdline []byte) (url string) {
-	for _, arg := range strings.Split(string(cmdline), " ") {
-		parts := strings.SplitN(strings.TrimSpace(arg), "=", 2)
+// parseCmdline returns the config URL given on the kernel command line, or
+// an empty string if there is none. The last occurrence of the option wins.
+func parseCmdline(cmdline []byte) (string, error) {
+	configUrl := ""
+	for _, arg := range strings.Fields(string(cmdline)) {
+		parts := strings.SplitN(arg, "=", 2)
 		key := parts[0]
 
-		if key != cmdlineUrlFlag {
+		if key != cmdlineUrlFlag && key != oldUrlFlag {
 			continue
 		}
 
-		if len(parts) == 2 {
-			url = parts[1]
+		if len(parts) != 2 || parts[1] == "" {
+			return "", fmt.Errorf("malformed %s: no url given", key)
+		}
+		u, err := url.Parse(parts[1])
+		if err != nil {
+			return "", fmt.Errorf("malformed %s: %v", key, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return "", fmt.Errorf("malformed %s: unsupported scheme %q in %q", key, u.Scheme, parts[1])
 		}
+		configUrl = parts[1]
 	}
 
-	return
+	return configUrl, nil
 }
@@ -0,0 +1,128 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdline
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/ignition/src/log"
+)
+
+func TestParseCmdline(t *testing.T) {
+	type in struct {
+		cmdline string
+	}
+	type out struct {
+		url string
+		err error
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{cmdline: "BOOT_IMAGE=/vmlinuz console=ttyS0 ignition.config.url=http://example.com/config.json\n"},
+			out: out{url: "http://example.com/config.json"},
+		},
+		{
+			in:  in{cmdline: "coreos.config.url=https://example.com/config.json quiet"},
+			out: out{url: "https://example.com/config.json"},
+		},
+		{
+			in:  in{cmdline: "console=ttyS0 rd.shell"},
+			out: out{},
+		},
+		{
+			in:  in{cmdline: "ignition.config.url"},
+			out: out{err: errors.New("malformed ignition.config.url: no url given")},
+		},
+		{
+			in:  in{cmdline: "ignition.config.url=ftp://example.com/config.json"},
+			out: out{err: errors.New(`malformed ignition.config.url: unsupported scheme "ftp" in "ftp://example.com/config.json"`)},
+		},
+	}
+
+	for i, test := range tests {
+		url, err := parseCmdline([]byte(test.in.cmdline))
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+		if test.out.url != url {
+			t.Errorf("#%d: bad url: want %q, got %q", i, test.out.url, url)
+		}
+	}
+}
+
+func TestIsOnline(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.json":
+			fmt.Fprint(w, `{"ignitionVersion": 1}`)
+		case "/hang":
+			<-block
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer close(block)
+
+	type in struct {
+		path string
+	}
+	type out struct {
+		online    bool
+		rawConfig string
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{in: in{path: "/config.json"}, out: out{online: true, rawConfig: `{"ignitionVersion": 1}`}},
+		{in: in{path: "/missing"}, out: out{online: false}},
+		{in: in{path: "/hang"}, out: out{online: false}},
+	}
+
+	for i, test := range tests {
+		cmdline, err := ioutil.TempFile("", "ignition-cmdline")
+		if err != nil {
+			t.Fatalf("failed to create cmdline: %v", err)
+		}
+		defer os.Remove(cmdline.Name())
+		fmt.Fprintf(cmdline, "console=ttyS0 ignition.config.url=%s%s\n", server.URL, test.in.path)
+		cmdline.Close()
+
+		p := provider{
+			logger: log.NewTest(),
+			path:   cmdline.Name(),
+			client: &http.Client{Timeout: 100 * time.Millisecond},
+		}
+		online := p.IsOnline()
+		if got := (out{online: online, rawConfig: string(p.rawConfig)}); !reflect.DeepEqual(test.out, got) {
+			t.Errorf("#%d: bad result: want %+v, got %+v", i, test.out, got)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The ec2 provider fetches a remote configuration from the EC2 user-data
+// metadata service.
+
+package ec2
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/log"
+	"github.com/coreos/ignition/src/providers"
+	"github.com/coreos/ignition/src/providers/util"
+)
+
+const (
+	name           = "ec2"
+	initialBackoff = 100 * time.Millisecond
+	maxBackoff     = 30 * time.Second
+	userdataUrl    = "http://169.254.169.254/latest/user-data"
+	metadataUrl    = "http://169.254.169.254/latest/meta-data/"
+)
+
+// metadataPaths maps the metadata keys exposed to file templates to where
+// the metadata service serves them.
+var metadataPaths = map[string]string{
+	"instance_id":       "instance-id",
+	"instance_type":     "instance-type",
+	"availability_zone": "placement/availability-zone",
+	"hostname":          "local-hostname",
+	"local_ipv4":        "local-ipv4",
+	"public_hostname":   "public-hostname",
+	"public_ipv4":       "public-ipv4",
+}
+
+func init() {
+	providers.Register(creator{})
+}
+
+type creator struct{}
+
+func (creator) Name() string {
+	return name
+}
+
+func (creator) Create(logger log.Logger, opts providers.Options) providers.Provider {
+	return &provider{
+		logger:      logger,
+		backoff:     initialBackoff,
+		shouldRetry: true,
+		client:      util.NewHttpClient(),
+		userdataUrl: userdataUrl,
+		metadataUrl: metadataUrl,
+		strict:      opts.StrictConfig,
+	}
+}
+
+type provider struct {
+	logger      log.Logger
+	backoff     time.Duration
+	shouldRetry bool
+	client      *http.Client
+	userdataUrl string // overridden for testing, as is metadataUrl.
+	metadataUrl string
+	rawConfig   []byte
+	strict      bool
+}
+
+func (provider) Name() string {
+	return name
+}
+
+func (p provider) FetchConfig() (config.Config, error) {
+	return providers.ParseConfig(p.rawConfig, p.strict)
+}
+
+func (p *provider) IsOnline() bool {
+	resp, err := p.client.Get(p.userdataUrl)
+	if err != nil {
+		// the metadata service may not be reachable yet
+		p.logger.Warning("failed fetching: %v", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		p.logger.Info("no user-data found")
+		p.shouldRetry = false
+		return false
+	default:
+		p.logger.Debug("failed fetching: HTTP status: %s", resp.Status)
+		return false
+	}
+
+	p.logger.Debug("successfully fetched")
+	if p.rawConfig, err = ioutil.ReadAll(resp.Body); err != nil {
+		p.logger.Err("failed to read body: %v", err)
+		return false
+	}
+	return true
+}
+
+// Metadata returns what the metadata service knows of the instance. Values
+// the instance lacks, such as a public address, are left out. The region is
+// derived from the availability zone.
+func (p provider) Metadata() (map[string]string, error) {
+	metadata := map[string]string{}
+	for key, path := range metadataPaths {
+		value, ok, err := p.fetchMetadata(path)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			metadata[key] = value
+		}
+	}
+	if zone := metadata["availability_zone"]; zone != "" {
+		metadata["region"] = zone[:len(zone)-1]
+	}
+	return metadata, nil
+}
+
+func (p provider) fetchMetadata(path string) (string, bool, error) {
+	resp, err := p.client.Get(p.metadataUrl + path)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", false, nil
+	default:
+		return "", false, fmt.Errorf("failed fetching %q: HTTP status: %s", path, resp.Status)
+	}
+
+	value, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(string(value)), true, nil
+}
+
+func (p provider) ShouldRetry() bool {
+	return p.shouldRetry
+}
+
+func (p *provider) BackoffDuration() time.Duration {
+	return util.ExpBackoff(&p.backoff, maxBackoff)
+}
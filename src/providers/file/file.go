@@ -12,13 +12,14 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// The file provider reads the configuration from a file in the current
-// working directory by the name "config.json".
+// The file provider reads the configuration from a local file, by default
+// providers.DefaultConfigFile.
 
 package file
 
 import (
 	"io/ioutil"
+	"os"
 	"time"
 
 	"github.com/coreos/ignition/config"
@@ -29,7 +30,6 @@ import (
 
 const (
 	name           = "file"
-	fileName       = "config.json"
 	initialBackoff = 100 * time.Millisecond
 	maxBackoff     = 30 * time.Second
 )
@@ -44,18 +44,27 @@ func (creator) Name() string {
 	return name
 }
 
-func (creator) Create(logger log.Logger) providers.Provider {
+func (creator) Create(logger log.Logger, opts providers.Options) providers.Provider {
+	path := opts.ConfigFile
+	if path == "" {
+		path = providers.DefaultConfigFile
+	}
 	return &provider{
-		logger:  logger,
-		backoff: initialBackoff,
+		logger:      logger,
+		backoff:     initialBackoff,
+		path:        path,
+		shouldRetry: true,
+		strict:      opts.StrictConfig,
 	}
 }
 
 type provider struct {
 	backoff     time.Duration
 	logger      log.Logger
+	path        string
 	rawConfig   []byte
 	shouldRetry bool
+	strict      bool
 }
 
 func (provider) Name() string {
@@ -63,14 +72,23 @@ func (provider) Name() string {
 }
 
 func (p provider) FetchConfig() (config.Config, error) {
-	return config.Parse(p.rawConfig)
+	cfg, err := providers.ParseConfig(p.rawConfig, p.strict)
+	if err != nil {
+		p.logger.Err("couldn't parse config %q: %v", p.path, err)
+	}
+	return cfg, err
 }
 
 func (p *provider) IsOnline() bool {
 	var err error
-	p.rawConfig, err = ioutil.ReadFile(fileName)
-	if err != nil {
-		p.logger.Err("couldn't read config %q: %v", fileName, err)
+	p.rawConfig, err = ioutil.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		// the file isn't going to appear by itself
+		p.logger.Err("config %q not found", p.path)
+		p.shouldRetry = false
+		return false
+	} else if err != nil {
+		p.logger.Err("couldn't read config %q: %v", p.path, err)
 		return false
 	}
 
@@ -78,7 +96,7 @@ func (p *provider) IsOnline() bool {
 }
 
 func (p provider) ShouldRetry() bool {
-	return true
+	return p.shouldRetry
 }
 
 func (p *provider) BackoffDuration() time.Duration {
@@ -0,0 +1,188 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The gce provider fetches a remote configuration from the user-data
+// attribute of the GCE metadata server.
+
+package gce
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/log"
+	"github.com/coreos/ignition/src/providers"
+	"github.com/coreos/ignition/src/providers/util"
+)
+
+const (
+	name           = "gce"
+	initialBackoff = 100 * time.Millisecond
+	maxBackoff     = 30 * time.Second
+	metadataUrl    = "http://metadata.google.internal/computeMetadata/v1/"
+	userdataPath   = "instance/attributes/user-data"
+)
+
+// metadataPaths maps the metadata keys exposed to file templates to where
+// the metadata server serves them.
+var metadataPaths = map[string]string{
+	"instance_id":  "instance/id",
+	"machine_type": "instance/machine-type",
+	"zone":         "instance/zone",
+	"hostname":     "instance/hostname",
+	"local_ipv4":   "instance/network-interfaces/0/ip",
+	"public_ipv4":  "instance/network-interfaces/0/access-configs/0/external-ip",
+	"project_id":   "project/project-id",
+}
+
+func init() {
+	providers.Register(creator{})
+}
+
+type creator struct{}
+
+func (creator) Name() string {
+	return name
+}
+
+func (creator) Create(logger log.Logger, opts providers.Options) providers.Provider {
+	return &provider{
+		logger:      logger,
+		backoff:     initialBackoff,
+		shouldRetry: true,
+		client:      util.NewHttpClient(),
+		url:         metadataUrl,
+		strict:      opts.StrictConfig,
+	}
+}
+
+type provider struct {
+	logger      log.Logger
+	backoff     time.Duration
+	shouldRetry bool
+	client      *http.Client
+	url         string // the metadata server, overridden for testing.
+	rawConfig   []byte
+	strict      bool
+}
+
+func (provider) Name() string {
+	return name
+}
+
+func (p provider) FetchConfig() (config.Config, error) {
+	return providers.ParseConfig(p.rawConfig, p.strict)
+}
+
+func (p *provider) IsOnline() bool {
+	resp, err := p.get(userdataPath)
+	if err != nil {
+		// the metadata server may not be reachable yet
+		p.logger.Warning("failed fetching: %v", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		p.logger.Info("no user-data attribute found")
+		p.shouldRetry = false
+		return false
+	default:
+		// the metadata server answers 503 while it's starting up
+		p.logger.Debug("failed fetching: HTTP status: %s", resp.Status)
+		return false
+	}
+
+	p.logger.Debug("successfully fetched")
+	if p.rawConfig, err = ioutil.ReadAll(resp.Body); err != nil {
+		p.logger.Err("failed to read body: %v", err)
+		return false
+	}
+	return true
+}
+
+// Metadata returns what the metadata server knows of the instance. Values
+// the instance lacks, such as a public address, are left out. The zone and
+// machine type are reduced to their names, and the region is derived from
+// the zone.
+func (p provider) Metadata() (map[string]string, error) {
+	metadata := map[string]string{}
+	for key, path := range metadataPaths {
+		value, ok, err := p.fetchMetadata(path)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			metadata[key] = value
+		}
+	}
+	for _, key := range []string{"zone", "machine_type"} {
+		if value, ok := metadata[key]; ok {
+			// served as projects/<number>/zones/<zone> and so on
+			metadata[key] = path.Base(value)
+		}
+	}
+	if zone := metadata["zone"]; strings.LastIndex(zone, "-") > 0 {
+		metadata["region"] = zone[:strings.LastIndex(zone, "-")]
+	}
+	return metadata, nil
+}
+
+func (p provider) fetchMetadata(path string) (string, bool, error) {
+	resp, err := p.get(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", false, nil
+	default:
+		return "", false, fmt.Errorf("failed fetching %q: HTTP status: %s", path, resp.Status)
+	}
+
+	value, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(string(value)), true, nil
+}
+
+// get requests path from the metadata server, which refuses requests lacking
+// the Metadata-Flavor header.
+func (p provider) get(path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", p.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return p.client.Do(req)
+}
+
+func (p provider) ShouldRetry() bool {
+	return p.shouldRetry
+}
+
+func (p *provider) BackoffDuration() time.Duration {
+	return util.ExpBackoff(&p.backoff, maxBackoff)
+}
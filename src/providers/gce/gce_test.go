@@ -0,0 +1,134 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gce
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/ignition/src/log"
+)
+
+func TestIsOnline(t *testing.T) {
+	type in struct {
+		status int
+		body   string
+	}
+	type out struct {
+		online    bool
+		retry     bool
+		rawConfig string
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{status: http.StatusOK, body: `{"ignitionVersion": 1}`},
+			out: out{online: true, retry: true, rawConfig: `{"ignitionVersion": 1}`},
+		},
+		{
+			in:  in{status: http.StatusNotFound},
+			out: out{online: false, retry: false},
+		},
+		{
+			in:  in{status: http.StatusServiceUnavailable},
+			out: out{online: false, retry: true},
+		},
+	}
+
+	for i, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/"+userdataPath {
+				http.Error(w, "bad request", http.StatusForbidden)
+				return
+			}
+			w.WriteHeader(test.in.status)
+			fmt.Fprint(w, test.in.body)
+		}))
+
+		p := provider{logger: log.NewTest(), shouldRetry: true, client: &http.Client{}, url: server.URL + "/"}
+		online := p.IsOnline()
+		got := out{online: online, retry: p.ShouldRetry(), rawConfig: string(p.rawConfig)}
+		if !reflect.DeepEqual(test.out, got) {
+			t.Errorf("#%d: bad result: want %+v, got %+v", i, test.out, got)
+		}
+		server.Close()
+	}
+}
+
+func TestIsOnlineTimeout(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	defer close(block)
+
+	p := provider{logger: log.NewTest(), shouldRetry: true, client: &http.Client{Timeout: 100 * time.Millisecond}, url: server.URL + "/"}
+	done := make(chan bool, 1)
+	go func() { done <- p.IsOnline() }()
+	select {
+	case online := <-done:
+		if online {
+			t.Errorf("bad online: want false, got true")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("request to a hanging server never timed out")
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	values := map[string]string{
+		"instance/id":                      "1234",
+		"instance/machine-type":            "projects/42/machineTypes/n1-standard-1",
+		"instance/zone":                    "projects/42/zones/us-central1-f",
+		"instance/hostname":                "node1.c.project.internal",
+		"instance/network-interfaces/0/ip": "10.0.0.2",
+		"project/project-id":               "project",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value, ok := values[r.URL.Path[1:]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, value)
+	}))
+	defer server.Close()
+
+	p := provider{logger: log.NewTest(), client: &http.Client{}, url: server.URL + "/"}
+	metadata, err := p.Metadata()
+	if err != nil {
+		t.Fatalf("failed to fetch metadata: %v", err)
+	}
+	expected := map[string]string{
+		"instance_id":  "1234",
+		"machine_type": "n1-standard-1",
+		"zone":         "us-central1-f",
+		"region":       "us-central1",
+		"hostname":     "node1.c.project.internal",
+		"local_ipv4":   "10.0.0.2",
+		"project_id":   "project",
+	}
+	if !reflect.DeepEqual(expected, metadata) {
+		t.Errorf("bad metadata: want %v, got %v", expected, metadata)
+	}
+}
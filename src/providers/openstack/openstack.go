@@ -0,0 +1,168 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The openstack provider reads the configuration from the user_data file of
+// an OpenStack config drive, the iso9660 or vfat filesystem labelled config-2.
+
+package openstack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/src/exec/util"
+	"github.com/coreos/ignition/src/log"
+	"github.com/coreos/ignition/src/providers"
+	putil "github.com/coreos/ignition/src/providers/util"
+)
+
+const (
+	name           = "openstack"
+	initialBackoff = 100 * time.Millisecond
+	maxBackoff     = 30 * time.Second
+	userdataPath   = "openstack/latest/user_data"
+)
+
+var (
+	// the label is written in upper case to vfat drives
+	configDriveLabels = []string{"config-2", "CONFIG-2"}
+	configDriveTypes  = []string{"iso9660", "vfat"}
+)
+
+func init() {
+	providers.Register(creator{})
+}
+
+type creator struct{}
+
+func (creator) Name() string {
+	return name
+}
+
+func (creator) Create(logger log.Logger, opts providers.Options) providers.Provider {
+	return &provider{
+		logger:      logger,
+		backoff:     initialBackoff,
+		shouldRetry: true,
+		strict:      opts.StrictConfig,
+	}
+}
+
+type provider struct {
+	logger      log.Logger
+	backoff     time.Duration
+	shouldRetry bool
+	rawConfig   []byte
+	strict      bool
+	devRoot     string // prefix for the device links, for testing.
+}
+
+func (provider) Name() string {
+	return name
+}
+
+func (p provider) FetchConfig() (config.Config, error) {
+	return providers.ParseConfig(p.rawConfig, p.strict)
+}
+
+func (p *provider) IsOnline() bool {
+	dev, ok := configDrive(p.devRoot)
+	if !ok {
+		// the drive is attached before boot, but udev may not have linked
+		// it yet, so keep trying until the provider times out
+		p.logger.Info("no config drive found")
+		return false
+	}
+
+	raw, found, err := p.readUserdata(dev)
+	if err != nil {
+		p.logger.Warning("failed reading config drive %q: %v", dev, err)
+		return false
+	}
+	if !found {
+		p.logger.Info("no user_data found on config drive %q", dev)
+		p.shouldRetry = false
+		return false
+	}
+
+	p.logger.Debug("successfully read config drive %q", dev)
+	p.rawConfig = raw
+	return true
+}
+
+// configDrive returns the link udev makes to the config drive under root, if
+// attached.
+func configDrive(root string) (string, bool) {
+	for _, label := range configDriveLabels {
+		dev := filepath.Join(root, util.DeviceLink(config.DevicePath("LABEL="+label)))
+		if _, err := os.Stat(dev); err == nil {
+			return dev, true
+		}
+	}
+	return "", false
+}
+
+// readUserdata mounts the config drive dev read-only in a temporary directory
+// and reads its user_data, which found reports the presence of.
+func (p *provider) readUserdata(dev string) (raw []byte, found bool, err error) {
+	mnt, err := ioutil.TempDir("", "ignition-configdrive")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.Remove(mnt)
+
+	if err := p.logger.LogOp(
+		func() error { return mountConfigDrive(dev, mnt) },
+		"mounting config drive %q at %q", dev, mnt,
+	); err != nil {
+		return nil, false, err
+	}
+	defer p.logger.LogOp(
+		func() error { return syscall.Unmount(mnt, 0) },
+		"unmounting config drive %q at %q", dev, mnt,
+	)
+
+	raw, err = ioutil.ReadFile(filepath.Join(mnt, userdataPath))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return raw, true, nil
+}
+
+// mountConfigDrive mounts dev at mnt read-only as whichever of the config
+// drive filesystem types it holds.
+func mountConfigDrive(dev, mnt string) error {
+	var err error
+	for _, typ := range configDriveTypes {
+		if err = syscall.Mount(dev, mnt, typ, syscall.MS_RDONLY, ""); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (p provider) ShouldRetry() bool {
+	return p.shouldRetry
+}
+
+func (p *provider) BackoffDuration() time.Duration {
+	return putil.ExpBackoff(&p.backoff, maxBackoff)
+}
@@ -0,0 +1,54 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openstack
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/ignition/src/log"
+)
+
+func TestIsOnlineLateLink(t *testing.T) {
+	root, err := ioutil.TempDir("", "ignition-openstack-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	p := provider{logger: log.NewTest(), shouldRetry: true, devRoot: root}
+	for i := 0; i < 3; i++ {
+		if p.IsOnline() {
+			t.Fatalf("#%d: bad online: want false, got true", i)
+		}
+		if !p.ShouldRetry() {
+			t.Fatalf("#%d: bad retry: want true, got false", i)
+		}
+	}
+
+	// udev links the drive once it has probed it
+	link := filepath.Join(root, "dev/disk/by-label/config-2")
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		t.Fatalf("failed to create link dir: %v", err)
+	}
+	if err := ioutil.WriteFile(link, nil, 0644); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+	if dev, ok := configDrive(p.devRoot); !ok || dev != link {
+		t.Errorf("bad config drive: want %q, got %q (%v)", link, dev, ok)
+	}
+}
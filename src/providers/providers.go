@@ -34,9 +34,35 @@ type Provider interface {
 	BackoffDuration() time.Duration
 }
 
+// MetadataProvider is implemented by providers which can describe the host
+// being provisioned (its instance id, region, addresses and so on). The
+// metadata is what file templates are rendered against.
+type MetadataProvider interface {
+	Metadata() (map[string]string, error)
+}
+
 type ProviderCreator interface {
 	Name() string
-	Create(logger log.Logger) Provider
+	Create(logger log.Logger, opts Options) Provider
+}
+
+// DefaultConfigFile is where the file provider looks for the config by default.
+const DefaultConfigFile = "/usr/share/oem/config.ign"
+
+// Options holds the engine settings which affect where providers look for
+// the config and how they parse it.
+type Options struct {
+	ConfigFile   string // path read by the file provider.
+	StrictConfig bool   // reject configs containing unknown keys.
+}
+
+// ParseConfig parses a raw config fetched by a provider, rejecting unknown
+// keys when strict is set.
+func ParseConfig(raw []byte, strict bool) (config.Config, error) {
+	if strict {
+		return config.ParseStrict(raw)
+	}
+	return config.Parse(raw)
 }
 
 var providers = registry.Create("providers")
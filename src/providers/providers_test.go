@@ -27,8 +27,8 @@ type mockProviderCreator struct {
 	provider Provider
 }
 
-func (c mockProviderCreator) Name() string               { return c.name }
-func (c mockProviderCreator) Create(log.Logger) Provider { return c.provider }
+func (c mockProviderCreator) Name() string                        { return c.name }
+func (c mockProviderCreator) Create(log.Logger, Options) Provider { return c.provider }
 
 func TestGet(t *testing.T) {
 	type in struct {
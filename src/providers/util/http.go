@@ -0,0 +1,30 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"net/http"
+	"time"
+)
+
+// requestTimeout bounds each request a provider makes, so that a server
+// which accepts the connection but never answers can't hang the boot. The
+// request is retried with backoff like any other failure.
+const requestTimeout = 10 * time.Second
+
+// NewHttpClient returns the client providers make their requests with.
+func NewHttpClient() *http.Client {
+	return &http.Client{Timeout: requestTimeout}
+}
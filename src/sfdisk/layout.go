@@ -0,0 +1,176 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sfdisk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// errNoTable is returned by readTable for devices without a partition table.
+var errNoTable = errors.New("no partition table")
+
+// table is the partition table found on the device, as dumped by sfdisk --json.
+type table struct {
+	Label      string              `json:"label"`
+	Partitions []existingPartition `json:"partitions"`
+}
+
+// existingPartition describes a partition found on the device.
+type existingPartition struct {
+	Node     string `json:"node"`
+	Start    uint64 `json:"start"` // 512-byte sectors
+	Size     uint64 `json:"size"`  // 512-byte sectors
+	Type     string `json:"type"`
+	Bootable bool   `json:"bootable"`
+}
+
+// nodeNumberRegexp extracts the partition number from a partition's node.
+var nodeNumberRegexp = regexp.MustCompile("[0-9]+$")
+
+// number returns the partition number of e, taken from its device node.
+func (e existingPartition) number() (int, error) {
+	return strconv.Atoi(nodeNumberRegexp.FindString(e.Node))
+}
+
+// Extent is where an existing partition lies on the device.
+type Extent struct {
+	Number int
+	Offset uint64 // 512-byte sectors
+	Length uint64 // 512-byte sectors
+}
+
+// Existing returns the extents of the partitions on the device which survive
+// the wiping and deletions of op, in the order the table lists them.
+// Partitions op creates over them aren't accounted for.
+func (op *Operation) Existing() ([]Extent, error) {
+	extents := []Extent{}
+	if op.wipe {
+		return extents, nil
+	}
+	t, err := op.readTable()
+	if err == errNoTable {
+		return extents, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	deleted := map[int]bool{}
+	for _, n := range op.dels {
+		deleted[n] = true
+	}
+	for _, e := range t.Partitions {
+		n, err := e.number()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse partition number of %q: %v", e.Node, err)
+		}
+		if !deleted[n] {
+			extents = append(extents, Extent{Number: n, Offset: e.Start, Length: e.Size})
+		}
+	}
+	return extents, nil
+}
+
+// Matches reports whether the partition table on the device is an MBR one
+// already containing exactly the partitions added to op, compared by number,
+// offset, size, type code and bootable flag. Partitions left to sfdisk's
+// discretion (an offset or size of 0) are only compared on the remaining
+// attributes.
+func (op *Operation) Matches() (bool, error) {
+	t, err := op.readTable()
+	if err == errNoTable {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return op.matches(t)
+}
+
+// matches reports whether t is an MBR table containing exactly the
+// partitions added to op, as described for Matches.
+func (op *Operation) matches(t table) (bool, error) {
+	if t.Label != "dos" || len(t.Partitions) != len(op.parts) {
+		return false, nil
+	}
+
+	existing := map[int]existingPartition{}
+	for _, e := range t.Partitions {
+		n, err := e.number()
+		if err != nil {
+			return false, fmt.Errorf("failed to parse partition number of %q: %v", e.Node, err)
+		}
+		existing[n] = e
+	}
+
+	for _, p := range op.parts {
+		e, ok := existing[p.Number]
+		if !ok {
+			return false, nil
+		}
+		if p.Offset != 0 && p.Offset != e.Start {
+			return false, nil
+		}
+		if p.Length != 0 && p.Length != e.Size {
+			return false, nil
+		}
+		if p.TypeCode != "" && !strings.EqualFold(p.TypeCode, strings.TrimPrefix(e.Type, "0x")) {
+			return false, nil
+		}
+		if p.Bootable != e.Bootable {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// readTable reads the partition table currently on the device. sfdisk runs
+// in the C locale, so its complaint about a missing table can be recognised.
+func (op *Operation) readTable() (table, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(op.path, "--json", op.dev)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), "does not contain a recognized partition table") {
+			return table{}, errNoTable
+		}
+		return table{}, fmt.Errorf("failed to dump table on %q: %v: %s", op.dev, err, strings.TrimSpace(stderr.String()))
+	}
+
+	t, err := parseTable(out)
+	if err != nil {
+		return table{}, fmt.Errorf("failed to parse table on %q: %v", op.dev, err)
+	}
+	return t, nil
+}
+
+// parseTable parses the output of sfdisk --json.
+func parseTable(out []byte) (table, error) {
+	var dump struct {
+		Table table `json:"partitiontable"`
+	}
+	if err := json.Unmarshal(out, &dump); err != nil {
+		return table{}, err
+	}
+	return dump.Table, nil
+}
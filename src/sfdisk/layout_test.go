@@ -0,0 +1,150 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sfdisk
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTable(t *testing.T) {
+	type in struct {
+		out string
+	}
+	type out struct {
+		table table
+		ok    bool
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in: in{out: `{
+   "partitiontable": {
+      "label": "dos",
+      "id": "0x5d4b2a1c",
+      "device": "/dev/sda",
+      "unit": "sectors",
+      "partitions": [
+         {"node": "/dev/sda1", "start": 2048, "size": 204800, "type": "ef", "bootable": true},
+         {"node": "/dev/sda2", "start": 206848, "size": 4096000, "type": "83"}
+      ]
+   }
+}`},
+			out: out{table: table{Label: "dos", Partitions: []existingPartition{
+				{Node: "/dev/sda1", Start: 2048, Size: 204800, Type: "ef", Bootable: true},
+				{Node: "/dev/sda2", Start: 206848, Size: 4096000, Type: "83"},
+			}}, ok: true},
+		},
+		{
+			in:  in{out: `{"partitiontable": {"label": "gpt", "device": "/dev/sdb"}}`},
+			out: out{table: table{Label: "gpt"}, ok: true},
+		},
+		{
+			in:  in{out: `Disk /dev/sda: 8 GiB`},
+			out: out{ok: false},
+		},
+	}
+
+	for i, test := range tests {
+		table, err := parseTable([]byte(test.in.out))
+		if got := (out{table: table, ok: err == nil}); !reflect.DeepEqual(test.out, got) {
+			t.Errorf("#%d: bad table: want %+v, got %+v", i, test.out, got)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	type in struct {
+		parts []Partition
+		table table
+	}
+	type out struct {
+		match bool
+		ok    bool
+	}
+
+	existing := table{Label: "dos", Partitions: []existingPartition{
+		{Node: "/dev/sda1", Start: 2048, Size: 204800, Type: "ef", Bootable: true},
+		{Node: "/dev/sda2", Start: 206848, Size: 4096000, Type: "83"},
+	}}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in: in{parts: []Partition{
+				{Number: 1, Offset: 2048, Length: 204800, TypeCode: "ef", Bootable: true},
+				{Number: 2, Offset: 206848, Length: 4096000, TypeCode: "83"},
+			}, table: existing},
+			out: out{match: true, ok: true},
+		},
+		{
+			// sizes and offsets left to sfdisk match whatever it chose
+			in: in{parts: []Partition{
+				{Number: 1, Length: 204800, TypeCode: "EF", Bootable: true},
+				{Number: 2},
+			}, table: existing},
+			out: out{match: true, ok: true},
+		},
+		{
+			in: in{parts: []Partition{
+				{Number: 1, Offset: 2048, Length: 204800, TypeCode: "ef"},
+				{Number: 2},
+			}, table: existing},
+			out: out{match: false, ok: true},
+		},
+		{
+			in: in{parts: []Partition{
+				{Number: 1, Length: 409600, Bootable: true},
+				{Number: 2},
+			}, table: existing},
+			out: out{match: false, ok: true},
+		},
+		{
+			in:  in{parts: []Partition{{Number: 1, Bootable: true}}, table: existing},
+			out: out{match: false, ok: true},
+		},
+		{
+			in: in{parts: []Partition{
+				{Number: 1, Bootable: true},
+				{Number: 3},
+			}, table: existing},
+			out: out{match: false, ok: true},
+		},
+		{
+			in:  in{parts: []Partition{}, table: table{Label: "gpt"}},
+			out: out{match: false, ok: true},
+		},
+		{
+			in:  in{parts: []Partition{{Number: 1}}, table: table{Label: "dos", Partitions: []existingPartition{{Node: "/dev/loop"}}}},
+			out: out{match: false, ok: false},
+		},
+	}
+
+	for i, test := range tests {
+		op := Begin(nil, "/dev/sda")
+		for _, p := range test.in.parts {
+			op.CreatePartition(p)
+		}
+		match, err := op.matches(test.in.table)
+		if got := (out{match: match, ok: err == nil}); !reflect.DeepEqual(test.out, got) {
+			t.Errorf("#%d: bad match: want %+v, got %+v (%v)", i, test.out, got, err)
+		}
+	}
+}
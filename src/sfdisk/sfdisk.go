@@ -0,0 +1,171 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sfdisk
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/coreos/ignition/src/log"
+)
+
+const (
+	sfdiskPath = "/sbin/sfdisk"
+
+	// mbrSectors is how many 512-byte sectors an MBR partition table can address.
+	mbrSectors = 1 << 32
+)
+
+// Operation lays out an MBR partition table, the counterpart of an sgdisk
+// operation for disks which can't use GPT.
+type Operation struct {
+	logger *log.Logger
+	path   string
+	dev    string
+	wipe   bool
+	dryRun bool
+	parts  []Partition
+	dels   []int
+}
+
+type Partition struct {
+	Number   int    // 1 to 4, only primary partitions are supported
+	Offset   uint64 // 512-byte sectors
+	Length   uint64 // 512-byte sectors, 0 fills the remaining space
+	TypeCode string // hex type code, sfdisk's default (83, linux) if empty
+	Bootable bool
+}
+
+// Begin begins an sfdisk operation
+func Begin(logger *log.Logger, dev string) *Operation {
+	return &Operation{logger: logger, path: sfdiskPath, dev: dev}
+}
+
+// Binary sets the sfdisk binary the operation runs, /sbin/sfdisk by default.
+func (op *Operation) Binary(path string) {
+	op.path = path
+}
+
+// CreatePartition adds the supplied partition to the list of partitions to be created as part of an operation.
+func (op *Operation) CreatePartition(p Partition) {
+	op.parts = append(op.parts, p)
+}
+
+// DeletePartition adds the numbered partition to the list of partitions to be
+// deleted, before any partitions are created, as part of an operation.
+func (op *Operation) DeletePartition(number int) {
+	op.dels = append(op.dels, number)
+}
+
+// WipeTable toggles if a new, empty table replaces the existing one first when commiting this operation.
+func (op *Operation) WipeTable(wipe bool) {
+	op.wipe = wipe
+}
+
+// DryRun toggles if commiting this operation only logs the commands which would be run.
+func (op *Operation) DryRun(dryRun bool) {
+	op.dryRun = dryRun
+}
+
+// Commit commits an partitioning operation. Each partition is created by
+// number with its own sfdisk run, since sfdisk scripts otherwise number
+// partitions in the order they're listed.
+func (op *Operation) Commit() error {
+	for _, p := range op.parts {
+		if p.Number < 1 || p.Number > 4 {
+			return fmt.Errorf("partition %d: mbr partitions must be numbered 1 to 4", p.Number)
+		}
+		if p.Offset+p.Length > mbrSectors {
+			return fmt.Errorf("partition %d: extends past the last sector addressable by mbr", p.Number)
+		}
+	}
+
+	wipe := op.wipe
+	if !wipe {
+		// sfdisk won't add partitions to a disk without a table, nor should
+		// it touch a GPT one
+		t, err := op.readTable()
+		if err == errNoTable {
+			wipe = true
+		} else if err != nil {
+			return err
+		} else if t.Label != "dos" {
+			return fmt.Errorf("%q holds a %s partition table, not mbr", op.dev, t.Label)
+		}
+	}
+
+	if wipe {
+		if err := op.run([]string{op.dev}, "label: dos\n", "creating mbr table on %q", op.dev); err != nil {
+			return fmt.Errorf("create table failed: %v", err)
+		}
+	}
+
+	if len(op.dels) != 0 && !wipe {
+		args := []string{"--delete", op.dev}
+		for _, n := range op.dels {
+			args = append(args, fmt.Sprintf("%d", n))
+		}
+		if err := op.run(args, "", "deleting %d partitions on %q", len(op.dels), op.dev); err != nil {
+			return fmt.Errorf("delete partitions failed: %v", err)
+		}
+	}
+
+	for _, p := range op.parts {
+		args := []string{fmt.Sprintf("--partno=%d", p.Number), op.dev}
+		if err := op.run(args, p.script(), "creating partition %d on %q", p.Number, op.dev); err != nil {
+			return fmt.Errorf("create partition %d failed: %v", p.Number, err)
+		}
+	}
+
+	return nil
+}
+
+// script returns the sfdisk script line describing p. Fields left out are
+// up to sfdisk: the first free sector, all the space following the start, and
+// the linux type.
+func (p Partition) script() string {
+	typ := p.TypeCode
+	if typ == "" {
+		typ = "83"
+	}
+	fields := []string{}
+	if p.Offset != 0 {
+		fields = append(fields, fmt.Sprintf("start=%d", p.Offset))
+	}
+	if p.Length != 0 {
+		fields = append(fields, fmt.Sprintf("size=%d", p.Length))
+	}
+	fields = append(fields, "type="+typ)
+	if p.Bootable {
+		fields = append(fields, "bootable")
+	}
+	return strings.Join(fields, ", ") + "\n"
+}
+
+// run runs sfdisk with args as a logged command, feeding it script, or only
+// logs it when op is a dry run. The kernel is left to reread the table once
+// the whole operation is done.
+func (op *Operation) run(args []string, script string, format string, a ...interface{}) error {
+	args = append([]string{"--no-reread"}, args...)
+	if op.dryRun {
+		op.logger.Info("[dryrun]   %s: %s %s <<< %q", fmt.Sprintf(format, a...), op.path, strings.Join(args, " "), script)
+		return nil
+	}
+
+	cmd := exec.Command(op.path, args...)
+	cmd.Stdin = strings.NewReader(script)
+	return op.logger.LogCmd(cmd, format, a...)
+}
@@ -0,0 +1,88 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sfdisk
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScript(t *testing.T) {
+	type in struct {
+		partition Partition
+	}
+	type out struct {
+		script string
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{partition: Partition{Number: 1}},
+			out: out{script: "type=83\n"},
+		},
+		{
+			in:  in{partition: Partition{Number: 1, Offset: 2048, Length: 4096, TypeCode: "82"}},
+			out: out{script: "start=2048, size=4096, type=82\n"},
+		},
+		{
+			in:  in{partition: Partition{Number: 2, Length: 4096, TypeCode: "ef", Bootable: true}},
+			out: out{script: "size=4096, type=ef, bootable\n"},
+		},
+		{
+			in:  in{partition: Partition{Number: 3, Offset: 6144}},
+			out: out{script: "start=6144, type=83\n"},
+		},
+	}
+
+	for i, test := range tests {
+		script := test.in.partition.script()
+		if !reflect.DeepEqual(test.out.script, script) {
+			t.Errorf("#%d: bad script: want %q, got %q", i, test.out.script, script)
+		}
+	}
+}
+
+func TestTypeCode(t *testing.T) {
+	type in struct {
+		typ string
+	}
+	type out struct {
+		code string
+		ok   bool
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{in: in{typ: ""}, out: out{code: "", ok: true}},
+		{in: in{typ: "83"}, out: out{code: "83", ok: true}},
+		{in: in{typ: "EF"}, out: out{code: "ef", ok: true}},
+		{in: in{typ: "swap"}, out: out{code: "82", ok: true}},
+		{in: in{typ: "RAID"}, out: out{code: "fd", ok: true}},
+		{in: in{typ: "8300"}, out: out{ok: false}},
+		{in: in{typ: "windows"}, out: out{ok: false}},
+	}
+
+	for i, test := range tests {
+		code, err := TypeCode(test.in.typ)
+		if got := (out{code: code, ok: err == nil}); !reflect.DeepEqual(test.out, got) {
+			t.Errorf("#%d: bad type code: want %+v, got %+v", i, test.out, got)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sfdisk
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// partitionTypes maps the common partition type names to their MBR type codes.
+var partitionTypes = map[string]string{
+	"linux": "83",
+	"swap":  "82",
+	"efi":   "ef",
+	"raid":  "fd",
+	"lvm":   "8e",
+}
+
+var codeRegexp = regexp.MustCompile("^[[:xdigit:]]{2}$")
+
+// TypeCode returns the MBR type code of typ, which is either a type code,
+// returned in lower case, or one of the common names in partitionTypes. An
+// empty typ is left empty, so sfdisk applies its default.
+func TypeCode(typ string) (string, error) {
+	if typ == "" || codeRegexp.MatchString(typ) {
+		return strings.ToLower(typ), nil
+	}
+	if code, ok := partitionTypes[strings.ToLower(typ)]; ok {
+		return code, nil
+	}
+	return "", fmt.Errorf("unknown mbr partition type %q", typ)
+}
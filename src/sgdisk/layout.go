@@ -0,0 +1,239 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sgdisk
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// existingPartition describes a partition found on the device.
+type existingPartition struct {
+	Number   int
+	Offset   uint64 // 512-byte sectors
+	Length   uint64 // 512-byte sectors
+	Label    string
+	TypeGUID string
+	GUID     string
+}
+
+// Extent is where an existing partition lies on the device.
+type Extent struct {
+	Number int
+	Offset uint64 // 512-byte sectors
+	Length uint64 // 512-byte sectors
+}
+
+// Existing returns the extents of the partitions on the device which survive
+// the wiping and deletions of op, ordered by number. Partitions op creates
+// over them aren't accounted for.
+func (op *Operation) Existing() ([]Extent, error) {
+	existing, err := op.readPartitions()
+	if err != nil {
+		return nil, err
+	}
+	if op.wipe {
+		existing = op.preserved(existing)
+	}
+	for _, n := range op.dels {
+		delete(existing, n)
+	}
+
+	extents := []Extent{}
+	for _, e := range existing {
+		extents = append(extents, Extent{Number: e.Number, Offset: e.Offset, Length: e.Length})
+	}
+	sort.Slice(extents, func(i, j int) bool { return extents[i].Number < extents[j].Number })
+	return extents, nil
+}
+
+// Matches reports whether the partition table on the device already contains
+// exactly the partitions added to op, compared by number, offset, size and
+// type GUID. Partitions left to sgdisk's discretion (an offset or size of 0)
+// are only compared on the remaining attributes.
+func (op *Operation) Matches() (bool, error) {
+	existing, err := op.readPartitions()
+	if err != nil {
+		return false, err
+	}
+	// preserved partitions are expected to be there, whatever op creates
+	for n := range op.preserved(existing) {
+		if !op.creates(n) {
+			delete(existing, n)
+		}
+	}
+	// as are the expected ones, which Verify checks
+	for _, p := range op.expects {
+		delete(existing, p.Number)
+	}
+	if len(existing) != len(op.parts) {
+		return false, nil
+	}
+
+	for _, p := range op.parts {
+		e, ok := existing[p.Number]
+		if !ok {
+			return false, nil
+		}
+		if p.Offset != 0 && p.Offset != e.Offset {
+			return false, nil
+		}
+		if p.Length != 0 && p.Length != e.Length {
+			return false, nil
+		}
+		if p.TypeGUID != "" && !strings.EqualFold(p.TypeGUID, e.TypeGUID) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Verify returns an error unless each of the partitions passed to
+// ExpectPartition is on the device, compared by number, offset, size, label,
+// type GUID and GUID. Fields left empty or 0 match anything.
+func (op *Operation) Verify() error {
+	if len(op.expects) == 0 {
+		return nil
+	}
+	existing, err := op.readPartitions()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range op.expects {
+		e, ok := existing[p.Number]
+		if !ok {
+			return fmt.Errorf("partition %d not found on %q", p.Number, op.dev)
+		}
+		mismatch := func(what string, want, got interface{}) error {
+			return fmt.Errorf("partition %d on %q has %s %v, expected %v", p.Number, op.dev, what, got, want)
+		}
+		switch {
+		case p.Offset != 0 && p.Offset != e.Offset:
+			return mismatch("start", p.Offset, e.Offset)
+		case p.Length != 0 && p.Length != e.Length:
+			return mismatch("size", p.Length, e.Length)
+		case p.Label != "" && p.Label != e.Label:
+			return mismatch("label", fmt.Sprintf("%q", p.Label), fmt.Sprintf("%q", e.Label))
+		case p.TypeGUID != "" && !strings.EqualFold(p.TypeGUID, e.TypeGUID):
+			return mismatch("type guid", p.TypeGUID, e.TypeGUID)
+		case p.GUID != "" && !strings.EqualFold(p.GUID, e.GUID):
+			return mismatch("guid", p.GUID, e.GUID)
+		}
+	}
+	return nil
+}
+
+// preserved returns those of the existing partitions matching one of the
+// selectors passed to Preserve, keyed by number.
+func (op *Operation) preserved(existing map[int]existingPartition) map[int]existingPartition {
+	kept := map[int]existingPartition{}
+	for n, e := range existing {
+		for _, sel := range op.preserves {
+			if sel.matches(e) {
+				kept[n] = e
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// creates reports whether op creates the numbered partition.
+func (op *Operation) creates(number int) bool {
+	for _, p := range op.parts {
+		if p.Number == number {
+			return true
+		}
+	}
+	return false
+}
+
+// readPartitions reads the partitions currently on the device, keyed by number.
+func (op *Operation) readPartitions() (map[int]existingPartition, error) {
+	out, err := exec.Command(sgdiskPath, "--print", op.dev).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to print table on %q: %v", op.dev, err)
+	}
+
+	// the partition list follows the "Number  Start (sector) ..." header
+	parts := map[int]existingPartition{}
+	listing := false
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "Number" {
+			listing = true
+			continue
+		}
+		if !listing {
+			continue
+		}
+
+		number, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		p, err := op.readPartition(number)
+		if err != nil {
+			return nil, err
+		}
+		parts[number] = p
+	}
+	return parts, nil
+}
+
+// readPartition reads the details of the numbered partition on the device.
+func (op *Operation) readPartition(number int) (existingPartition, error) {
+	p := existingPartition{Number: number}
+
+	out, err := exec.Command(sgdiskPath, fmt.Sprintf("--info=%d", number), op.dev).Output()
+	if err != nil {
+		return p, fmt.Errorf("failed to read partition %d on %q: %v", number, op.dev, err)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		fields := strings.Fields(kv[1])
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch kv[0] {
+		case "Partition GUID code":
+			p.TypeGUID = fields[0]
+		case "Partition unique GUID":
+			p.GUID = fields[0]
+		case "Partition name":
+			p.Label = strings.Trim(strings.TrimSpace(kv[1]), "'")
+		case "First sector":
+			p.Offset, err = strconv.ParseUint(fields[0], 10, 64)
+		case "Partition size":
+			p.Length, err = strconv.ParseUint(fields[0], 10, 64)
+		}
+		if err != nil {
+			return p, fmt.Errorf("failed to parse %q for partition %d on %q: %v", kv[0], number, op.dev, err)
+		}
+	}
+	return p, nil
+}
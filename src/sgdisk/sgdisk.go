@@ -17,30 +17,70 @@ package sgdisk
 import (
 	"fmt"
 	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/coreos/ignition/src/log"
 )
 
-const sgdiskPath = "/sbin/sgdisk"
+const (
+	sgdiskPath = "/sbin/sgdisk"
+
+	// defaults for retrying commands which fail because the device is busy
+	defaultBusyAttempts = 5
+	busyRetryDelay      = time.Second
+)
+
+// busyRegexp matches how sgdisk reports EBUSY from the kernel: through
+// strerror(3), or through the bare errno 16 when it fails to open the device.
+var busyRegexp = regexp.MustCompile(`(?i)device or resource busy|errno is 16\b|error 16\b`)
 
 type Operation struct {
 	logger *log.Logger
+	path   string
 	dev    string
 	wipe   bool
+	dryRun bool
 	parts  []Partition
+	dels   []int
+
+	busyAttempts int
+	preserves    []Selector
+	expects      []Partition
 }
 
 type Partition struct {
-	Number   int
-	Offset   uint64 // 512-byte sectors
-	Length   uint64 // 512-byte sectors
+	Number     int
+	Offset     uint64 // 512-byte sectors
+	Length     uint64 // 512-byte sectors, 0 fills the remaining space
+	Label      string
+	TypeGUID   string
+	GUID       string
+	Attributes []uint // GPT attribute bits to set
+}
+
+// Selector picks out existing partitions by label, type GUID or both. Empty
+// fields match anything.
+type Selector struct {
 	Label    string
 	TypeGUID string
 }
 
+func (s Selector) matches(e existingPartition) bool {
+	if s.Label != "" && s.Label != e.Label {
+		return false
+	}
+	if s.TypeGUID != "" && !strings.EqualFold(s.TypeGUID, e.TypeGUID) {
+		return false
+	}
+	return true
+}
+
 // Begin begins an sgdisk operation
 func Begin(logger *log.Logger, dev string) *Operation {
-	return &Operation{logger: logger, dev: dev}
+	return &Operation{logger: logger, path: sgdiskPath, dev: dev, busyAttempts: defaultBusyAttempts}
 }
 
 // CreatePartition adds the supplied partition to the list of partitions to be created as part of an operation.
@@ -49,35 +89,194 @@ func (op *Operation) CreatePartition(p Partition) {
 	op.parts = append(op.parts, p)
 }
 
+// DeletePartition adds the numbered partition to the list of partitions to be
+// deleted, before any partitions are created, as part of an operation.
+func (op *Operation) DeletePartition(number int) {
+	op.dels = append(op.dels, number)
+}
+
+// Preserve protects the existing partitions matching sel from the operation:
+// wiping the table deletes every other partition instead, and deleting or
+// creating a partition over a preserved one fails the commit.
+func (op *Operation) Preserve(sel Selector) {
+	op.preserves = append(op.preserves, sel)
+}
+
+// ExpectPartition adds p to the partitions which must already exist, as
+// checked by Verify, rather than be created.
+func (op *Operation) ExpectPartition(p Partition) {
+	op.expects = append(op.expects, p)
+}
+
 // WipeTable toggles if the table is to be wiped first when commiting this operation.
 func (op *Operation) WipeTable(wipe bool) {
 	op.wipe = wipe
 }
 
+// DryRun toggles if commiting this operation only logs the commands which would be run.
+func (op *Operation) DryRun(dryRun bool) {
+	op.dryRun = dryRun
+}
+
+// BusyAttempts sets how many times each sgdisk command is attempted when it
+// fails because the device is busy. Other failures are never retried.
+func (op *Operation) BusyAttempts(attempts int) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	op.busyAttempts = attempts
+}
+
 // Commit commits an partitioning operation.
 func (op *Operation) Commit() error {
-	if op.wipe {
-		cmd := exec.Command(sgdiskPath, "--zap-all", op.dev)
-		if err := op.logger.LogCmd(cmd, "wiping table on %q", op.dev); err != nil {
-			return fmt.Errorf("wipe failed: %v")
+	// check attributes up front so the device is left untouched on failure
+	for _, p := range op.parts {
+		for _, bit := range p.Attributes {
+			if bit > 63 {
+				return fmt.Errorf("partition %d: invalid attribute bit %d", p.Number, bit)
+			}
+		}
+	}
+
+	dels := op.dels
+	if len(op.preserves) != 0 {
+		existing, err := op.readPartitions()
+		if err != nil {
+			return err
+		}
+		preserved := op.preserved(existing)
+		if err := op.checkPreserved(preserved); err != nil {
+			return err
+		}
+		if op.wipe {
+			// wipe everything around the preserved partitions instead
+			dels = nil
+			for n := range existing {
+				if _, ok := preserved[n]; !ok {
+					dels = append(dels, n)
+				}
+			}
+			sort.Ints(dels)
+		}
+	}
+	wipe := op.wipe && len(op.preserves) == 0
+
+	// partitions to delete must exist, unless the whole table is going away
+	if len(op.dels) != 0 && !op.wipe {
+		existing, err := op.readPartitions()
+		if err != nil {
+			return err
+		}
+		for _, n := range op.dels {
+			if _, ok := existing[n]; !ok {
+				return fmt.Errorf("cannot delete partition %d on %q: no such partition", n, op.dev)
+			}
+		}
+	}
+
+	if wipe {
+		if err := op.run([]string{"--zap-all", op.dev}, "wiping table on %q", op.dev); err != nil {
+			return fmt.Errorf("wipe failed: %v", err)
+		}
+	}
+
+	if len(dels) != 0 && !wipe {
+		opts := []string{}
+		for _, n := range dels {
+			opts = append(opts, fmt.Sprintf("--delete=%d", n))
+		}
+		opts = append(opts, op.dev)
+		if err := op.run(opts, "deleting %d partitions on %q", len(dels), op.dev); err != nil {
+			return fmt.Errorf("delete partitions failed: %v", err)
 		}
 	}
 
 	if len(op.parts) != 0 {
 		opts := []string{}
 		for _, p := range op.parts {
-			opts = append(opts, fmt.Sprintf("--new=%d:%d:+%d", p.Number, p.Offset, p.Length))
+			if p.Length == 0 {
+				// an end of 0 is sgdisk's "rest of disk"
+				opts = append(opts, fmt.Sprintf("--new=%d:%d:0", p.Number, p.Offset))
+			} else {
+				opts = append(opts, fmt.Sprintf("--new=%d:%d:+%d", p.Number, p.Offset, p.Length))
+			}
 			opts = append(opts, fmt.Sprintf("--change-name=%d:%s", p.Number, p.Label))
 			if p.TypeGUID != "" {
-				opts = append(opts, fmt.Sprintf("--partition-guid=%d:%s", p.Number, p.TypeGUID))
+				opts = append(opts, fmt.Sprintf("--typecode=%d:%s", p.Number, p.TypeGUID))
+			}
+			if p.GUID != "" {
+				opts = append(opts, fmt.Sprintf("--partition-guid=%d:%s", p.Number, p.GUID))
+			}
+			for _, bit := range p.Attributes {
+				opts = append(opts, fmt.Sprintf("--attributes=%d:set:%d", p.Number, bit))
 			}
 		}
 		opts = append(opts, op.dev)
-		cmd := exec.Command(sgdiskPath, opts...)
-		if err := op.logger.LogCmd(cmd, "creating %d partitions on %q", len(op.parts), op.dev); err != nil {
+		if err := op.run(opts, "creating %d partitions on %q", len(op.parts), op.dev); err != nil {
 			return fmt.Errorf("create partitions failed: %v", err)
 		}
 	}
 
 	return nil
 }
+
+// checkPreserved returns an error if op would delete or create a partition
+// over one of the preserved partitions.
+func (op *Operation) checkPreserved(preserved map[int]existingPartition) error {
+	for _, n := range op.dels {
+		if _, ok := preserved[n]; ok {
+			return fmt.Errorf("cannot delete partition %d on %q: partition is preserved", n, op.dev)
+		}
+	}
+	for _, p := range op.parts {
+		for _, e := range preserved {
+			if p.Number == e.Number {
+				return fmt.Errorf("partition %d collides with preserved partition %d on %q", p.Number, e.Number, op.dev)
+			}
+			if p.Offset == 0 {
+				// placed by sgdisk into free space
+				continue
+			}
+			end := p.Offset + p.Length
+			if p.Length == 0 {
+				// fills from its offset, so can only collide at the offset
+				end = p.Offset + 1
+			}
+			if p.Offset < e.Offset+e.Length && e.Offset < end {
+				return fmt.Errorf("partition %d collides with preserved partition %d on %q", p.Number, e.Number, op.dev)
+			}
+		}
+	}
+	return nil
+}
+
+// run runs sgdisk with args as a logged command, or only logs it when op is a
+// dry run. Attempts failing because the device is busy are retried up to
+// op.busyAttempts times.
+func (op *Operation) run(args []string, format string, a ...interface{}) error {
+	if op.dryRun {
+		op.logger.Info("[dryrun]   %s: %s %s", fmt.Sprintf(format, a...), op.path, strings.Join(args, " "))
+		return nil
+	}
+
+	var err error
+	for attempt := 1; attempt <= op.busyAttempts; attempt++ {
+		cmd := exec.Command(op.path, args...)
+		err = op.logger.LogCmd(cmd, "%s (attempt %d)", fmt.Sprintf(format, a...), attempt)
+		if err == nil || !isBusy(err) {
+			return err
+		}
+		if attempt < op.busyAttempts {
+			op.logger.Warning("%q is busy, retrying in %v", op.dev, busyRetryDelay)
+			time.Sleep(busyRetryDelay)
+		}
+	}
+	return err
+}
+
+// isBusy reports whether err from LogCmd was caused by the device being busy.
+// sgdisk reports EBUSY from the kernel only in its output, which LogCmd
+// quotes into err.
+func isBusy(err error) bool {
+	return busyRegexp.MatchString(err.Error())
+}
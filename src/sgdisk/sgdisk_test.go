@@ -0,0 +1,284 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sgdisk
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/ignition/src/log"
+)
+
+func TestSelectorMatches(t *testing.T) {
+	type in struct {
+		sel  Selector
+		part existingPartition
+	}
+	type out struct {
+		match bool
+	}
+
+	root := existingPartition{Number: 9, Label: "ROOT", TypeGUID: "3884DD41-8582-4404-B9A8-E9B84F2DF50E"}
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{sel: Selector{}, part: root},
+			out: out{match: true},
+		},
+		{
+			in:  in{sel: Selector{Label: "ROOT"}, part: root},
+			out: out{match: true},
+		},
+		{
+			in:  in{sel: Selector{Label: "root"}, part: root},
+			out: out{match: false},
+		},
+		{
+			in:  in{sel: Selector{TypeGUID: "3884dd41-8582-4404-b9a8-e9b84f2df50e"}, part: root},
+			out: out{match: true},
+		},
+		{
+			in:  in{sel: Selector{Label: "ROOT", TypeGUID: "0FC63DAF-8483-4772-8E79-3D69D8477DE4"}, part: root},
+			out: out{match: false},
+		},
+		{
+			in:  in{sel: Selector{Label: "OEM", TypeGUID: "3884DD41-8582-4404-B9A8-E9B84F2DF50E"}, part: root},
+			out: out{match: false},
+		},
+	}
+
+	for i, test := range tests {
+		match := test.in.sel.matches(test.in.part)
+		if match != test.out.match {
+			t.Errorf("#%d: bad match: want %t, got %t", i, test.out.match, match)
+		}
+	}
+}
+
+func TestPreserved(t *testing.T) {
+	type in struct {
+		preserves []Selector
+	}
+	type out struct {
+		numbers []int
+	}
+
+	existing := map[int]existingPartition{
+		1: {Number: 1, Label: "EFI-SYSTEM", TypeGUID: "C12A7328-F81F-11D2-BA4B-00A0C93EC93B"},
+		6: {Number: 6, Label: "OEM", TypeGUID: "0FC63DAF-8483-4772-8E79-3D69D8477DE4"},
+		9: {Number: 9, Label: "ROOT", TypeGUID: "0FC63DAF-8483-4772-8E79-3D69D8477DE4"},
+	}
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{preserves: nil},
+			out: out{numbers: []int{}},
+		},
+		{
+			in:  in{preserves: []Selector{{Label: "OEM"}}},
+			out: out{numbers: []int{6}},
+		},
+		{
+			in:  in{preserves: []Selector{{TypeGUID: "0fc63daf-8483-4772-8e79-3d69d8477de4"}}},
+			out: out{numbers: []int{6, 9}},
+		},
+		{
+			in:  in{preserves: []Selector{{Label: "EFI-SYSTEM"}, {Label: "ROOT"}, {Label: "USR-A"}}},
+			out: out{numbers: []int{1, 9}},
+		},
+	}
+
+	for i, test := range tests {
+		op := Operation{dev: "/dev/sda", preserves: test.in.preserves}
+		preserved := op.preserved(existing)
+		numbers := []int{}
+		for _, n := range []int{1, 6, 9} {
+			if _, ok := preserved[n]; ok {
+				numbers = append(numbers, n)
+			}
+		}
+		if !reflect.DeepEqual(test.out.numbers, numbers) {
+			t.Errorf("#%d: bad preserved partitions: want %v, got %v", i, test.out.numbers, numbers)
+		}
+	}
+}
+
+func TestCheckPreserved(t *testing.T) {
+	type in struct {
+		parts []Partition
+		dels  []int
+	}
+	type out struct {
+		err error
+	}
+
+	preserved := map[int]existingPartition{
+		9: {Number: 9, Offset: 4096, Length: 2048, Label: "ROOT"},
+	}
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{dels: []int{1}, parts: []Partition{{Number: 1, Offset: 6144, Length: 2048}}},
+			out: out{},
+		},
+		{
+			in:  in{dels: []int{9}},
+			out: out{err: errors.New(`cannot delete partition 9 on "/dev/sda": partition is preserved`)},
+		},
+		{
+			in:  in{parts: []Partition{{Number: 9}}},
+			out: out{err: errors.New(`partition 9 collides with preserved partition 9 on "/dev/sda"`)},
+		},
+		{
+			in:  in{parts: []Partition{{Number: 1, Offset: 2048, Length: 4096}}},
+			out: out{err: errors.New(`partition 1 collides with preserved partition 9 on "/dev/sda"`)},
+		},
+		{
+			in:  in{parts: []Partition{{Number: 1, Offset: 2048, Length: 2048}}},
+			out: out{},
+		},
+		{
+			in:  in{parts: []Partition{{Number: 1, Offset: 5000, Length: 0}}},
+			out: out{err: errors.New(`partition 1 collides with preserved partition 9 on "/dev/sda"`)},
+		},
+		{
+			in:  in{parts: []Partition{{Number: 1, Offset: 0, Length: 0}}},
+			out: out{},
+		},
+	}
+
+	for i, test := range tests {
+		op := Operation{dev: "/dev/sda", parts: test.in.parts, dels: test.in.dels}
+		err := op.checkPreserved(preserved)
+		if !reflect.DeepEqual(test.out.err, err) {
+			t.Errorf("#%d: bad error: want %v, got %v", i, test.out.err, err)
+		}
+	}
+}
+
+func TestIsBusy(t *testing.T) {
+	type in struct {
+		stderr string
+	}
+	type out struct {
+		busy bool
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{stderr: "Unable to open device '/dev/sda' for writing! Errno is 16! Aborting write!\n"},
+			out: out{busy: true},
+		},
+		{
+			in:  in{stderr: "Warning: The kernel is still using the old partition table.\nThe new table will be used at the next reboot.\nError 16 calling ioctl()\n"},
+			out: out{busy: true},
+		},
+		{
+			in:  in{stderr: "Problem opening /dev/sda for writing! Error is Device or resource busy\n"},
+			out: out{busy: true},
+		},
+		{
+			in:  in{stderr: "Problem opening /dev/sdz for reading! Error is 2.\nThe specified file does not exist!\n"},
+			out: out{busy: false},
+		},
+		{
+			in:  in{stderr: "Could not create partition 1 from 34 to 2047\nError encountered; not saving changes.\n"},
+			out: out{busy: false},
+		},
+		{
+			// errno 160 isn't EBUSY
+			in:  in{stderr: "Errno is 160!\n"},
+			out: out{busy: false},
+		},
+	}
+
+	for i, test := range tests {
+		// as LogCmd quotes it
+		err := fmt.Errorf("exit status 4: Stdout: %q Stderr: %q", "", test.in.stderr)
+		if busy := isBusy(err); busy != test.out.busy {
+			t.Errorf("#%d: bad busy: want %t, got %t", i, test.out.busy, busy)
+		}
+	}
+}
+
+func TestRunBusyAttempts(t *testing.T) {
+	type in struct {
+		stderr   string
+		attempts int
+	}
+	type out struct {
+		runs int
+	}
+
+	tests := []struct {
+		in  in
+		out out
+	}{
+		{
+			in:  in{stderr: "Error encountered; not saving changes.", attempts: 3},
+			out: out{runs: 1},
+		},
+		{
+			in:  in{stderr: "Unable to open device '/dev/sda' for writing! Errno is 16!", attempts: 2},
+			out: out{runs: 2},
+		},
+	}
+
+	for i, test := range tests {
+		dir, err := ioutil.TempDir("", "ignition-sgdisk")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		// fails every time, counting its runs
+		runs := filepath.Join(dir, "runs")
+		path := filepath.Join(dir, "sgdisk")
+		script := fmt.Sprintf("#!/bin/sh\necho run >> %s\necho %q >&2\nexit 4\n", runs, test.in.stderr)
+		if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		logger := log.NewTest()
+		op := Begin(&logger, "/dev/sda")
+		op.path = path
+		op.BusyAttempts(test.in.attempts)
+		if err := op.run([]string{"--zap-all", "/dev/sda"}, "wiping table"); err == nil {
+			t.Errorf("#%d: failing command succeeded", i)
+		}
+
+		out, err := ioutil.ReadFile(runs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(out), "run"); n != test.out.runs {
+			t.Errorf("#%d: bad runs: want %d, got %d", i, test.out.runs, n)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sgdisk
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// partitionTypes maps the common partition type names to their GPT type GUIDs.
+var partitionTypes = map[string]string{
+	"linux": "0FC63DAF-8483-4772-8E79-3D69D8477DE4",
+	"swap":  "0657FD6D-A4AB-43C4-84E5-0933C84B4F4F",
+	"efi":   "C12A7328-F81F-11D2-BA4B-00A0C93EC93B",
+	"raid":  "A19D880F-05FC-4D3B-A006-743F0F84911E",
+	"lvm":   "E6D6D379-F507-44C2-A23C-238F2A3DF928",
+}
+
+var guidRegexp = regexp.MustCompile("^[[:xdigit:]]{8}-[[:xdigit:]]{4}-[[:xdigit:]]{4}-[[:xdigit:]]{4}-[[:xdigit:]]{12}$")
+
+// TypeGUID returns the GPT type GUID of typ, which is either a type GUID,
+// returned as is, or one of the common names in partitionTypes. An empty typ
+// is left empty, so sgdisk applies its default.
+func TypeGUID(typ string) (string, error) {
+	if typ == "" || guidRegexp.MatchString(typ) {
+		return typ, nil
+	}
+	if guid, ok := partitionTypes[strings.ToLower(typ)]; ok {
+		return guid, nil
+	}
+	return "", fmt.Errorf("unknown partition type %q", typ)
+}
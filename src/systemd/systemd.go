@@ -16,21 +16,35 @@ package systemd
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/coreos/ignition/third_party/github.com/coreos/go-systemd/dbus"
 	"github.com/coreos/ignition/third_party/github.com/coreos/go-systemd/unit"
 )
 
-// WaitOnDevices waits for the devices named in devs to be plugged before returning.
-func WaitOnDevices(devs []string, stage string) error {
+// MissingDevicesError lists the devices WaitOnDevices gave up on.
+type MissingDevicesError struct {
+	Devices []string
+}
+
+func (e MissingDevicesError) Error() string {
+	return fmt.Sprintf("devices never appeared: %s", strings.Join(e.Devices, ", "))
+}
+
+// WaitOnDevices waits for the devices named in devs to be plugged before
+// returning. If timeout is nonzero and elapses first, or if systemd gives up
+// on any of the devices, a MissingDevicesError names the ones still absent.
+func WaitOnDevices(devs []string, stage string, timeout time.Duration) error {
 	conn, err := dbus.New()
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
 
 	devUnits := []string{}
 	for _, d := range devs {
-		devUnits = append(devUnits, unit.UnitNamePathEscape(d)+".device")
+		devUnits = append(devUnits, deviceUnit(d))
 	}
 
 	unitName := unit.UnitNameEscape(fmt.Sprintf("ignition_%s.service", stage))
@@ -40,15 +54,51 @@ func WaitOnDevices(devs []string, stage string) error {
 		dbus.PropRequires(devUnits...),
 	}
 
-	res := make(chan string)
+	// buffered so the job's completion isn't stuck on a receiver that gave up
+	res := make(chan string, 1)
 	if _, err = conn.StartTransientUnit(unitName, "replace", props, res); err != nil {
 		return fmt.Errorf("failed creating transient unit %s: %v", unitName, err)
 	}
-	s := <-res
 
-	if s != "done" {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case s := <-res:
+		if s == "done" {
+			return nil
+		}
+		if missing := missingDevices(conn, devs); len(missing) != 0 {
+			return MissingDevicesError{Devices: missing}
+		}
 		return fmt.Errorf("transient unit %s %s", unitName, s)
+	case <-expired:
+		// don't leave the job queued behind devices which may never appear
+		conn.StopUnit(unitName, "replace", nil)
+		if missing := missingDevices(conn, devs); len(missing) != 0 {
+			return MissingDevicesError{Devices: missing}
+		}
+		return fmt.Errorf("timed out after %v waiting for %v", timeout, devs)
 	}
+}
+
+// deviceUnit returns the name of the systemd unit representing dev.
+func deviceUnit(dev string) string {
+	return unit.UnitNamePathEscape(dev) + ".device"
+}
 
-	return nil
+// missingDevices returns those of devs which systemd doesn't consider plugged.
+func missingDevices(conn *dbus.Conn, devs []string) []string {
+	missing := []string{}
+	for _, d := range devs {
+		prop, err := conn.GetUnitProperty(deviceUnit(d), "ActiveState")
+		if err != nil || prop.Value.Value() != "active" {
+			missing = append(missing, d)
+		}
+	}
+	return missing
 }